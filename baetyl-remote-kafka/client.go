@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"sync"
+	"time"
+
 	"github.com/baetyl/baetyl/logger"
 	"github.com/baetyl/baetyl/utils"
 	"github.com/segmentio/kafka-go"
@@ -9,14 +12,41 @@ import (
 
 type readHandler func(msg kafka.Message) error
 
+// producer writes messages to kafka, implemented by *kafka.Writer
+type producer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// consumer reads messages from kafka, implemented by *kafka.Reader
+type consumer interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// the factories of producer and consumer, replaced by fakes in tests
+var (
+	newProducer = func(address []string, cfg Rule) producer {
+		return newKafkaWriter(address, cfg)
+	}
+	newConsumer = func(address []string, cfg Rule) consumer {
+		return newKafkaReader(address, cfg)
+	}
+)
+
 type client struct {
-	writer  *kafka.Writer
-	reader  *kafka.Reader
-	tomb    utils.Tomb
-	handler readHandler
-	log     logger.Logger
-	ctx     context.Context
-	cancel  context.CancelFunc
+	writer   producer
+	reader   consumer
+	tomb     utils.Tomb
+	routines flight // the goroutines of tomb
+	handler  readHandler
+	drain    time.Duration
+	log      logger.Logger
+	ctx      context.Context // of writing and committing, canceled when closed
+	cancel   context.CancelFunc
+	fetch    context.Context // of fetching, canceled when closing
+	stop     context.CancelFunc
 }
 
 func newKafkaWriter(address []string, cfg Rule) *kafka.Writer {
@@ -41,18 +71,22 @@ func newKafkaReader(address []string, cfg Rule) *kafka.Reader {
 
 func newClient(address []string, cfg Rule, log logger.Logger) *client {
 	ctx, cancel := context.WithCancel(context.Background())
+	fetch, stop := context.WithCancel(ctx)
 	c := &client{
 		ctx:    ctx,
 		cancel: cancel,
+		fetch:  fetch,
+		stop:   stop,
+		drain:  cfg.Drain,
 		log:    log,
 	}
 	if cfg.Type == "to" {
-		c.writer = newKafkaWriter(address, cfg)
+		c.writer = newProducer(address, cfg)
 	} else if cfg.Type == "from" {
 		if cfg.Remote.GroupID == "" {
 			return nil
 		}
-		c.reader = newKafkaReader(address, cfg)
+		c.reader = newConsumer(address, cfg)
 	}
 	return c
 }
@@ -64,23 +98,25 @@ func (c *client) WriteMessages(msg kafka.Message) error {
 	return nil
 }
 
+// readMessage commits the offset of each message after handled
 func (c *client) readMessage() error {
 	for {
-		select {
-		case <-c.dying():
-			return nil
-		default:
-			msg, err := c.reader.ReadMessage(c.ctx)
-			if err != nil {
-				c.log.Errorf("failed to read kafka message: %s", err.Error())
-				continue
+		msg, err := c.reader.FetchMessage(c.fetch)
+		if err != nil {
+			if c.fetch.Err() != nil {
+				return nil
 			}
-			if c.handler != nil {
-				if err = c.handler(msg); err != nil {
-					c.log.Errorf("failed to handle mqtt msg")
-				}
+			c.log.Errorf("failed to read kafka message: %s", err.Error())
+			continue
+		}
+		if c.handler != nil {
+			if err = c.handler(msg); err != nil {
+				c.log.Errorf("failed to handle mqtt msg")
 			}
 		}
+		if err = c.reader.CommitMessages(c.ctx, msg); err != nil {
+			c.log.Errorf("failed to commit kafka message: %s", err.Error())
+		}
 	}
 }
 
@@ -89,24 +125,27 @@ func (c *client) SetReadHandler(handler readHandler) {
 }
 
 func (c *client) StartRead() error {
-	go func() {
-		select {
-		case <-c.dying():
-			c.cancel()
-		}
-	}()
 	if c.reader != nil {
-		return c.tomb.Go(c.readMessage)
+		return goroutine(&c.tomb, &c.routines, c.readMessage)
 	}
 	return nil
 }
 
-func (c *client) dying() <-chan struct{} {
-	return c.tomb.Dying()
+// Close stops fetching, waits for the message being handled to be committed until drain timeout, then closes reader and writer
+func (c *client) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.drain)
+	defer cancel()
+	c.close(ctx)
 }
 
-func (c *client) Close() {
+// close closes the client with the deadline of ctx, which is shared with the ruler draining
+func (c *client) close(ctx context.Context) {
+	c.stop()
 	c.tomb.Kill(nil)
+	if !wait(ctx, c.routines.wait()) {
+		c.log.Warnf("drain timeout, kafka messages being handled not committed")
+	}
+	c.cancel()
 	c.tomb.Wait()
 	if c.reader != nil {
 		c.reader.Close()
@@ -115,3 +154,63 @@ func (c *client) Close() {
 		c.writer.Close()
 	}
 }
+
+// wait waits for done until ctx done, returns false if ctx done first
+func wait(ctx context.Context, done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// flight counts the works in flight, which are waited without a goroutine blocked after drain timeout
+type flight struct {
+	n    int
+	idle chan struct{} // closed once no work in flight
+	lock sync.Mutex
+}
+
+func (f *flight) add() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.n == 0 {
+		f.idle = make(chan struct{})
+	}
+	f.n++
+}
+
+func (f *flight) done() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.n--
+	if f.n == 0 {
+		close(f.idle)
+	}
+}
+
+// wait returns a channel closed once no work in flight
+func (f *flight) wait() <-chan struct{} {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.n == 0 {
+		idle := make(chan struct{})
+		close(idle)
+		return idle
+	}
+	return f.idle
+}
+
+// goroutine runs fn in tomb and counts it in flight until it returns
+func goroutine(t *utils.Tomb, f *flight, fn func() error) error {
+	f.add()
+	err := t.Go(func() error {
+		defer f.done()
+		return fn()
+	})
+	if err != nil {
+		f.done()
+	}
+	return err
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl/logger"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRule(typ, topic string) Rule {
+	var rule Rule
+	rule.Type = typ
+	rule.Hub.ClientID = "test"
+	rule.Remote.Name = "kafka"
+	rule.Remote.Topic = topic
+	rule.Remote.GroupID = "group"
+	return rule
+}
+
+func TestClientWrite(t *testing.T) {
+	b := newFakeBroker()
+	defer b.install()()
+
+	c := newClient([]string{"127.0.0.1:9092"}, newRule("to", "t1"), logger.WithField("test", "client"))
+	assert.NotNil(t, c)
+	assert.Nil(t, c.reader)
+	err := c.WriteMessages(kafka.Message{Key: []byte("k"), Value: []byte("v")})
+	assert.NoError(t, err)
+	msg, err := b.next("t1")
+	assert.NoError(t, err)
+	assert.Equal(t, "k", string(msg.Key))
+	assert.Equal(t, "v", string(msg.Value))
+
+	b.writeErr = fmt.Errorf("broker unavailable")
+	err = c.WriteMessages(kafka.Message{Value: []byte("v")})
+	assert.EqualError(t, err, "broker unavailable")
+
+	w := c.writer.(*fakeWriter)
+	c.Close()
+	assert.True(t, w.closed)
+}
+
+func TestClientRead(t *testing.T) {
+	b := newFakeBroker()
+	defer b.install()()
+
+	// group id is required to read
+	rule := newRule("from", "t2")
+	rule.Remote.GroupID = ""
+	c := newClient([]string{"127.0.0.1:9092"}, rule, logger.WithField("test", "client"))
+	assert.Nil(t, c)
+
+	c = newClient([]string{"127.0.0.1:9092"}, newRule("from", "t2"), logger.WithField("test", "client"))
+	assert.NotNil(t, c)
+	assert.Nil(t, c.writer)
+	// write is ignored without writer
+	assert.NoError(t, c.WriteMessages(kafka.Message{Value: []byte("v")}))
+
+	msgs := make(chan kafka.Message, 10)
+	c.SetReadHandler(func(msg kafka.Message) error {
+		msgs <- msg
+		if string(msg.Value) == "bad" {
+			return fmt.Errorf("failed to handle")
+		}
+		return nil
+	})
+	assert.NoError(t, c.StartRead())
+
+	// reader errors and handler errors do not stop reading
+	b.readErrs <- fmt.Errorf("connection reset")
+	b.topic("t2") <- kafka.Message{Value: []byte("bad")}
+	b.topic("t2") <- kafka.Message{Value: []byte("good")}
+	assert.Equal(t, "bad", string((<-msgs).Value))
+	assert.Equal(t, "good", string((<-msgs).Value))
+
+	r := c.reader.(*fakeReader)
+	c.Close()
+	assert.True(t, r.closed)
+}
+
+func TestClientDrain(t *testing.T) {
+	b := newFakeBroker()
+	defer b.install()()
+
+	rule := newRule("from", "t3")
+	rule.Drain = 3 * time.Second
+	c := newClient([]string{"127.0.0.1:9092"}, rule, logger.WithField("test", "client"))
+	handling, release := make(chan struct{}), make(chan struct{})
+	c.SetReadHandler(func(msg kafka.Message) error {
+		close(handling)
+		<-release
+		return nil
+	})
+	assert.NoError(t, c.StartRead())
+	b.topic("t3") <- kafka.Message{Offset: 7, Value: []byte("v")}
+	<-handling
+
+	// the message being handled is committed before closed
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("client closed before message handled")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	<-closed
+	assert.Len(t, b.commits, 1)
+	assert.Equal(t, int64(7), (<-b.commits).Offset)
+	assert.True(t, c.reader.(*fakeReader).closed)
+
+	// the deadline shared by ruler overrides the drain of client, the message not handled in time is not committed
+	c = newClient([]string{"127.0.0.1:9092"}, rule, logger.WithField("test", "client"))
+	handling, release = make(chan struct{}), make(chan struct{})
+	c.SetReadHandler(func(msg kafka.Message) error {
+		close(handling)
+		<-release
+		return nil
+	})
+	assert.NoError(t, c.StartRead())
+	b.topic("t3") <- kafka.Message{Offset: 8, Value: []byte("v")}
+	<-handling
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	closed = make(chan struct{})
+	go func() {
+		c.close(ctx)
+		close(closed)
+	}()
+	time.Sleep(500 * time.Millisecond)
+	close(release)
+	<-closed
+	assert.Len(t, b.commits, 0)
+}
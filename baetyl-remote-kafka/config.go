@@ -11,27 +11,65 @@ type Config struct {
 	Remotes []Remote `yaml:"remotes" json:"remotes"`
 	// parse item list
 	Rules []Rule `yaml:"rules" json:"rules"`
+	// reload rules if config file changed
+	Reload Reload `yaml:"reload" json:"reload"`
+}
+
+// Reload hot reload configuration
+type Reload struct {
+	Enable   bool          `yaml:"enable" json:"enable" default:"false"`
+	Path     string        `yaml:"path" json:"path" default:"etc/baetyl/service.yml"`
+	Interval time.Duration `yaml:"interval" json:"interval" default:"10s"` // interval to check config file
 }
 
 // Slave kafka slave device configuration
 type Remote struct {
-	Name string `yaml:"name" json:"name"`
+	Name    string   `yaml:"name" json:"name"`
 	Address []string `yaml:"address" json:"address"`
 }
 
 // ParseItem parse Item configuration
 type Rule struct {
 	Type string `yaml:"type" json:"type" validate:"regexp=^(to|from)?$"`
-	Hub struct {
+	Hub  struct {
 		ClientID      string           `yaml:"clientid" json:"clientid"`
 		Subscriptions []mqtt.TopicInfo `yaml:"subscriptions" json:"subscriptions" default:"[]"`
 	} `yaml:"hub" json:"hub"`
 	Remote struct {
-		Name string `yaml:"name" json:"name"`
-		Topic string `yaml:"topic" json:"topic"`
-		GroupID string `yaml:"group_id" json:"group_id"`
-		MinBytes int `yaml:"min_bytes" json:"min_bytes" default:"10e3"` // 10kB
-		MaxBytes int `yaml:"max_bytes" json:"max_bytes" default:"10e6"` // 10MB
-		MaxWait time.Duration `yaml:"max_wait" json:"max_wait" default:"1s"`
+		Name     string        `yaml:"name" json:"name"`
+		Topic    string        `yaml:"topic" json:"topic"`
+		GroupID  string        `yaml:"group_id" json:"group_id"`
+		MinBytes int           `yaml:"min_bytes" json:"min_bytes" default:"10e3"` // 10kB
+		MaxBytes int           `yaml:"max_bytes" json:"max_bytes" default:"10e6"` // 10MB
+		MaxWait  time.Duration `yaml:"max_wait" json:"max_wait" default:"1s"`
 	} `yaml:"remote" json:"remote"`
+	Limit Limit `yaml:"limit" json:"limit"`
+	Dedup Dedup `yaml:"dedup" json:"dedup"`
+	// max time to flush in-flight messages when closing
+	Drain time.Duration `yaml:"drain" json:"drain" default:"10s"`
+}
+
+// Limit rate limit and quota of messages written to kafka by a "to" rule
+type Limit struct {
+	Messages int           `yaml:"messages" json:"messages"` // max messages per second, 0 means unlimited
+	Bytes    int           `yaml:"bytes" json:"bytes"`       // max bytes per second, 0 means unlimited
+	Quota    int64         `yaml:"quota" json:"quota"`       // max bytes per month, 0 means unlimited
+	Action   string        `yaml:"action" json:"action" default:"drop" validate:"regexp=^(drop|buffer|reject)?$"`
+	Buffer   int           `yaml:"buffer" json:"buffer" default:"1000"` // max buffered messages of action buffer
+	Path     string        `yaml:"path" json:"path" default:"var/db/baetyl/stats"`
+	Interval time.Duration `yaml:"interval" json:"interval" default:"1m"` // interval to save stats
+}
+
+// Dedup deduplication of messages written to kafka by a "to" rule, duplicates are acknowledged but not written
+type Dedup struct {
+	Enable   bool          `yaml:"enable" json:"enable" default:"false"`
+	Field    string        `yaml:"field" json:"field"`                 // field of json payload as message id, the hash of topic and payload is used if not found
+	Window   time.Duration `yaml:"window" json:"window" default:"10m"` // max time to remember a message
+	Size     int           `yaml:"size" json:"size" default:"10000"`   // max messages to remember
+	Path     string        `yaml:"path" json:"path" default:"var/db/baetyl/dedup"`
+	Interval time.Duration `yaml:"interval" json:"interval" default:"10s"` // interval to save fingerprints
+}
+
+func (l Limit) enabled() bool {
+	return l.Messages > 0 || l.Bytes > 0 || l.Quota > 0
 }
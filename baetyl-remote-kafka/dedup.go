@@ -0,0 +1,134 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/baetyl/baetyl/utils"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// record fingerprint of a message written to kafka
+type record struct {
+	ID   string    `yaml:"id" json:"id"`
+	Time time.Time `yaml:"time" json:"time"`
+}
+
+// deduper remembers the fingerprints of messages written to kafka within the window
+type deduper struct {
+	cfg     Dedup
+	file    string
+	records *list.List
+	index   map[string]*list.Element
+	dirty   bool
+	lock    sync.Mutex
+}
+
+func newDeduper(cfg Dedup, id string) (*deduper, error) {
+	d := &deduper{
+		cfg:     cfg,
+		file:    path.Join(cfg.Path, id+".yml"),
+		records: list.New(),
+		index:   make(map[string]*list.Element),
+	}
+	err := os.MkdirAll(cfg.Path, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make dir (%s): %s", cfg.Path, err.Error())
+	}
+	if !utils.FileExists(d.file) {
+		return d, nil
+	}
+	var records []*record
+	err = utils.LoadYAML(d.file, &records)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load records (%s): %s", d.file, err.Error())
+	}
+	for _, r := range records {
+		d.index[r.ID] = d.records.PushBack(r)
+	}
+	return d, nil
+}
+
+// fingerprint returns the value of the id field if payload is a json object containing it,
+// otherwise the hash of topic and payload
+func (d *deduper) fingerprint(p *packet.Publish) string {
+	if d.cfg.Field != "" {
+		var fields map[string]interface{}
+		if json.Unmarshal(p.Message.Payload, &fields) == nil {
+			if v, ok := fields[d.cfg.Field]; ok && v != nil {
+				return fmt.Sprint(v)
+			}
+		}
+	}
+	h := sha256.New()
+	h.Write([]byte(p.Message.Topic))
+	h.Write([]byte{0})
+	h.Write(p.Message.Payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// seen reports whether the fingerprint is written within the window
+func (d *deduper) seen(id string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.evict(time.Now())
+	_, ok := d.index[id]
+	return ok
+}
+
+// add remembers the fingerprint, the oldest is forgotten if exceeds the size
+func (d *deduper) add(id string) {
+	now := time.Now()
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if e, ok := d.index[id]; ok {
+		d.records.Remove(e)
+	}
+	d.index[id] = d.records.PushBack(&record{ID: id, Time: now})
+	d.evict(now)
+	d.dirty = true
+}
+
+func (d *deduper) evict(now time.Time) {
+	for e := d.records.Front(); e != nil; e = d.records.Front() {
+		r := e.Value.(*record)
+		if d.records.Len() <= d.cfg.Size && now.Sub(r.Time) <= d.cfg.Window {
+			return
+		}
+		d.records.Remove(e)
+		delete(d.index, r.ID)
+		d.dirty = true
+	}
+}
+
+// dump saves the fingerprints in file if changed
+func (d *deduper) dump() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if !d.dirty {
+		return nil
+	}
+	records := make([]*record, 0, d.records.Len())
+	for e := d.records.Front(); e != nil; e = e.Next() {
+		records = append(records, e.Value.(*record))
+	}
+	data, err := yaml.Marshal(records)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(d.file, data, 0644)
+	if err != nil {
+		return err
+	}
+	d.dirty = false
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeduper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := Dedup{Enable: true, Field: "id", Window: time.Minute, Size: 2, Path: dir}
+	d, err := newDeduper(cfg, "test")
+	assert.NoError(t, err)
+
+	// fingerprint
+	p := packet.NewPublish()
+	p.Message.Topic = "a"
+	p.Message.Payload = []byte(`{"id":"x1","v":1}`)
+	assert.Equal(t, "x1", d.fingerprint(p))
+	p.Message.Payload = []byte(`{"id":12,"v":1}`)
+	assert.Equal(t, "12", d.fingerprint(p))
+	p.Message.Payload = []byte(`{"v":1}`)
+	h := d.fingerprint(p)
+	assert.Len(t, h, 64)
+	p.Message.Topic = "b"
+	assert.NotEqual(t, h, d.fingerprint(p))
+	p.Message.Payload = []byte("raw")
+	assert.Len(t, d.fingerprint(p), 64)
+
+	// size
+	assert.False(t, d.seen("1"))
+	d.add("1")
+	d.add("2")
+	assert.True(t, d.seen("1"))
+	d.add("3")
+	assert.False(t, d.seen("1"))
+	assert.True(t, d.seen("2"))
+	assert.True(t, d.seen("3"))
+
+	// persisted
+	assert.NoError(t, d.dump())
+	d, err = newDeduper(cfg, "test")
+	assert.NoError(t, err)
+	assert.False(t, d.seen("1"))
+	assert.True(t, d.seen("2"))
+	assert.True(t, d.seen("3"))
+
+	// window
+	d.cfg.Window = time.Millisecond
+	time.Sleep(10 * time.Millisecond)
+	assert.False(t, d.seen("2"))
+	assert.False(t, d.seen("3"))
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/baetyl/baetyl/logger"
+	"github.com/baetyl/baetyl/protocol/mqtt"
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeBroker in-memory kafka broker and hub
+type fakeBroker struct {
+	topics   map[string]chan kafka.Message
+	commits  chan kafka.Message
+	hubs     map[string]*fakeHub
+	readErrs chan error
+	writeErr error
+	lock     sync.Mutex
+}
+
+func newFakeBroker() *fakeBroker {
+	return &fakeBroker{
+		topics:   make(map[string]chan kafka.Message),
+		commits:  make(chan kafka.Message, 100),
+		hubs:     make(map[string]*fakeHub),
+		readErrs: make(chan error, 10),
+	}
+}
+
+// install replaces the factories of producer, consumer and hub client with fakes, returns a function to restore them
+func (b *fakeBroker) install() func() {
+	np, nc, nh := newProducer, newConsumer, newHubClient
+	newProducer = func(address []string, cfg Rule) producer {
+		return &fakeWriter{broker: b, topic: cfg.Remote.Topic}
+	}
+	newConsumer = func(address []string, cfg Rule) consumer {
+		return &fakeReader{broker: b, topic: cfg.Remote.Topic}
+	}
+	newHubClient = func(cc mqtt.ClientInfo, log logger.Logger) hubClient {
+		h := &fakeHub{info: cc, sent: make(chan packet.Generic, 100)}
+		b.lock.Lock()
+		b.hubs[cc.ClientID] = h
+		b.lock.Unlock()
+		return h
+	}
+	return func() {
+		newProducer, newConsumer, newHubClient = np, nc, nh
+	}
+}
+
+func (b *fakeBroker) topic(name string) chan kafka.Message {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	ch, ok := b.topics[name]
+	if !ok {
+		ch = make(chan kafka.Message, 100)
+		b.topics[name] = ch
+	}
+	return ch
+}
+
+func (b *fakeBroker) hub(clientID string) *fakeHub {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.hubs[clientID]
+}
+
+// next returns the next message of topic or an error after timeout
+func (b *fakeBroker) next(topic string) (kafka.Message, error) {
+	select {
+	case msg := <-b.topic(topic):
+		return msg, nil
+	case <-time.After(3 * time.Second):
+		return kafka.Message{}, fmt.Errorf("no message in topic (%s)", topic)
+	}
+}
+
+type fakeWriter struct {
+	broker *fakeBroker
+	topic  string
+	closed bool
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.broker.lock.Lock()
+	err := w.broker.writeErr
+	w.broker.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		msg.Topic = w.topic
+		w.broker.topic(w.topic) <- msg
+	}
+	return nil
+}
+
+func (w *fakeWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+type fakeReader struct {
+	broker *fakeBroker
+	topic  string
+	closed bool
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	case err := <-r.broker.readErrs:
+		return kafka.Message{}, err
+	case msg := <-r.broker.topic(r.topic):
+		return msg, nil
+	}
+}
+
+func (r *fakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		r.broker.commits <- msg
+	}
+	return nil
+}
+
+func (r *fakeReader) Close() error {
+	r.closed = true
+	return nil
+}
+
+type fakeHub struct {
+	info    mqtt.ClientInfo
+	handler mqtt.Handler
+	sent    chan packet.Generic
+	closed  bool
+}
+
+func (h *fakeHub) Start(handler mqtt.Handler) error {
+	h.handler = handler
+	return nil
+}
+
+func (h *fakeHub) Send(pkt packet.Generic) error {
+	h.sent <- pkt
+	return nil
+}
+
+func (h *fakeHub) Close() error {
+	h.closed = true
+	return nil
+}
+
+// publish delivers a message from hub to the ruler
+func (h *fakeHub) publish(id packet.ID, qos packet.QOS, topic, payload string) error {
+	pkt := packet.NewPublish()
+	pkt.ID = id
+	pkt.Message.Topic = topic
+	pkt.Message.QOS = qos
+	pkt.Message.Payload = []byte(payload)
+	return h.handler.ProcessPublish(pkt)
+}
+
+// next returns the next packet sent to hub or an error after timeout
+func (h *fakeHub) next() (packet.Generic, error) {
+	select {
+	case pkt := <-h.sent:
+		return pkt, nil
+	case <-time.After(3 * time.Second):
+		return nil, fmt.Errorf("no packet sent to hub")
+	}
+}
+
+// empty reports whether no packet is sent to hub in a short time
+func (h *fakeHub) empty() bool {
+	select {
+	case <-h.sent:
+		return false
+	case <-time.After(100 * time.Millisecond):
+		return true
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/baetyl/baetyl/utils"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The actions when a message exceeds the limit
+const (
+	ActionDrop   = "drop"
+	ActionBuffer = "buffer"
+	ActionReject = "reject"
+)
+
+// Item data count
+type Item struct {
+	Bytes int64 `yaml:"bytes" json:"bytes"`
+	Count int64 `yaml:"count" json:"count"`
+}
+
+// Stats month stats
+type Stats struct {
+	Total  Item             `yaml:"total" json:"total"`
+	Months map[string]*Item `yaml:"months" json:"months"`
+}
+
+// bucket token bucket refilled with rate tokens per second
+type bucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate int) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &bucket{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+}
+
+// delay returns how long to wait until n tokens can be taken,
+// a request larger than the bucket only waits for a full bucket
+func (b *bucket) delay(n float64) time.Duration {
+	if n > b.rate {
+		n = b.rate
+	}
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// limiter limits the messages and bytes per second and the bytes per month of a rule
+type limiter struct {
+	cfg      Limit
+	file     string
+	messages *bucket
+	bytes    *bucket
+	stats    Stats
+	dirty    bool
+	lock     sync.Mutex
+}
+
+func newLimiter(cfg Limit, id string) (*limiter, error) {
+	l := &limiter{
+		cfg:      cfg,
+		messages: newBucket(cfg.Messages),
+		bytes:    newBucket(cfg.Bytes),
+		stats:    Stats{Months: map[string]*Item{}},
+	}
+	if cfg.Quota <= 0 {
+		return l, nil
+	}
+	err := os.MkdirAll(cfg.Path, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make dir (%s): %s", cfg.Path, err.Error())
+	}
+	l.file = path.Join(cfg.Path, id+".yml")
+	if utils.FileExists(l.file) {
+		err = utils.LoadYAML(l.file, &l.stats)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load stats (%s): %s", l.file, err.Error())
+		}
+		if l.stats.Months == nil {
+			l.stats.Months = map[string]*Item{}
+		}
+	}
+	return l, nil
+}
+
+// reserve takes the tokens of a message with n bytes if the limit allows,
+// otherwise returns how long to wait and the reason
+func (l *limiter) reserve(n int) (time.Duration, error) {
+	now := time.Now()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.cfg.Quota > 0 {
+		month := now.Format("2006-01")
+		var used int64
+		if item, ok := l.stats.Months[month]; ok {
+			used = item.Bytes
+		}
+		if used+int64(n) > l.cfg.Quota {
+			next := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, now.Location())
+			return next.Sub(now), fmt.Errorf("exceeds max data size (%d) of this month", l.cfg.Quota)
+		}
+	}
+	var d time.Duration
+	if l.messages != nil {
+		l.messages.refill(now)
+		d = l.messages.delay(1)
+	}
+	if l.bytes != nil {
+		l.bytes.refill(now)
+		if bd := l.bytes.delay(float64(n)); bd > d {
+			d = bd
+		}
+	}
+	if d > 0 {
+		return d, fmt.Errorf("exceeds rate limit")
+	}
+	if l.messages != nil {
+		l.messages.tokens--
+	}
+	if l.bytes != nil {
+		l.bytes.tokens -= float64(n)
+	}
+	return 0, nil
+}
+
+// record counts a message with n bytes written to remote
+func (l *limiter) record(n int) {
+	if l.cfg.Quota <= 0 {
+		return
+	}
+	month := time.Now().Format("2006-01")
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if _, ok := l.stats.Months[month]; !ok {
+		l.stats.Months[month] = &Item{}
+	}
+	l.stats.Total.Bytes += int64(n)
+	l.stats.Total.Count++
+	l.stats.Months[month].Bytes += int64(n)
+	l.stats.Months[month].Count++
+	l.dirty = true
+}
+
+// dump saves the stats in file if changed
+func (l *limiter) dump() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if !l.dirty {
+		return nil
+	}
+	data, err := yaml.Marshal(&l.stats)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(l.file, data, 0644)
+	if err != nil {
+		return err
+	}
+	l.dirty = false
+	return nil
+}
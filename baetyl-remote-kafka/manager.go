@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"time"
+
+	"github.com/baetyl/baetyl/logger"
+	"github.com/baetyl/baetyl/protocol/mqtt"
+	"github.com/baetyl/baetyl/utils"
+)
+
+type entry struct {
+	rule   Rule
+	remote Remote
+	ruler  *ruler
+}
+
+// manager creates and closes rulers according to the config
+type manager struct {
+	cfg    Config
+	hub    mqtt.ClientInfo
+	rulers map[string]*entry
+	data   []byte
+	tomb   utils.Tomb
+	log    logger.Logger
+}
+
+func newManager(cfg Config, hub mqtt.ClientInfo, log logger.Logger) *manager {
+	return &manager{
+		cfg:    cfg,
+		hub:    hub,
+		rulers: make(map[string]*entry),
+		log:    log,
+	}
+}
+
+// start starts all rulers of the config and watches the config file if reload enabled
+func (m *manager) start() error {
+	if err := m.apply(m.cfg, true); err != nil {
+		return err
+	}
+	if !m.cfg.Reload.Enable {
+		return nil
+	}
+	data, err := ioutil.ReadFile(m.cfg.Reload.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read config (%s): %s", m.cfg.Reload.Path, err.Error())
+	}
+	m.data = data
+	return m.tomb.Go(m.watching)
+}
+
+// apply diffs the config with the running rulers, only rulers added, removed or changed are created or closed.
+// The config with duplicated rules is rejected before any ruler is changed
+func (m *manager) apply(cfg Config, strict bool) error {
+	remotes := make(map[string]Remote)
+	for _, remote := range cfg.Remotes {
+		remotes[remote.Name] = remote
+	}
+	rules := make(map[string]Rule)
+	for _, rule := range cfg.Rules {
+		if _, ok := remotes[rule.Remote.Name]; !ok {
+			m.log.Errorf("remote (%s) not found", rule.Remote.Name)
+			continue
+		}
+		k := key(rule)
+		if _, ok := rules[k]; ok {
+			return fmt.Errorf("rule (%s) duplicated: the client id, type and remote of rules should be unique", k)
+		}
+		rules[k] = rule
+	}
+	for k, e := range m.rulers {
+		rule, ok := rules[k]
+		if ok && reflect.DeepEqual(rule, e.rule) && reflect.DeepEqual(remotes[rule.Remote.Name], e.remote) {
+			continue
+		}
+		m.log.Infof("ruler (%s) removed or changed, to close", k)
+		e.ruler.close()
+		delete(m.rulers, k)
+	}
+	for k, rule := range rules {
+		if _, ok := m.rulers[k]; ok {
+			continue
+		}
+		remote := remotes[rule.Remote.Name]
+		rr, err := m.create(rule, remote)
+		if err != nil {
+			if strict {
+				return err
+			}
+			m.log.Errorf("failed to start ruler (%s): %s", k, err.Error())
+			continue
+		}
+		m.rulers[k] = &entry{rule: rule, remote: remote, ruler: rr}
+		m.log.Infof("ruler (%s) started", k)
+	}
+	return nil
+}
+
+func (m *manager) create(rule Rule, remote Remote) (*ruler, error) {
+	client := newClient(remote.Address, rule, m.log)
+	if client == nil {
+		return nil, fmt.Errorf("group id of rule (%s) is required", key(rule))
+	}
+	rr, err := create(rule, m.hub, client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	if err = rr.start(); err != nil {
+		rr.close()
+		return nil, err
+	}
+	return rr, nil
+}
+
+// watching reloads the rules and remotes if the config file changed, the reload config itself is not reloaded
+func (m *manager) watching() error {
+	t := time.NewTicker(m.cfg.Reload.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-m.tomb.Dying():
+			return nil
+		case <-t.C:
+			m.reload()
+		}
+	}
+}
+
+func (m *manager) reload() {
+	data, err := ioutil.ReadFile(m.cfg.Reload.Path)
+	if err != nil {
+		m.log.Errorf("failed to read config (%s): %s", m.cfg.Reload.Path, err.Error())
+		return
+	}
+	if bytes.Equal(data, m.data) {
+		return
+	}
+	m.data = data
+	var cfg Config
+	if err = utils.UnmarshalYAML(data, &cfg); err != nil {
+		m.log.Errorf("failed to load config (%s): %s", m.cfg.Reload.Path, err.Error())
+		return
+	}
+	m.log.Infof("config (%s) changed, to reload", m.cfg.Reload.Path)
+	if err = m.apply(cfg, false); err != nil {
+		m.log.Errorf("failed to reload config (%s): %s", m.cfg.Reload.Path, err.Error())
+	}
+}
+
+func (m *manager) close() {
+	m.tomb.Kill(nil)
+	m.tomb.Wait()
+	for _, e := range m.rulers {
+		e.ruler.close()
+	}
+}
+
+// key identifies a rule by the client id connecting to hub
+func key(rule Rule) string {
+	return rule.Hub.ClientID + rule.Type + rule.Remote.Name
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl/logger"
+	"github.com/baetyl/baetyl/protocol/mqtt"
+	"github.com/baetyl/baetyl/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager(t *testing.T) {
+	b := newFakeBroker()
+	defer b.install()()
+
+	r1 := newRule("to", "t1")
+	r2 := newRule("from", "t2")
+	cfg := Config{
+		Remotes: []Remote{{Name: "kafka", Address: []string{"127.0.0.1:9092"}}},
+		Rules:   []Rule{r1, r2},
+	}
+	m := newManager(cfg, mqtt.ClientInfo{}, logger.WithField("test", "manager"))
+	assert.NoError(t, m.start())
+	assert.Len(t, m.rulers, 2)
+	h1, h2 := b.hub(key(r1)), b.hub(key(r2))
+
+	// round 1: unchanged config
+	assert.NoError(t, m.apply(cfg, false))
+	assert.Len(t, m.rulers, 2)
+	assert.False(t, h1.closed)
+	assert.False(t, h2.closed)
+
+	// round 2: rule changed, remote not found
+	r1.Remote.Topic = "t3"
+	r3 := newRule("to", "t4")
+	r3.Remote.Name = "unknown"
+	cfg.Rules = []Rule{r1, r2, r3}
+	assert.NoError(t, m.apply(cfg, false))
+	assert.Len(t, m.rulers, 2)
+	assert.True(t, h1.closed)
+	assert.False(t, h2.closed)
+	assert.Equal(t, "t3", m.rulers[key(r1)].rule.Remote.Topic)
+
+	// round 3: rule removed
+	h1 = b.hub(key(r1))
+	cfg.Rules = []Rule{r2}
+	assert.NoError(t, m.apply(cfg, false))
+	assert.Len(t, m.rulers, 1)
+	assert.True(t, h1.closed)
+
+	m.close()
+	assert.True(t, h2.closed)
+}
+
+func TestManagerReload(t *testing.T) {
+	b := newFakeBroker()
+	defer b.install()()
+	dir, err := ioutil.TempDir("", "reload")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := path.Join(dir, "service.yml")
+	data := `
+remotes:
+  - name: kafka
+    address: ["127.0.0.1:9092"]
+rules:
+  - type: to
+    hub:
+      clientid: test
+    remote:
+      name: kafka
+      topic: t1
+`
+	assert.NoError(t, ioutil.WriteFile(conf, []byte(data), 0644))
+	var cfg Config
+	assert.NoError(t, utils.LoadYAML(conf, &cfg))
+	cfg.Reload = Reload{Enable: true, Path: conf, Interval: time.Hour}
+	m := newManager(cfg, mqtt.ClientInfo{}, logger.WithField("test", "manager"))
+	assert.NoError(t, m.start())
+	defer m.close()
+	assert.Len(t, m.rulers, 1)
+	h := b.hub("testtokafka")
+
+	// round 1: file not changed
+	m.reload()
+	assert.False(t, h.closed)
+
+	// round 2: file changed
+	assert.NoError(t, ioutil.WriteFile(conf, []byte(strings.Replace(data, "t1", "t2", 1)), 0644))
+	m.reload()
+	assert.True(t, h.closed)
+	assert.Equal(t, "t2", m.rulers["testtokafka"].rule.Remote.Topic)
+	assert.False(t, b.hub("testtokafka").closed)
+
+	// round 3: rules duplicated, the running rulers are kept
+	h = b.hub("testtokafka")
+	dup := strings.Replace(data, "t1", "t2", 1) + `
+  - type: to
+    hub:
+      clientid: test
+    remote:
+      name: kafka
+      topic: t3
+`
+	assert.NoError(t, ioutil.WriteFile(conf, []byte(dup), 0644))
+	m.reload()
+	assert.False(t, h.closed)
+	assert.Len(t, m.rulers, 1)
+	assert.Equal(t, "t2", m.rulers["testtokafka"].rule.Remote.Topic)
+	var c Config
+	assert.NoError(t, utils.LoadYAML(conf, &c))
+	assert.EqualError(t, newManager(c, mqtt.ClientInfo{}, logger.WithField("test", "manager")).start(),
+		"rule (testtokafka) duplicated: the client id, type and remote of rules should be unique")
+}
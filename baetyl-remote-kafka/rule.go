@@ -1,48 +1,85 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
 	"github.com/256dpi/gomqtt/packet"
 	"github.com/baetyl/baetyl/logger"
 	"github.com/baetyl/baetyl/protocol/mqtt"
+	"github.com/baetyl/baetyl/utils"
 	"github.com/segmentio/kafka-go"
 )
 
+// hubClient sends and receives messages of hub, implemented by *mqtt.Dispatcher
+type hubClient interface {
+	Start(h mqtt.Handler) error
+	Send(pkt packet.Generic) error
+	Close() error
+}
+
+// the factory of hub client, replaced by a fake in tests
+var newHubClient = func(cc mqtt.ClientInfo, log logger.Logger) hubClient {
+	return mqtt.NewDispatcher(cc, log)
+}
+
 type ruler struct {
-	rule   *Rule
-	hub    *mqtt.Dispatcher
-	client *client
-	log    logger.Logger
+	rule    *Rule
+	hub     hubClient
+	client  *client
+	limiter *limiter
+	dedup   *deduper
+	buffer  chan *packet.Publish
+	tomb    utils.Tomb
+	log     logger.Logger
+
+	inflight flight // the msgs from hub being processed
+	routines flight // the goroutines of tomb
+	closing  bool
+	lock     sync.RWMutex
+	drain    context.Context
 }
 
-func create(rule Rule, hub mqtt.ClientInfo, client *client) *ruler {
+func create(rule Rule, hub mqtt.ClientInfo, client *client) (*ruler, error) {
 	defaults(&rule, &hub)
 	log := logger.WithField("rule", rule.Remote.Name)
-	return &ruler{
+	rr := &ruler{
 		rule:   &rule,
-		hub:    mqtt.NewDispatcher(hub, log),
+		hub:    newHubClient(hub, log),
 		client: client,
 		log:    log,
 	}
+	if rule.Type == "to" && rule.Limit.enabled() {
+		l, err := newLimiter(rule.Limit, hub.ClientID)
+		if err != nil {
+			return nil, err
+		}
+		rr.limiter = l
+		if rule.Limit.Action == ActionBuffer {
+			rr.buffer = make(chan *packet.Publish, rule.Limit.Buffer)
+		}
+	}
+	if rule.Type == "to" && rule.Dedup.Enable {
+		d, err := newDeduper(rule.Dedup, hub.ClientID)
+		if err != nil {
+			return nil, err
+		}
+		rr.dedup = d
+	}
+	return rr, nil
 }
 
 func (rr *ruler) start() error {
 	hubHandler := mqtt.NewHandlerWrapper(
 		func(p *packet.Publish) error {
-			msg := p.Message
-			kafkaMsg := kafka.Message{
-				Key:   []byte(msg.Topic),
-				Value: msg.Payload,
-			}
-			err := rr.client.WriteMessages(kafkaMsg)
-			if err != nil {
-				rr.log.Errorf("failed to writer msg id=%d to kafka")
-				return err
+			if !rr.accept() {
+				rr.log.Warnf("ruler is closing, msg id=%d rejected", p.ID)
+				return rr.reject(p, fmt.Errorf("ruler is closing"))
 			}
-			if p.Message.QOS == 1 {
-				r := &packet.Puback{ID: p.ID}
-				return rr.hub.Send(r)
-			}
-			return nil
+			defer rr.inflight.done()
+			return rr.process(p)
 		},
 		func(p *packet.Puback) error {
 			return nil
@@ -54,6 +91,15 @@ func (rr *ruler) start() error {
 	if err := rr.hub.Start(hubHandler); err != nil {
 		return err
 	}
+	if rr.buffer != nil {
+		goroutine(&rr.tomb, &rr.routines, rr.flushing)
+	}
+	if rr.limiter != nil && rr.rule.Limit.Quota > 0 {
+		goroutine(&rr.tomb, &rr.routines, rr.saving(rr.rule.Limit.Interval, rr.limiter.dump))
+	}
+	if rr.dedup != nil {
+		goroutine(&rr.tomb, &rr.routines, rr.saving(rr.rule.Dedup.Interval, rr.dedup.dump))
+	}
 	rr.client.SetReadHandler(func(msg kafka.Message) error {
 		for _, subscription := range rr.rule.Hub.Subscriptions {
 			pkt := packet.NewPublish()
@@ -72,12 +118,187 @@ func (rr *ruler) start() error {
 	return nil
 }
 
+// accept counts the msg in flight if the ruler is not closing
+func (rr *ruler) accept() bool {
+	rr.lock.RLock()
+	defer rr.lock.RUnlock()
+	if rr.closing {
+		return false
+	}
+	rr.inflight.add()
+	return true
+}
+
+func (rr *ruler) process(p *packet.Publish) error {
+	if rr.limiter == nil {
+		return rr.write(p)
+	}
+	if rr.buffer != nil {
+		select {
+		case rr.buffer <- p:
+			return nil
+		default:
+			rr.log.Warnf("buffer is full, msg id=%d rejected", p.ID)
+			return rr.reject(p, fmt.Errorf("buffer is full"))
+		}
+	}
+	if _, err := rr.limiter.reserve(len(p.Message.Payload)); err != nil {
+		if rr.rule.Limit.Action == ActionReject {
+			rr.log.Warnf("msg id=%d rejected: %s", p.ID, err.Error())
+			return rr.reject(p, err)
+		}
+		rr.log.Warnf("msg id=%d dropped: %s", p.ID, err.Error())
+		return rr.ack(p)
+	}
+	return rr.write(p)
+}
+
+func (rr *ruler) write(p *packet.Publish) error {
+	var id string
+	if rr.dedup != nil {
+		id = rr.dedup.fingerprint(p)
+		if rr.dedup.seen(id) {
+			rr.log.Debugf("msg id=%d is duplicate, skipped", p.ID)
+			return rr.ack(p)
+		}
+	}
+	msg := p.Message
+	kafkaMsg := kafka.Message{
+		Key:   []byte(msg.Topic),
+		Value: msg.Payload,
+	}
+	err := rr.client.WriteMessages(kafkaMsg)
+	if err != nil {
+		rr.log.Errorf("failed to writer msg id=%d to kafka", p.ID)
+		return err
+	}
+	if rr.limiter != nil {
+		rr.limiter.record(len(msg.Payload))
+	}
+	if rr.dedup != nil {
+		rr.dedup.add(id)
+	}
+	return rr.ack(p)
+}
+
+func (rr *ruler) ack(p *packet.Publish) error {
+	if p.Message.QOS == 1 {
+		r := &packet.Puback{ID: p.ID}
+		return rr.hub.Send(r)
+	}
+	return nil
+}
+
+// reject returns the error to make the hub redeliver the msg of QoS 1, msg of QoS 0 is dropped
+func (rr *ruler) reject(p *packet.Publish, err error) error {
+	if p.Message.QOS == 1 {
+		return err
+	}
+	return nil
+}
+
+// flushing writes the buffered msgs to kafka as soon as the limit allows
+func (rr *ruler) flushing() error {
+	for {
+		select {
+		case <-rr.tomb.Dying():
+			return rr.flushLeft(nil)
+		case p := <-rr.buffer:
+			if !rr.flush(p, rr.tomb.Dying()) {
+				return rr.flushLeft(p)
+			}
+		}
+	}
+}
+
+// flushLeft flushes the msgs left when closing until drain timeout
+func (rr *ruler) flushLeft(p *packet.Publish) error {
+	for {
+		if p == nil {
+			select {
+			case p = <-rr.buffer:
+			default:
+				return nil
+			}
+		}
+		if !rr.flush(p, rr.drain.Done()) {
+			rr.log.Warnf("drain timeout, %d buffered msgs not written", len(rr.buffer)+1)
+			return nil
+		}
+		p = nil
+	}
+}
+
+// flush writes the msg to kafka once the limit allows, returns false if aborted
+func (rr *ruler) flush(p *packet.Publish, abort <-chan struct{}) bool {
+	for {
+		d, err := rr.limiter.reserve(len(p.Message.Payload))
+		if err == nil {
+			break
+		}
+		select {
+		case <-abort:
+			return false
+		case <-time.After(d):
+		}
+	}
+	if err := rr.write(p); err != nil {
+		rr.log.Errorf("failed to flush msg id=%d: %s", p.ID, err.Error())
+	}
+	return true
+}
+
+// saving saves the stats or fingerprints periodically
+func (rr *ruler) saving(interval time.Duration, dump func() error) func() error {
+	return func() error {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-rr.tomb.Dying():
+				return nil
+			case <-t.C:
+				if err := dump(); err != nil {
+					rr.log.Errorf("failed to save: %s", err.Error())
+				}
+			}
+		}
+	}
+}
+
+// close stops accepting msgs from hub, flushes the msgs in flight or buffered and the kafka msgs being handled until drain timeout
 func (rr *ruler) close() {
+	rr.lock.Lock()
+	rr.closing = true
+	rr.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), rr.rule.Drain)
+	defer cancel()
+	rr.drain = ctx
+	if !wait(ctx, rr.inflight.wait()) {
+		rr.log.Warnf("drain timeout, msgs in flight not finished")
+	}
+	rr.tomb.Kill(nil)
+	if !wait(ctx, rr.routines.wait()) {
+		rr.log.Warnf("drain timeout, buffered msgs not finished")
+	}
+	// the client drains until the same deadline, so that closing takes drain timeout at most
+	rr.client.close(ctx)
+	rr.tomb.Wait()
+	if rr.limiter != nil && rr.rule.Limit.Quota > 0 {
+		if err := rr.limiter.dump(); err != nil {
+			rr.log.Errorf("failed to save stats: %s", err.Error())
+		}
+	}
+	if rr.dedup != nil {
+		if err := rr.dedup.dump(); err != nil {
+			rr.log.Errorf("failed to save fingerprints: %s", err.Error())
+		}
+	}
 	rr.hub.Close()
-	rr.client.Close()
 }
 
 func defaults(rule *Rule, hub *mqtt.ClientInfo) {
-	hub.ClientID = rule.Hub.ClientID + rule.Type + rule.Remote.Name
+	hub.ClientID = key(*rule)
 	hub.Subscriptions = rule.Hub.Subscriptions
 }
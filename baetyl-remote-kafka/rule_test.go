@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/baetyl/baetyl/logger"
+	"github.com/baetyl/baetyl/protocol/mqtt"
+	"github.com/baetyl/baetyl/utils"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRuler(t *testing.T, b *fakeBroker, rule Rule) (*ruler, *fakeHub) {
+	c := newClient([]string{"127.0.0.1:9092"}, rule, logger.WithField("test", "rule"))
+	rr, err := create(rule, mqtt.ClientInfo{}, c)
+	assert.NoError(t, err)
+	assert.NoError(t, rr.start())
+	h := b.hub(key(rule))
+	assert.NotNil(t, h)
+	return rr, h
+}
+
+func TestRulerTo(t *testing.T) {
+	b := newFakeBroker()
+	defer b.install()()
+
+	rr, h := newRuler(t, b, newRule("to", "t1"))
+	assert.Equal(t, "testtokafka", h.info.ClientID)
+
+	// qos 1 is acknowledged after written to kafka
+	assert.NoError(t, h.publish(1, 1, "a/b", "hello"))
+	msg, err := b.next("t1")
+	assert.NoError(t, err)
+	assert.Equal(t, "a/b", string(msg.Key))
+	assert.Equal(t, "hello", string(msg.Value))
+	pkt, err := h.next()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.ID(1), pkt.(*packet.Puback).ID)
+
+	// qos 0 is not acknowledged
+	assert.NoError(t, h.publish(0, 0, "a/b", "world"))
+	msg, err = b.next("t1")
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(msg.Value))
+	assert.True(t, h.empty())
+
+	// qos 1 is not acknowledged if failed to write to kafka
+	b.writeErr = fmt.Errorf("broker unavailable")
+	assert.EqualError(t, h.publish(2, 1, "a/b", "hello"), "broker unavailable")
+	assert.True(t, h.empty())
+
+	w := rr.client.writer.(*fakeWriter)
+	rr.close()
+	assert.True(t, h.closed)
+	assert.True(t, w.closed)
+}
+
+func TestRulerFrom(t *testing.T) {
+	b := newFakeBroker()
+	defer b.install()()
+
+	rule := newRule("from", "t2")
+	rule.Hub.Subscriptions = []mqtt.TopicInfo{{QOS: 0, Topic: "x"}, {QOS: 1, Topic: "y"}}
+	rr, h := newRuler(t, b, rule)
+	assert.Equal(t, "testfromkafka", h.info.ClientID)
+	assert.Len(t, h.info.Subscriptions, 2)
+
+	b.readErrs <- fmt.Errorf("connection reset")
+	b.topic("t2") <- kafka.Message{Key: []byte("k"), Value: []byte("hello")}
+	for _, s := range rule.Hub.Subscriptions {
+		pkt, err := h.next()
+		assert.NoError(t, err)
+		pub := pkt.(*packet.Publish)
+		assert.Equal(t, s.Topic, pub.Message.Topic)
+		assert.Equal(t, packet.QOS(s.QOS), pub.Message.QOS)
+		assert.Equal(t, "hello", string(pub.Message.Payload))
+	}
+
+	r := rr.client.reader.(*fakeReader)
+	rr.close()
+	assert.True(t, h.closed)
+	assert.True(t, r.closed)
+}
+
+func TestRulerLimit(t *testing.T) {
+	b := newFakeBroker()
+	defer b.install()()
+	dir, err := ioutil.TempDir("", "limit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// round 1: drop
+	rule := newRule("to", "t3")
+	rule.Limit = Limit{Messages: 1, Action: ActionDrop, Path: dir}
+	rr, h := newRuler(t, b, rule)
+	assert.NoError(t, h.publish(1, 1, "a", "1"))
+	assert.NoError(t, h.publish(2, 1, "a", "2"))
+	for _, id := range []packet.ID{1, 2} {
+		pkt, err := h.next()
+		assert.NoError(t, err)
+		assert.Equal(t, id, pkt.(*packet.Puback).ID)
+	}
+	msg, err := b.next("t3")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(msg.Value))
+	assert.Len(t, b.topic("t3"), 0)
+	rr.close()
+
+	// round 2: reject
+	rule.Limit = Limit{Quota: 3, Action: ActionReject, Path: dir, Interval: time.Millisecond}
+	rr, h = newRuler(t, b, rule)
+	// the first message of month larger than quota is rejected
+	assert.EqualError(t, h.publish(4, 1, "a", "1234"), "exceeds max data size (3) of this month")
+	assert.NoError(t, h.publish(1, 1, "a", "123"))
+	_, err = b.next("t3")
+	assert.NoError(t, err)
+	assert.EqualError(t, h.publish(2, 1, "a", "4"), "exceeds max data size (3) of this month")
+	assert.NoError(t, h.publish(3, 0, "a", "4"))
+	assert.Len(t, b.topic("t3"), 0)
+	rr.close()
+	var stats Stats
+	assert.NoError(t, utils.LoadYAML(path.Join(dir, "testtokafka.yml"), &stats))
+	assert.Equal(t, int64(3), stats.Total.Bytes)
+	assert.Equal(t, int64(1), stats.Total.Count)
+
+	// round 3: buffer
+	rule.Limit = Limit{Bytes: 1000, Action: ActionBuffer, Buffer: 1, Path: dir}
+	rr, h = newRuler(t, b, rule)
+	assert.NoError(t, h.publish(1, 1, "a", "1"))
+	msg, err = b.next("t3")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(msg.Value))
+	pkt, err := h.next()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.ID(1), pkt.(*packet.Puback).ID)
+	rr.close()
+}
+
+func TestRulerDrain(t *testing.T) {
+	b := newFakeBroker()
+	defer b.install()()
+	dir, err := ioutil.TempDir("", "drain")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// round 1: buffered msgs are flushed before closed
+	rule := newRule("to", "t4")
+	rule.Drain = 3 * time.Second
+	rule.Limit = Limit{Messages: 4, Action: ActionBuffer, Buffer: 10, Path: dir}
+	rr, h := newRuler(t, b, rule)
+	for i := 1; i <= 6; i++ {
+		assert.NoError(t, h.publish(packet.ID(i), 1, "a", "m"))
+	}
+	rr.close()
+	assert.Len(t, b.topic("t4"), 6)
+	assert.Len(t, h.sent, 6)
+	assert.True(t, h.closed)
+
+	// msgs are rejected after closed
+	assert.EqualError(t, h.publish(7, 1, "a", "m"), "ruler is closing")
+	assert.NoError(t, h.publish(8, 0, "a", "m"))
+	assert.Len(t, b.topic("t4"), 6)
+
+	// round 2: buffered msgs are left after drain timeout
+	rule.Drain = 100 * time.Millisecond
+	rule.Limit.Messages = 1
+	rr, h = newRuler(t, b, rule)
+	for i := 1; i <= 3; i++ {
+		assert.NoError(t, h.publish(packet.ID(i), 1, "a", "m"))
+	}
+	rr.close()
+	assert.Len(t, h.sent, 1)
+}
+
+func TestRulerDedup(t *testing.T) {
+	b := newFakeBroker()
+	defer b.install()()
+	dir, err := ioutil.TempDir("", "dedup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	rule := newRule("to", "t5")
+	rule.Dedup = Dedup{Enable: true, Window: time.Minute, Size: 10, Path: dir, Interval: time.Minute}
+	rr, h := newRuler(t, b, rule)
+	assert.NoError(t, h.publish(1, 1, "a", "m1"))
+	assert.NoError(t, h.publish(1, 1, "a", "m1"))
+	assert.NoError(t, h.publish(2, 1, "b", "m1"))
+	rr.close()
+	assert.Len(t, b.topic("t5"), 2)
+	assert.Len(t, h.sent, 3)
+
+	// duplicates are remembered across restarts
+	rr, h = newRuler(t, b, rule)
+	assert.NoError(t, h.publish(1, 1, "a", "m1"))
+	assert.NoError(t, h.publish(3, 1, "a", "m2"))
+	rr.close()
+	assert.Len(t, b.topic("t5"), 3)
+	assert.Len(t, h.sent, 2)
+}
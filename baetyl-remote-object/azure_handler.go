@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	v1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/docker/distribution/uuid"
+)
+
+// the version of azure blob service rest api
+const azureVersion = "2019-12-12"
+
+// AzureHandler stores objects in azure blob storage, the bucket is the container,
+// ak is the account name, sk is the account key for shared key auth, token is the sas token
+type AzureHandler struct {
+	rest       *restClient
+	endpoint   *url.URL
+	account    string
+	key        []byte     // the account key, nil if sas is used
+	sas        url.Values // the sas token, nil if shared key is used
+	cfg        ClientInfo
+	resumer    *resumer
+	throttlers []*throttler
+	log        *log.Logger
+}
+
+// NewAzureHandler creates a new azure handler, the endpoint is https://<account>.blob.core.windows.net
+// by default, or the one of emulator such as http://127.0.0.1:10000/devstoreaccount1
+func NewAzureHandler(cfg ClientInfo) (StorageHandler, error) {
+	if cfg.Ak == "" {
+		return nil, errors.Errorf("failed to create azure client (%s): ak is required as the account name", cfg.Name)
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", cfg.Ak)
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, errors.Errorf("failed to create azure client (%s): %s", cfg.Name, err.Error())
+	}
+	rest, err := newRESTClient(cfg)
+	if err != nil {
+		return nil, errors.Errorf("failed to create azure client (%s): %s", cfg.Name, err.Error())
+	}
+	h := &AzureHandler{
+		rest:       rest,
+		endpoint:   u,
+		account:    cfg.Ak,
+		cfg:        cfg,
+		throttlers: []*throttler{newThrottler(cfg.Throttle), globalThrottler},
+		log:        log.With(log.Any("storage", "azure")),
+	}
+	if cfg.Token != "" {
+		h.sas, err = url.ParseQuery(strings.TrimPrefix(cfg.Token, "?"))
+	} else {
+		h.key, err = base64.StdEncoding.DecodeString(cfg.Sk)
+		if err == nil && len(h.key) == 0 {
+			err = errors.New("sk is required as the account key if token is not set")
+		}
+	}
+	if err != nil {
+		return nil, errors.Errorf("failed to create azure client (%s): %s", cfg.Name, err.Error())
+	}
+	h.resumer = newResumer(cfg.MultiPart, h, h.log)
+	return h, nil
+}
+
+// PutObjectFromFile upload file as block blob, the file larger than part size is uploaded in blocks, returns the etag
+func (cli *AzureHandler) PutObjectFromFile(Bucket, remotePath, filename string, meta map[string]string) (string, error) {
+	md5, err := utils.CalculateFileMD5(filename)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	sum, err := hex.DecodeString(md5)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	contentMD5 := base64.StdEncoding.EncodeToString(sum)
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if cli.cfg.MultiPart.PartSize <= 0 || fi.Size() <= cli.cfg.MultiPart.PartSize {
+		req, err := cli.request(http.MethodPut, Bucket, remotePath, nil, cli.body(f, 0, fi.Size()), fi.Size())
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		req.Header.Set("x-ms-blob-type", "BlockBlob")
+		req.Header.Set("Content-MD5", contentMD5)
+		setAzureMeta(req, meta)
+		header, err := cli.send(req)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		return strings.Trim(header.Get("ETag"), "\""), nil
+	}
+	_, err = cli.resumer.upload(Bucket, remotePath, filename, meta)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	// the md5 of blob committed from blocks is not calculated by azure
+	req, err := cli.request(http.MethodPut, Bucket, remotePath, url.Values{"comp": {"properties"}}, nil, 0)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	req.Header.Set("x-ms-blob-content-md5", contentMD5)
+	header, err := cli.send(req)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return strings.Trim(header.Get("ETag"), "\""), nil
+}
+
+// InitMultipartUpload returns a new id as the prefix of block ids, azure has no upload id
+func (cli *AzureHandler) InitMultipartUpload(Bucket, remotePath string, meta map[string]string) (string, error) {
+	return uuid.Generate().String(), nil
+}
+
+// UploadPart uploads the part as an uncommitted block, returns the block id
+func (cli *AzureHandler) UploadPart(Bucket, remotePath, uploadID string, number int, f *os.File, off, size int64) (string, error) {
+	// the block ids of blob must be in the same length
+	id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s-%06d", uploadID, number)))
+	req, err := cli.request(http.MethodPut, Bucket, remotePath, url.Values{"comp": {"block"}, "blockid": {id}}, cli.body(f, off, size), size)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	_, err = cli.send(req)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return id, nil
+}
+
+// CompleteMultipartUpload commits the blocks with the meta, returns the etag
+func (cli *AzureHandler) CompleteMultipartUpload(Bucket, remotePath, uploadID string, parts []Part, meta map[string]string) (string, error) {
+	list := struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string `xml:"Latest"`
+	}{}
+	for _, p := range parts {
+		list.Latest = append(list.Latest, p.ETag)
+	}
+	data, err := xml.Marshal(list)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	data = append([]byte(xml.Header), data...)
+	req, err := cli.request(http.MethodPut, Bucket, remotePath, url.Values{"comp": {"blocklist"}}, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	setAzureMeta(req, meta)
+	header, err := cli.send(req)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return strings.Trim(header.Get("ETag"), "\""), nil
+}
+
+// AbortMultipartUpload does nothing, the uncommitted blocks are garbage collected by azure
+func (cli *AzureHandler) AbortMultipartUpload(Bucket, remotePath, uploadID string) error {
+	return nil
+}
+
+// GetObjectToFile download file
+func (cli *AzureHandler) GetObjectToFile(Bucket, remotePath, filename string) error {
+	req, err := cli.request(http.MethodGet, Bucket, remotePath, nil, nil, 0)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cli.sign(req)
+	return errors.Trace(cli.rest.download(req, filename))
+}
+
+// DeleteObject delete object
+func (cli *AzureHandler) DeleteObject(Bucket, remotePath string) error {
+	req, err := cli.request(http.MethodDelete, Bucket, remotePath, nil, nil, 0)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = cli.send(req)
+	if isNotFound(err) {
+		return nil
+	}
+	return errors.Trace(err)
+}
+
+// FileExists checks the Content-MD5 of blob
+func (cli *AzureHandler) FileExists(Bucket, remotePath, md5 string) bool {
+	req, err := cli.request(http.MethodHead, Bucket, remotePath, nil, nil, 0)
+	if err != nil {
+		return false
+	}
+	header, err := cli.send(req)
+	if err != nil {
+		if !isNotFound(err) {
+			cli.log.Warn("failed to get object meta", log.Error(err))
+		}
+		return false
+	}
+	sum, err := base64.StdEncoding.DecodeString(header.Get("Content-MD5"))
+	if err != nil || len(sum) == 0 {
+		return false
+	}
+	return strings.EqualFold(hex.EncodeToString(sum), md5)
+}
+
+func (cli *AzureHandler) RefreshSts() (*v1.STSResponse, error) {
+	return nil, nil
+}
+
+// body returns the section of file as request body, which is read no faster than the throttlers
+func (cli *AzureHandler) body(f *os.File, off, size int64) io.Reader {
+	return ioutil.NopCloser(throttled(io.NewSectionReader(f, off, size), cli.throttlers...))
+}
+
+// request creates the request of blob with the sas token if set
+func (cli *AzureHandler) request(method, Bucket, remotePath string, query url.Values, body io.Reader, size int64) (*http.Request, error) {
+	u := *cli.endpoint
+	u.Path = u.Path + "/" + Bucket + "/" + strings.TrimPrefix(remotePath, "/")
+	q := url.Values{}
+	for k, v := range cli.sas {
+		q[k] = v
+	}
+	for k, v := range query {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	if body == nil || size == 0 {
+		body = http.NoBody
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	req.ContentLength = size
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", azureVersion)
+	return req, nil
+}
+
+// send signs the request and sends it, returns the header of response
+func (cli *AzureHandler) send(req *http.Request) (http.Header, error) {
+	cli.sign(req)
+	return cli.rest.discard(req)
+}
+
+// sign signs the request by shared key, see https://docs.microsoft.com/rest/api/storageservices/authorize-with-shared-key
+func (cli *AzureHandler) sign(req *http.Request) {
+	if cli.key == nil {
+		return
+	}
+	length := ""
+	if req.ContentLength > 0 {
+		length = fmt.Sprint(req.ContentLength)
+	}
+	var headers []string
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-ms-") {
+			headers = append(headers, k+":"+strings.TrimSpace(strings.Join(v, ",")))
+		}
+	}
+	sort.Strings(headers)
+	resource := "/" + cli.account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	var names []string
+	for k := range query {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		v := query[k]
+		sort.Strings(v)
+		resource += "\n" + strings.ToLower(k) + ":" + strings.Join(v, ",")
+	}
+	s := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		length,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // date, x-ms-date is used
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	}, "\n") + "\n" + strings.Join(headers, "\n") + "\n" + resource
+	mac := hmac.New(sha256.New, cli.key)
+	mac.Write([]byte(s))
+	req.Header.Set("Authorization", "SharedKey "+cli.account+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// setAzureMeta sets the user meta of blob, the names of meta must be valid c# identifiers
+func setAzureMeta(req *http.Request, meta map[string]string) {
+	for k, v := range meta {
+		req.Header.Set("x-ms-meta-"+k, v)
+	}
+}
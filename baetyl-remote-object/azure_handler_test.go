@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockAzure a minimal blob service of emulator
+type mockAzure struct {
+	blobs  map[string][]byte
+	md5s   map[string]string
+	metas  map[string]http.Header
+	blocks map[string][]byte
+	auths  []string
+	lock   sync.Mutex
+}
+
+func newMockAzure() *mockAzure {
+	return &mockAzure{
+		blobs:  map[string][]byte{},
+		md5s:   map[string]string{},
+		metas:  map[string]http.Header{},
+		blocks: map[string][]byte{},
+	}
+}
+
+func (m *mockAzure) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if r.Header.Get("x-ms-version") == "" || r.Header.Get("x-ms-date") == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	m.auths = append(m.auths, r.Header.Get("Authorization")+r.URL.Query().Get("sig"))
+	name := strings.TrimPrefix(r.URL.Path, "/devstoreaccount1/")
+	q := r.URL.Query()
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := ioutil.ReadAll(r.Body)
+		switch q.Get("comp") {
+		case "":
+			sum := md5.Sum(data)
+			if r.Header.Get("Content-MD5") != base64.StdEncoding.EncodeToString(sum[:]) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			m.blobs[name], m.md5s[name], m.metas[name] = data, r.Header.Get("Content-MD5"), r.Header
+		case "block":
+			m.blocks[name+q.Get("blockid")] = data
+		case "blocklist":
+			var list struct {
+				Latest []string `xml:"Latest"`
+			}
+			xml.Unmarshal(data, &list)
+			var blob []byte
+			for _, id := range list.Latest {
+				blob = append(blob, m.blocks[name+id]...)
+			}
+			m.blobs[name], m.md5s[name], m.metas[name] = blob, "", r.Header
+		case "properties":
+			m.md5s[name] = r.Header.Get("x-ms-blob-content-md5")
+		}
+		w.Header().Set("ETag", "\"0x8D\"")
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet, http.MethodHead:
+		blob, ok := m.blobs[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if m.md5s[name] != "" {
+			w.Header().Set("Content-MD5", m.md5s[name])
+		}
+		w.Write(blob)
+	case http.MethodDelete:
+		if _, ok := m.blobs[name]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(m.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func TestAzureHandler(t *testing.T) {
+	m := newMockAzure()
+	s := httptest.NewServer(m)
+	defer s.Close()
+
+	c := *cfg
+	c.Kind = Azure
+	c.Endpoint = s.URL + "/devstoreaccount1"
+	c.Ak = "devstoreaccount1"
+	c.Sk = "aGVsbG8="
+	c.Token = ""
+	c.MultiPart = MultiPart{PartSize: 4, Concurrency: 2}
+	h, err := NewObjectStorageHandler(nil, c)
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	small := path.Join(dir, "small.txt")
+	assert.NoError(t, ioutil.WriteFile(small, []byte("abc"), 0644))
+	large := path.Join(dir, "large.txt")
+	assert.NoError(t, ioutil.WriteFile(large, []byte("0123456789"), 0644))
+
+	// round 1: put blob
+	etag, err := h.PutObjectFromFile("container", "a/small.txt", small, map[string]string{"k": "v"})
+	assert.NoError(t, err)
+	assert.Equal(t, "0x8D", etag)
+	assert.Equal(t, "v", m.metas["container/a/small.txt"].Get("x-ms-meta-k"))
+	assert.True(t, strings.HasPrefix(m.auths[0], "SharedKey devstoreaccount1:"))
+	sum, err := utils.CalculateFileMD5(small)
+	assert.NoError(t, err)
+	assert.True(t, h.FileExists("container", "a/small.txt", sum))
+	assert.False(t, h.FileExists("container", "a/small.txt", "0123"))
+	assert.False(t, h.FileExists("container", "a/none.txt", sum))
+
+	// round 2: put blob in blocks
+	_, err = h.PutObjectFromFile("container", "a/large.txt", large, map[string]string{"k": "v"})
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789", string(m.blobs["container/a/large.txt"]))
+	assert.Len(t, m.blocks, 3)
+	assert.Equal(t, "v", m.metas["container/a/large.txt"].Get("x-ms-meta-k"))
+	sum, err = utils.CalculateFileMD5(large)
+	assert.NoError(t, err)
+	assert.True(t, h.FileExists("container", "a/large.txt", sum))
+
+	// round 3: get and delete blob
+	dst := path.Join(dir, "dst.txt")
+	assert.NoError(t, h.GetObjectToFile("container", "a/large.txt", dst))
+	data, err := ioutil.ReadFile(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data))
+	assert.Error(t, h.GetObjectToFile("container", "a/none.txt", dst))
+	assert.NoError(t, h.DeleteObject("container", "a/large.txt"))
+	assert.NoError(t, h.DeleteObject("container", "a/large.txt"))
+	assert.False(t, h.FileExists("container", "a/large.txt", sum))
+
+	// round 4: sas token
+	c.Sk = ""
+	c.Token = "?sv=2019-12-12&sig=signature"
+	h, err = NewObjectStorageHandler(nil, c)
+	assert.NoError(t, err)
+	_, err = h.PutObjectFromFile("container", "a/small.txt", small, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "signature", m.auths[len(m.auths)-1])
+
+	// the token is not leaked by the transport error
+	s.Close()
+	_, err = h.PutObjectFromFile("container", "a/small.txt", small, nil)
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "signature")
+
+	c.Token = ""
+	_, err = NewObjectStorageHandler(nil, c)
+	assert.Error(t, err)
+}
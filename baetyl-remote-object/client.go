@@ -2,6 +2,9 @@ package main
 
 import (
 	"compress/flate"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
 	"os"
 	"path"
 	"path/filepath"
@@ -19,7 +22,10 @@ import (
 	"github.com/panjf2000/ants"
 )
 
-type ruleHook func(msg *EventMessage, err error)
+// the extension of the file encrypted and kept in temp path for resumable upload
+const stagedExt = ".enc"
+
+type ruleHook func(msg *EventMessage, res *Result, err error)
 
 // Task StorageClient
 type Task struct {
@@ -37,16 +43,22 @@ type FileStats struct {
 
 // Client ObjectClient
 type Client struct {
-	cfg     ClientInfo
-	handler StorageHandler
-	stats   Stats
-	pwd     string
-	fs      *FileStats
-	arch    archiver.Archiver
-	log     *log.Logger
-	pool    *ants.PoolWithFunc
-	lock    sync.Mutex
-	tomb    utils.Tomb
+	cfg      ClientInfo
+	handler  StorageHandler
+	stats    Stats
+	pwd      string
+	fs       *FileStats
+	arch     archiver.Archiver
+	log      *log.Logger
+	pool     *ants.PoolWithFunc
+	journal  *journal
+	tpl      *pathTemplate
+	crypter  *crypter // encrypts the files before upload if encryption enabled
+	deferred []*Task
+	syncing  sync.Map // the index files of syncs in progress
+	lock     sync.Mutex
+	dlock    sync.Mutex
+	tomb     utils.Tomb
 }
 
 // NewClient creates a new object client
@@ -59,6 +71,9 @@ func NewClient(ctx context.Context, cfg ClientInfo) (*Client, error) {
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	if _, ok := handler.(OptionsHandler); !ok && !cfg.Object.empty() {
+		return nil, errors.Errorf("object options unsupported by kind (%s)", cfg.Kind)
+	}
 	cli := &Client{
 		cfg:     cfg,
 		pwd:     pwd,
@@ -87,34 +102,147 @@ func NewClient(ctx context.Context, cfg ClientInfo) (*Client, error) {
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	cli.tpl, err = newPathTemplate(cfg.PathTemplate, ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if cfg.Encryption.Enable {
+		cli.crypter, err = newCrypter(cfg.Encryption)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	// the tasks deferred by schedule or not before are always persisted in journal
+	cli.journal, err = newJournal(cfg.Journal, cfg.Name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 	p, err := ants.NewPoolWithFunc(cli.cfg.Pool.Worker, cli.call, ants.WithExpiryDuration(cli.cfg.Pool.Idletime))
 	if err != nil {
 		return nil, errors.Errorf("failed to create a pool: %s", err.Error())
 	}
 	cli.pool = p
 
-	cli.tomb.Go(cli.recording)
+	cli.tomb.Go(cli.recording, cli.scheduling)
 	cli.log.Debug("client starts")
 	return cli, nil
 }
 
-// CallAsync submit task
+// CallAsync submit task, the task is deferred if not due
 func (cli *Client) CallAsync(msg *EventMessage, cb ruleHook) error {
-	if cli.pool.Running() == cli.cfg.Pool.Worker {
-		cb(msg, errors.New("failed to submit task: no worker can be used"))
-		return nil
-	}
 	task := &Task{
 		msg: msg,
 		cb:  cb,
 	}
+	if due := cli.due(msg.Event, time.Now()); !due.IsZero() {
+		cli.log.Info("task deferred", log.Any("task", msg.JournalID), log.Any("due", due))
+		cli.finish(msg, TaskDeferred, nil)
+		cli.dlock.Lock()
+		cli.deferred = append(cli.deferred, task)
+		cli.dlock.Unlock()
+		return nil
+	}
+	return cli.submit(task)
+}
+
+// due returns the time when the event can be executed, zero if it can be executed now
+func (cli *Client) due(e *Event, now time.Time) time.Time {
+	if e == nil {
+		return time.Time{}
+	}
+	t := now
+	if e.NotBefore != nil && e.NotBefore.After(t) {
+		t = *e.NotBefore
+	}
+	// the urgent event bypasses the schedule if no window opens before its deadline
+	if open := cli.cfg.Schedule.next(t); e.Deadline == nil || open.Before(*e.Deadline) {
+		t = open
+	}
+	if t.After(now) {
+		return t
+	}
+	return time.Time{}
+}
+
+func (cli *Client) submit(task *Task) error {
+	msg, cb := task.msg, task.cb
+	if cli.pool.Running() == cli.cfg.Pool.Worker {
+		err := errors.New("failed to submit task: no worker can be used")
+		cli.finish(msg, TaskFailed, err)
+		cb(msg, newResult(msg, nil, err), err)
+		return nil
+	}
 	if err := cli.pool.Invoke(task); err != nil {
-		cb(msg, errors.Errorf("failed to invoke pool task: %s", err.Error()))
+		err = errors.Errorf("failed to invoke pool task: %s", err.Error())
+		cli.finish(msg, TaskFailed, err)
+		cb(msg, newResult(msg, nil, err), err)
+		return nil
+	}
+	return nil
+}
+
+// Journal persists the event message of rule before it is acknowledged, returns false if the journal
+// of client is not enabled and the message isn't deferred. The message deferred by schedule or not before
+// is always persisted, so it is acknowledged at once and survives restart
+func (cli *Client) Journal(rule string, msg *EventMessage) (bool, error) {
+	if cli.journal == nil {
+		return false, nil
+	}
+	if !cli.cfg.Journal.Enable && len(cli.cfg.Schedule.Windows) == 0 && cli.due(msg.Event, time.Now()).IsZero() {
+		return false, nil
+	}
+	return true, cli.journal.add(rule, msg)
+}
+
+// Prune removes the pending tasks of rules not journaled by the client any more from journal
+func (cli *Client) Prune(rules []string) error {
+	if cli.journal == nil {
 		return nil
 	}
+	n, err := cli.journal.prune(rules)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if n > 0 {
+		cli.log.Warn("pending tasks of removed rules pruned from journal", log.Any("count", n))
+	}
 	return nil
 }
 
+// Pending returns the tasks of rule queued or interrupted before restart in journal
+func (cli *Client) Pending(rule string) ([]*EventMessage, error) {
+	if cli.journal == nil {
+		return nil, nil
+	}
+	return cli.journal.pending(rule)
+}
+
+// Resume submits the tasks of rule queued or interrupted before restart
+func (cli *Client) Resume(rule string, cb ruleHook) error {
+	msgs, err := cli.Pending(rule)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, msg := range msgs {
+		cli.log.Info("resume task from journal", log.Any("rule", rule), log.Any("task", msg.JournalID))
+		err = cli.CallAsync(msg, cb)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// finish transits the state of task in journal if journaled
+func (cli *Client) finish(msg *EventMessage, state TaskState, err error) {
+	if cli.journal == nil || msg.JournalID == "" {
+		return
+	}
+	if e := cli.journal.update(msg.JournalID, state, err); e != nil {
+		cli.log.Error("failed to update journal", log.Any("task", msg.JournalID), log.Error(e))
+	}
+}
+
 func (cli *Client) call(task interface{}) {
 	t, ok := task.(*Task)
 	if !ok {
@@ -122,30 +250,64 @@ func (cli *Client) call(task interface{}) {
 		return
 	}
 	var err error
-	switch t.msg.Event.Type {
-	case Upload:
-		uploadEvent, ok := t.msg.Event.Content.(*UploadEvent)
-		if !ok {
-			log.Error(errors.New("failed to convert interface{} to *UploadEvent"))
-			return
-		}
-		err = cli.handleUploadEvent(uploadEvent)
-	default:
-		err = errors.New("EventMessage type unexpected")
+	var res *Result
+	start := time.Now()
+	cli.finish(t.msg, TaskRunning, nil)
+	if d := t.msg.Event.Deadline; d != nil && start.After(*d) {
+		err = errors.Errorf("deadline (%s) exceeded", d.Format(time.RFC3339))
+	} else {
+		res, err = cli.handle(t.msg.Event)
 	}
 	if err != nil {
 		cli.log.Error("error occurred in Client.call", log.Error(err))
+		cli.finish(t.msg, TaskFailed, err)
+	} else {
+		cli.finish(t.msg, TaskSucceeded, nil)
 	}
 	if t.cb != nil {
-		t.cb(t.msg, err)
+		res = newResult(t.msg, res, err)
+		res.Duration = int64(time.Since(start) / time.Millisecond)
+		t.cb(t.msg, res, err)
 	}
 }
 
-// upload upload object to service(BOS, CEPH or AWS S3)
-func (cli *Client) upload(f, remotePath string, meta map[string]string) error {
+// handle executes the event
+func (cli *Client) handle(e *Event) (*Result, error) {
+	switch e.Type {
+	case Upload:
+		uploadEvent, ok := e.Content.(*UploadEvent)
+		if !ok {
+			return nil, errors.New("failed to convert interface{} to *UploadEvent")
+		}
+		return cli.handleUploadEvent(uploadEvent)
+	case Package:
+		packageEvent, ok := e.Content.(*PackageEvent)
+		if !ok {
+			return nil, errors.New("failed to convert interface{} to *PackageEvent")
+		}
+		return cli.handlePackageEvent(packageEvent)
+	case Download:
+		downloadEvent, ok := e.Content.(*DownloadEvent)
+		if !ok {
+			return nil, errors.New("failed to convert interface{} to *DownloadEvent")
+		}
+		return cli.handleDownloadEvent(downloadEvent)
+	case Sync:
+		syncEvent, ok := e.Content.(*SyncEvent)
+		if !ok {
+			return nil, errors.New("failed to convert interface{} to *SyncEvent")
+		}
+		return cli.handleSyncEvent(syncEvent)
+	default:
+		return nil, errors.New("EventMessage type unexpected")
+	}
+}
+
+// refreshSts refreshes the sts of client and returns the remote path with default path
+func (cli *Client) refreshSts(remotePath string) (string, error) {
 	res, err := cli.handler.RefreshSts()
 	if err != nil {
-		return errors.Trace(err)
+		return "", errors.Trace(err)
 	}
 	if cli.cfg.Name == MinioStsCli {
 		cli.cfg.DefaultPath = res.Namespace + "/" + res.NodeName
@@ -157,51 +319,182 @@ func (cli *Client) upload(f, remotePath string, meta map[string]string) error {
 		cli.cfg.Token = res.Token
 		remotePath = cli.cfg.DefaultPath + "/" + remotePath
 	}
+	return remotePath, nil
+}
+
+// upload upload object to service(BOS, CEPH or AWS S3), the bucket of client is used if bucket is empty,
+// the options override the object options of client
+func (cli *Client) upload(f, bucket, remotePath string, meta map[string]string, opts *ObjectOptions) (res *Result, err error) {
+	remotePath, err = cli.refreshSts(remotePath)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if bucket == "" {
+		bucket = cli.cfg.Bucket
+	}
 	fsize, md5 := cli.fileSizeMd5(f)
-	if cli.handler.FileExists(cli.cfg.Bucket, remotePath, md5) {
-		return nil
+	res = &Result{
+		Bucket:     bucket,
+		RemotePath: remotePath,
+		Size:       fsize,
+		MD5:        md5,
+	}
+	opts, err = cli.objectOptions(f, remotePath, opts)
+	if err != nil {
+		return res, errors.Trace(err)
+	}
+	if cli.crypter != nil {
+		// the object encrypted never matches the file, so it is checked by the record of the file uploaded
+		if r := cli.crypter.uploaded(bucket, remotePath, md5); r != nil && cli.fileExists(bucket, remotePath, r.MD5, r.Size, opts) {
+			res.Status = StatusSkipped
+			return res, nil
+		}
+		var t string
+		var m map[string]string
+		var kept bool
+		t, m, kept, err = cli.encrypt(f, bucket, remotePath, fsize, md5, meta)
+		if err != nil {
+			return res, errors.Trace(err)
+		}
+		tsize, tmd5 := cli.fileSizeMd5(t)
+		// the file kept is removed once uploaded
+		defer func() {
+			if err == nil {
+				if e := cli.crypter.recordUploaded(bucket, remotePath, md5, tmd5, tsize); e != nil {
+					cli.log.Warn("failed to record file encrypted", log.Any("remotePath", remotePath), log.Error(e))
+				}
+			}
+			if !kept || err == nil {
+				os.Remove(t)
+			}
+		}()
+		f, meta = t, m
+	} else if cli.fileExists(bucket, remotePath, md5, fsize, opts) {
+		res.Status = StatusSkipped
+		return res, nil
 	}
 	if cli.cfg.Limit.Enable {
 		month := time.Unix(0, time.Now().UnixNano()).Format("2006-01")
 		err = cli.checkData(fsize, month)
 		if err != nil {
 			atomic.AddUint64(&cli.fs.limit, 1)
-			return errors.Errorf("failed to pass data check: %s", err.Error())
+			return res, errors.Errorf("failed to pass data check: %s", err.Error())
 		}
-		err = cli.putObjectWithStats(cli.cfg.Bucket, remotePath, f, meta)
+		res.ETag, err = cli.putObjectWithStats(bucket, remotePath, f, meta, opts)
 		if err != nil {
-			return err
+			return res, err
 		}
-		return cli.increaseData(fsize, month)
+		return res, cli.increaseData(fsize, month)
 	}
-	err = cli.putObjectWithStats(cli.cfg.Bucket, remotePath, f, meta)
+	res.ETag, err = cli.putObjectWithStats(bucket, remotePath, f, meta, opts)
 	if err != nil {
-		return errors.Trace(err)
+		return res, errors.Trace(err)
 	}
-	return nil
+	return res, nil
 }
 
-func (cli *Client) putObjectWithStats(bucket, remotePath, f string, meta map[string]string) error {
-	err := cli.handler.PutObjectFromFile(bucket, remotePath, f, meta)
+// encrypt encrypts the file to upload. If the multipart upload is resumable, the file encrypted is kept in temp path
+// by the object and the content of file until uploaded, so the upload retried sends the same content and resumes
+// from the checkpoint. Returns the file encrypted and whether it is kept
+func (cli *Client) encrypt(f, bucket, remotePath string, size int64, sum string, meta map[string]string) (string, map[string]string, bool, error) {
+	if !cli.cfg.MultiPart.Resume {
+		t, m, err := cli.crypter.encrypt(f, cli.cfg.TempPath, meta)
+		return t, m, false, errors.Trace(err)
+	}
+	cli.cleanStaged()
+	key := md5.Sum([]byte(fmt.Sprintf("%s/%s\x00%d\x00%s\x00%s", bucket, remotePath, size, sum, cli.crypter.keyID)))
+	staged := path.Join(cli.cfg.TempPath, hex.EncodeToString(key[:])+stagedExt)
+	if utils.FileExists(staged) {
+		cli.log.Debug("reuse the file encrypted", log.Any("localFile", f), log.Any("staged", staged))
+		return staged, cli.crypter.meta(meta), true, nil
+	}
+	t, m, err := cli.crypter.encrypt(f, cli.cfg.TempPath, meta)
+	if err != nil {
+		return "", nil, false, errors.Trace(err)
+	}
+	if err = os.Rename(t, staged); err != nil {
+		os.Remove(t)
+		return "", nil, false, errors.Trace(err)
+	}
+	return staged, m, true, nil
+}
+
+// cleanStaged removes the files encrypted and kept longer than the expiration of multipart upload,
+// whose checkpoints are aborted already
+func (cli *Client) cleanStaged() {
+	if cli.cfg.MultiPart.Expiration <= 0 {
+		return
+	}
+	files, err := filepath.Glob(path.Join(cli.cfg.TempPath, "*"+stagedExt))
+	if err != nil {
+		return
+	}
+	deadline := time.Now().Add(-cli.cfg.MultiPart.Expiration)
+	for _, file := range files {
+		if fi, err := os.Stat(file); err == nil && fi.ModTime().Before(deadline) {
+			os.Remove(file)
+		}
+	}
+}
+
+// objectOptions merges the options into the object options of client and detects the content type if not set,
+// nil is returned if no options set and the options are not supported by handler
+func (cli *Client) objectOptions(f, remotePath string, opts *ObjectOptions) (*ObjectOptions, error) {
+	res, err := cli.mergeOptions(opts)
+	if err != nil || res == nil {
+		return nil, errors.Trace(err)
+	}
+	if res.ContentType == "" {
+		// the content of object encrypted by client is never the one of file
+		if cli.crypter != nil {
+			res.ContentType = octetStream
+		} else {
+			res.ContentType = detectContentType(f, remotePath)
+		}
+	}
+	return res, nil
+}
+
+// mergeOptions merges the options into the object options of client,
+// nil is returned if no options set and the options are not supported by handler
+func (cli *Client) mergeOptions(opts *ObjectOptions) (*ObjectOptions, error) {
+	if _, ok := cli.handler.(OptionsHandler); !ok {
+		if !opts.empty() || !cli.cfg.Object.empty() {
+			return nil, errors.Errorf("object options unsupported by kind (%s)", cli.cfg.Kind)
+		}
+		return nil, nil
+	}
+	res, err := cli.cfg.Object.merge(opts)
+	return res, errors.Trace(err)
+}
+
+func (cli *Client) putObjectWithStats(bucket, remotePath, f string, meta map[string]string, opts *ObjectOptions) (string, error) {
+	var etag string
+	var err error
+	if h, ok := cli.handler.(OptionsHandler); ok && opts != nil {
+		etag, err = h.PutObjectFromFileWithOptions(bucket, remotePath, f, meta, opts)
+	} else {
+		etag, err = cli.handler.PutObjectFromFile(bucket, remotePath, f, meta)
+	}
 	if err != nil {
 		cli.log.Error("failed to put object from file", log.Any("localFile", f), log.Any("remotePath", remotePath), log.Any("bucket", bucket), log.Error(err))
 		atomic.AddUint64(&cli.fs.fail, 1)
-		return errors.Trace(err)
+		return "", errors.Trace(err)
 	}
 	cli.log.Info("put object from file successfully", log.Any("localFile", f), log.Any("remotePath", remotePath), log.Any("bucket", bucket))
 	atomic.AddUint64(&cli.fs.success, 1)
-	return nil
+	return etag, nil
 }
 
-func (cli *Client) handleUploadEvent(e *UploadEvent) error {
+func (cli *Client) handleUploadEvent(e *UploadEvent) (*Result, error) {
 	if strings.Contains(e.LocalPath, "..") {
-		return errors.Errorf("failed to pass LocalPath (%s) check: the local path can't contains ..", e.LocalPath)
+		return nil, errors.Errorf("failed to pass LocalPath (%s) check: the local path can't contains ..", e.LocalPath)
 	}
 	var t string
 	p, err := filepath.EvalSymlinks(path.Join(cli.pwd, e.LocalPath))
 	if err != nil {
 		atomic.AddUint64(&cli.fs.deleted, 1)
-		return errors.Errorf("failed get real dir path: %s", err.Error())
+		return nil, errors.Errorf("failed get real dir path: %s", err.Error())
 	}
 	if ok := utils.FileExists(p); ok {
 		if e.Zip {
@@ -232,7 +525,7 @@ func (cli *Client) handleUploadEvent(e *UploadEvent) error {
 		}
 	} else {
 		atomic.AddUint64(&cli.fs.deleted, 1)
-		return errors.Errorf("failed to find path: %s", p)
+		return nil, errors.Errorf("failed to find path: %s", p)
 	}
 	if t != p {
 		defer os.RemoveAll(t)
@@ -240,11 +533,11 @@ func (cli *Client) handleUploadEvent(e *UploadEvent) error {
 
 	if cli.arch != nil {
 		if err := cli.arch.Archive([]string{p}, t); err != nil {
-			return errors.Errorf("failed to zip/tar dir: %s", err.Error())
+			return nil, errors.Errorf("failed to zip/tar dir: %s", err.Error())
 		}
 	}
 
-	return cli.upload(t, e.RemotePath, e.Meta)
+	return cli.upload(t, e.Bucket, e.RemotePath, e.Meta, e.Options)
 }
 
 func (cli *Client) fileSizeMd5(f string) (int64, string) {
@@ -309,6 +602,41 @@ func (cli *Client) recording() error {
 	}
 }
 
+// scheduling submits the deferred tasks once they are due
+func (cli *Client) scheduling() error {
+	defer cli.log.Debug("client scheduling task stopped")
+	t := time.NewTicker(cli.cfg.Schedule.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-cli.tomb.Dying():
+			return nil
+		case <-t.C:
+			cli.schedule(time.Now())
+		}
+	}
+}
+
+func (cli *Client) schedule(now time.Time) {
+	var due []*Task
+	cli.dlock.Lock()
+	deferred := cli.deferred[:0]
+	for _, t := range cli.deferred {
+		if cli.due(t.msg.Event, now).IsZero() {
+			due = append(due, t)
+		} else {
+			deferred = append(deferred, t)
+		}
+	}
+	cli.deferred = deferred
+	cli.dlock.Unlock()
+	for _, t := range due {
+		if err := cli.submit(t); err != nil {
+			cli.log.Error("failed to submit deferred task", log.Error(err))
+		}
+	}
+}
+
 // Close close client and all worker
 func (cli *Client) Close() error {
 	cli.log.Info("client starts to close")
@@ -318,3 +646,15 @@ func (cli *Client) Close() error {
 	cli.tomb.Kill(nil)
 	return cli.tomb.Wait()
 }
+
+// fileExists checks whether the object is the file, by the size as well if supported by the storage,
+// the object is read with the options it is uploaded with if set
+func (cli *Client) fileExists(bucket, remotePath, md5 string, size int64, opts *ObjectOptions) bool {
+	if h, ok := cli.handler.(OptionsHandler); ok && opts != nil {
+		return h.FileExistsWithOptions(bucket, remotePath, md5, opts)
+	}
+	if h, ok := cli.handler.(SizeChecker); ok {
+		return h.FileExistsWithSize(bucket, remotePath, md5, size)
+	}
+	return cli.handler.FileExists(bucket, remotePath, md5)
+}
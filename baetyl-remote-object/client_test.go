@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"github.com/baetyl/baetyl-go/v2/context"
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/panjf2000/ants"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -85,7 +87,7 @@ func TestCallAsync(t *testing.T) {
 	assert.Nil(t, err)
 }
 
-func mockRuleHook(msg *EventMessage, err error) {
+func mockRuleHook(msg *EventMessage, res *Result, err error) {
 	return
 }
 
@@ -158,14 +160,14 @@ func TestUpload(t *testing.T) {
 	defer storageClient.Close()
 
 	// round 1: local file is not exist
-	err = storageClient.upload("var/test/file", "default", map[string]string{})
+	_, err = storageClient.upload("var/test/file", "", "default", map[string]string{}, nil)
 	assert.Error(t, err, "open var/test/file: no such file or directory")
 
 	// round 2: file exists without limit data
 	storageClient.cfg.Bucket = "Bucket"
 	storageClient.cfg.MultiPart.PartSize = 1048576000
 	storageClient.cfg.MultiPart.Concurrency = 10
-	err = storageClient.upload("./example/etc/baetyl/service-bos.yml", "var/file/service.yml", map[string]string{})
+	_, err = storageClient.upload("./example/etc/baetyl/service-bos.yml", "", "var/file/service.yml", map[string]string{}, nil)
 	assert.Error(t, err)
 
 	// round 3: file exists with limit data
@@ -177,7 +179,7 @@ func TestUpload(t *testing.T) {
 			Bytes: 21234345,
 			Count: 20,
 		}}
-	err = storageClient.upload("./example/test/baetyl/service.yml", "var/file/service.yml", map[string]string{})
+	_, err = storageClient.upload("./example/test/baetyl/service.yml", "", "var/file/service.yml", map[string]string{}, nil)
 	assert.Error(t, err)
 }
 
@@ -214,7 +216,7 @@ func TestHandleUploadEvent(t *testing.T) {
 
 	// wrong path
 	e.LocalPath = "../example/etc/baetyl/service-bos.yml"
-	err = storageClient.handleUploadEvent(e)
+	_, err = storageClient.handleUploadEvent(e)
 	assert.Error(t, err)
 
 	// real path
@@ -222,26 +224,26 @@ func TestHandleUploadEvent(t *testing.T) {
 	storageClient.cfg.Bucket = "Bucket"
 	storageClient.cfg.MultiPart.PartSize = 1048576000
 	storageClient.cfg.MultiPart.Concurrency = 10
-	err = storageClient.handleUploadEvent(e)
+	_, err = storageClient.handleUploadEvent(e)
 	assert.Error(t, err)
 
 	// zip is true, upload file
 	e.Zip = true
 	e.RemotePath = "var/file/test.zip"
-	err = storageClient.handleUploadEvent(e)
+	_, err = storageClient.handleUploadEvent(e)
 	assert.NotNil(t, err)
 	assert.Equal(t, "failed to zip/tar dir: checking extension: filename must have a .zip extension", err.Error())
 
 	// zip is true, upload directory
 	e.LocalPath = "./example"
-	err = storageClient.handleUploadEvent(e)
+	_, err = storageClient.handleUploadEvent(e)
 	assert.NotNil(t, err)
 	assert.Equal(t, "failed to zip/tar dir: checking extension: filename must have a .zip extension", err.Error())
 
 	// zip is false, tar directory and upload
 	e.Zip = false
 	e.RemotePath = "var/file/test.tar"
-	err = storageClient.handleUploadEvent(e)
+	_, err = storageClient.handleUploadEvent(e)
 	assert.NotNil(t, err)
 	assert.Equal(t, "failed to zip/tar dir: checking extension: filename must have a .tar extension", err.Error())
 }
@@ -376,3 +378,146 @@ func TestStartAndClose(t *testing.T) {
 	err = storageClient.Close()
 	assert.NoError(t, err)
 }
+
+func TestCallResult(t *testing.T) {
+	cli, _ := newMockClient(t)
+	assert.NoError(t, os.MkdirAll(cli.pwd, 0755))
+	assert.NoError(t, copyFile("example/etc/baetyl/service-bos.yml", path.Join(cli.pwd, "service.yml")))
+	md5, err := utils.CalculateFileMD5(path.Join(cli.pwd, "service.yml"))
+	assert.NoError(t, err)
+
+	var got *Result
+	cb := func(msg *EventMessage, res *Result, err error) {
+		got = res
+	}
+	cli.call(&Task{
+		msg: &EventMessage{
+			Event: &Event{
+				Type:      Upload,
+				RequestID: "r1",
+				Content:   &UploadEvent{RemotePath: "a/service.yml", LocalPath: "service.yml"},
+			},
+		},
+		cb: cb,
+	})
+	assert.NotNil(t, got)
+	assert.Equal(t, "r1", got.RequestID)
+	assert.Equal(t, Upload, got.Type)
+	assert.Equal(t, StatusSucceeded, got.Status)
+	assert.Equal(t, "bucket", got.Bucket)
+	assert.Equal(t, "a/service.yml", got.RemotePath)
+	assert.Equal(t, md5, got.MD5)
+	assert.Equal(t, "etag-1", got.ETag)
+
+	cli.call(&Task{
+		msg: &EventMessage{
+			Event: &Event{
+				Type:      Upload,
+				RequestID: "r2",
+				Content:   &UploadEvent{RemotePath: "a/none.yml", LocalPath: "none.yml"},
+			},
+		},
+		cb: cb,
+	})
+	assert.Equal(t, "r2", got.RequestID)
+	assert.Equal(t, StatusFailed, got.Status)
+	assert.NotEmpty(t, got.Error)
+}
+
+func TestClientDue(t *testing.T) {
+	cli, _ := newMockClient(t)
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.Local)
+	at := func(hour int) *time.Time {
+		t := time.Date(2020, 1, 1, hour, 0, 0, 0, time.Local)
+		return &t
+	}
+	assert.True(t, cli.due(&Event{}, now).IsZero())
+	assert.Equal(t, *at(13), cli.due(&Event{NotBefore: at(13)}, now))
+	assert.True(t, cli.due(&Event{NotBefore: at(11)}, now).IsZero())
+
+	cli.cfg.Schedule.Windows = []Window{{Start: "22:00", End: "06:00"}}
+	assert.Equal(t, *at(22), cli.due(&Event{}, now))
+	assert.Equal(t, *at(22), cli.due(&Event{NotBefore: at(13)}, now))
+	assert.Equal(t, *at(22), cli.due(&Event{Deadline: at(23)}, now))
+	// the urgent event bypasses the schedule
+	assert.True(t, cli.due(&Event{Deadline: at(18)}, now).IsZero())
+	assert.Equal(t, *at(13), cli.due(&Event{NotBefore: at(13), Deadline: at(18)}, now))
+}
+
+func TestClientSchedule(t *testing.T) {
+	cli, h := newMockClient(t)
+	var err error
+	cli.pool, err = ants.NewPoolWithFunc(cli.cfg.Pool.Worker, cli.call)
+	assert.NoError(t, err)
+	defer cli.pool.Release()
+	cli.journal, err = newJournal(Journal{Path: path.Join(h.dir, "journal"), Retention: time.Hour}, "cli")
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(cli.pwd, 0755))
+	assert.NoError(t, copyFile("example/etc/baetyl/service-bos.yml", path.Join(cli.pwd, "service.yml")))
+
+	notBefore := time.Now().Add(time.Hour)
+	msg := &EventMessage{
+		Event: &Event{
+			Type:      Upload,
+			NotBefore: &notBefore,
+			Content:   &UploadEvent{RemotePath: "a/service.yml", LocalPath: "service.yml"},
+		},
+	}
+	// the message deferred is journaled even if the journal is not enabled, the others are not
+	journaled, err := cli.Journal("rule", &EventMessage{Event: &Event{Type: Upload, Content: &UploadEvent{}}})
+	assert.NoError(t, err)
+	assert.False(t, journaled)
+	journaled, err = cli.Journal("rule", msg)
+	assert.NoError(t, err)
+	assert.True(t, journaled)
+	results := make(chan *Result, 1)
+	assert.NoError(t, cli.CallAsync(msg, func(msg *EventMessage, res *Result, err error) {
+		results <- res
+	}))
+	assert.Len(t, cli.deferred, 1)
+	assert.Equal(t, TaskDeferred, cli.journal.index[msg.JournalID].State)
+
+	// the task deferred survives restart
+	j, err := newJournal(Journal{Path: path.Join(h.dir, "journal"), Retention: time.Hour}, "cli")
+	assert.NoError(t, err)
+	pending, err := j.pending("rule")
+	assert.NoError(t, err)
+	assert.Len(t, pending, 1)
+	assert.Equal(t, msg.JournalID, pending[0].JournalID)
+	assert.Equal(t, notBefore.Unix(), pending[0].Event.NotBefore.Unix())
+
+	// not due
+	cli.schedule(time.Now())
+	assert.Len(t, cli.deferred, 1)
+
+	cli.schedule(notBefore)
+	assert.Len(t, cli.deferred, 0)
+	select {
+	case res := <-results:
+		assert.Equal(t, StatusSucceeded, res.Status)
+	case <-time.After(3 * time.Second):
+		t.Fatal("deferred task not executed")
+	}
+	assert.Equal(t, 1, h.puts)
+}
+
+func TestClientDeadline(t *testing.T) {
+	cli, h := newMockClient(t)
+	deadline := time.Now().Add(-time.Second)
+	var got *Result
+	cli.call(&Task{
+		msg: &EventMessage{
+			Event: &Event{
+				Type:     Upload,
+				Deadline: &deadline,
+				Content:  &UploadEvent{RemotePath: "a/service.yml", LocalPath: "service.yml"},
+			},
+		},
+		cb: func(msg *EventMessage, res *Result, err error) {
+			got = res
+		},
+	})
+	assert.Equal(t, StatusFailed, got.Status)
+	assert.Contains(t, got.Error, "deadline")
+	assert.Equal(t, 0, h.puts)
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/utils"
 	"github.com/docker/go-units"
 	yaml "gopkg.in/yaml.v2"
 )
@@ -14,8 +15,16 @@ type Kind string
 
 // The type of event from cloud
 const (
-	Bos Kind = "BOS"
-	S3  Kind = "S3"
+	Bos    Kind = "BOS"
+	S3     Kind = "S3"
+	File   Kind = "FILE"
+	Azure  Kind = "AZURE"
+	GCS    Kind = "GCS"
+	OSS    Kind = "OSS"
+	COS    Kind = "COS"
+	SFTP   Kind = "SFTP"
+	WebDAV Kind = "WEBDAV"
+	HTTP   Kind = "HTTP"
 
 	MinioStsCli    = "baetyl-sts"
 	IpcRule        = "baetyl-ipc"
@@ -24,8 +33,9 @@ const (
 
 // Config config of rule
 type Config struct {
-	Clients []ClientInfo `yaml:"clients" json:"clients"`
-	Rules   []RuleInfo   `yaml:"rules" json:"rules"`
+	Clients  []ClientInfo `yaml:"clients" json:"clients"`
+	Rules    []RuleInfo   `yaml:"rules" json:"rules"`
+	Throttle Throttle     `yaml:"throttle" json:"throttle"` // bandwidth limit shared by all clients
 }
 
 // ClientInfo client config
@@ -39,8 +49,16 @@ type ClientInfo struct {
 	Pool         Pool          `yaml:"pool" json:"pool"`
 	MultiPart    MultiPart     `yaml:"multipart" json:"multipart"`
 	Limit        Limit         `yaml:"limit" json:"limit"`
+	Journal      Journal       `yaml:"journal" json:"journal"`
+	Sync         SyncConfig    `yaml:"sync" json:"sync"`
+	HTTP         HTTPConfig    `yaml:"http" json:"http"`
+	Encryption   Encryption    `yaml:"encryption" json:"encryption"`
+	Object       ObjectOptions `yaml:"object" json:"object"` // the options of objects uploaded, only supported by S3 and BOS
+	Throttle     Throttle      `yaml:"throttle" json:"throttle"`
+	Schedule     Schedule      `yaml:"schedule" json:"schedule"`
 	StsDeadline  time.Time     `yaml:"StsDeadline" json:"StsDeadline"`
 	DefaultPath  string        `yaml:"defaultPath" json:"defaultPath"`
+	PathTemplate string        `yaml:"pathTemplate" json:"pathTemplate"` // template of remote path of upload event, the remote path is used verbatim if empty
 	Record       struct {
 		Interval time.Duration `yaml:"interval" json:"interval" default:"1m"`
 	} `yaml:"record" json:"record"`
@@ -54,6 +72,51 @@ type ObjectConfig struct {
 	Sk       string `yaml:"sk" json:"sk" validate:"nonzero"`
 	Bucket   string `yaml:"bucket" json:"bucket" validate:"nonzero"`
 	Token    string `yaml:"token,omitempty" json:"token,omitempty" default:""`
+	// the path of credentials file, such as the service account json key of GCS
+	Credentials string `yaml:"credentials,omitempty" json:"credentials,omitempty"`
+	// the type of temporary credentials requested from baetyl core, such as oss or cos, the ak/sk is used if empty
+	Sts string `yaml:"sts,omitempty" json:"sts,omitempty"`
+	// the path of known_hosts file to check the host key of SFTP server
+	KnownHosts string `yaml:"knownHosts,omitempty" json:"knownHosts,omitempty"`
+	// the tls config of storages accessed by rest api, such as WEBDAV
+	TLS utils.Certificate `yaml:"tls,omitempty" json:"tls,omitempty"`
+}
+
+// HTTPConfig the upload config of HTTP storage
+type HTTPConfig struct {
+	// the template of object url, the remote path is escaped
+	URL     string            `yaml:"url" json:"url" default:"{{.Endpoint}}/{{.Bucket}}/{{.RemotePath}}"`
+	Method  string            `yaml:"method" json:"method" default:"PUT"` // PUT the file as body, or POST it in multipart/form-data
+	Field   string            `yaml:"field" json:"field" default:"file"`  // the form field of file if POST
+	Headers map[string]string `yaml:"headers" json:"headers"`
+	Status  []int             `yaml:"status" json:"status"` // the status codes of successful upload, 2xx if empty
+	Head    bool              `yaml:"head" json:"head"`     // check whether the object exists by HEAD, the file is always uploaded if false
+}
+
+// Encryption client-side envelope encryption, the file is encrypted by a random data key of AES-256-GCM before upload,
+// the data key is wrapped by the public key of RSA or X25519, or by the static key
+type Encryption struct {
+	Enable     bool   `yaml:"enable" json:"enable" default:"false"`
+	KeyID      string `yaml:"keyId" json:"keyId"`           // the id of key recorded in object meta, the fingerprint of key if empty
+	PublicKey  string `yaml:"publicKey" json:"publicKey"`   // path of PEM public key to wrap the data key
+	PrivateKey string `yaml:"privateKey" json:"privateKey"` // path of PEM private key to unwrap the data key of objects downloaded
+	KeyFile    string `yaml:"keyFile" json:"keyFile"`       // path of static key of 32 bytes in raw, hex or base64, instead of key pair
+	// directory to keep the digests of files encrypted and uploaded, so the files unchanged are skipped, always uploaded if empty
+	Index    string       `yaml:"index" json:"index" default:"var/lib/baetyl/data/encryption"`
+	Previous []Encryption `yaml:"previous" json:"previous"` // the keys rotated out to decrypt the objects downloaded, only the keys and key id are used
+}
+
+// ObjectOptions the options of objects uploaded, the options of upload event override the ones of client
+type ObjectOptions struct {
+	SSE             string            `yaml:"sse" json:"sse,omitempty"`                       // server-side encryption: SSE-S3, SSE-KMS or SSE-C
+	SSEKMSKeyID     string            `yaml:"sseKmsKeyId" json:"sseKmsKeyId,omitempty"`       // the kms key of SSE-KMS, the default key of service if empty
+	SSECustomerKey  string            `yaml:"sseCustomerKey" json:"sseCustomerKey,omitempty"` // path of SSE-C key of 32 bytes in raw, hex or base64, client only
+	StorageClass    string            `yaml:"storageClass" json:"storageClass,omitempty"`     // such as STANDARD_IA, GLACIER of S3, or COLD of BOS
+	ACL             string            `yaml:"acl" json:"acl,omitempty"`                       // canned acl, such as private or public-read
+	ContentType     string            `yaml:"contentType" json:"contentType,omitempty"`       // detected by the extension or content of file if empty
+	ContentEncoding string            `yaml:"contentEncoding" json:"contentEncoding,omitempty"`
+	CacheControl    string            `yaml:"cacheControl" json:"cacheControl,omitempty"`
+	Tags            map[string]string `yaml:"tags" json:"tags,omitempty"`
 }
 
 // RuleInfo rule info
@@ -61,11 +124,55 @@ type RuleInfo struct {
 	Name   string `yaml:"name" json:"name" validate:"nonzero"`
 	Source struct {
 		QOS   uint32 `yaml:"qos" json:"qos" validate:"min=0, max=1"`
-		Topic string `yaml:"topic" json:"topic" validate:"nonzero"`
+		Topic string `yaml:"topic" json:"topic"` // required unless watch is set
+		Watch *Watch `yaml:"watch" json:"watch"` // watches local directories instead of subscribing the topic if set
 	} `yaml:"source" json:"source" validate:"nonzero"`
 	Target struct {
-		Client string `yaml:"client" json:"client" default:"baetyl-sts"`
+		Client       string   `yaml:"client" json:"client" default:"baetyl-sts"`
+		Clients      []string `yaml:"clients" json:"clients"`             // multiple targets to store copies, overrides client if set
+		Policy       string   `yaml:"policy" json:"policy" default:"all"` // the policy of multiple targets: all, any or fallback
+		PathTemplate string   `yaml:"pathTemplate" json:"pathTemplate"`   // template of remote path of upload event, overrides the one of client
+		Routes       []Route  `yaml:"routes" json:"routes"`               // routes the upload events by file attributes, the first matched is applied
 	} `yaml:"target" json:"target"`
+	Reply struct {
+		QOS   uint32 `yaml:"qos" json:"qos" validate:"min=0, max=1"`
+		Topic string `yaml:"topic" json:"topic"` // results are not published if empty
+	} `yaml:"reply" json:"reply"`
+}
+
+// Watch directory watcher source, uploads the files once they are stable
+type Watch struct {
+	Paths      []string          `yaml:"paths" json:"paths" validate:"nonzero"`            // local directories to watch
+	Recursive  bool              `yaml:"recursive" json:"recursive"`                       // watch the sub directories
+	Include    []string          `yaml:"include" json:"include"`                           // glob patterns of files to include, all files included if empty
+	Exclude    []string          `yaml:"exclude" json:"exclude"`                           // glob patterns of files to exclude
+	RemotePath string            `yaml:"remotePath" json:"remotePath" default:"{{.Path}}"` // template of remote path
+	Stable     time.Duration     `yaml:"stable" json:"stable" default:"10s"`               // the file is uploaded once closed or unchanged in the duration
+	Interval   time.Duration     `yaml:"interval" json:"interval" default:"5s"`            // interval to scan the directories
+	After      string            `yaml:"after" json:"after" default:"keep"`
+	MoveTo     string            `yaml:"moveTo" json:"moveTo"` // local directory to move the files uploaded to if after is move
+	Meta       map[string]string `yaml:"meta" json:"meta" default:"{}"`
+}
+
+// Route routes the upload events matched to the target client or bucket, all conditions set must be matched
+type Route struct {
+	Ext     []string          `yaml:"ext" json:"ext"`         // extensions of local path such as .mp4, case insensitive
+	MinSize int64             `yaml:"minSize" json:"minSize"` // the file is not smaller than the size, such as 100m
+	MaxSize int64             `yaml:"maxSize" json:"maxSize"` // the file is smaller than the size
+	Meta    map[string]string `yaml:"meta" json:"meta"`       // glob patterns of meta values of event, such as * if the key exists
+	Topic   string            `yaml:"topic" json:"topic"`     // topic filter of message with wildcards + and #
+	Client  string            `yaml:"client" json:"client"`   // the target client, the target of rule if empty
+	Bucket  string            `yaml:"bucket" json:"bucket"`   // the target bucket, the bucket of client if empty
+}
+
+type route struct {
+	Ext     []string          `yaml:"ext" json:"ext"`
+	MinSize string            `yaml:"minSize" json:"minSize"`
+	MaxSize string            `yaml:"maxSize" json:"maxSize"`
+	Meta    map[string]string `yaml:"meta" json:"meta"`
+	Topic   string            `yaml:"topic" json:"topic"`
+	Client  string            `yaml:"client" json:"client"`
+	Bucket  string            `yaml:"bucket" json:"bucket"`
 }
 
 // Backoff policy
@@ -83,13 +190,19 @@ type Pool struct {
 
 // MultiPart config
 type MultiPart struct {
-	PartSize    int64 `yaml:"partsize" json:"partsize" default:"1048576000"`
-	Concurrency int   `yaml:"concurrency" json:"concurrency" default:"10"`
+	PartSize    int64         `yaml:"partsize" json:"partsize" default:"1048576000"`
+	Concurrency int           `yaml:"concurrency" json:"concurrency" default:"10"`
+	Resume      bool          `yaml:"resume" json:"resume" default:"false"`                     // resume the upload of file larger than part size from the last completed part
+	Path        string        `yaml:"path" json:"path" default:"var/lib/baetyl/data/multipart"` // checkpoints of multipart uploads
+	Expiration  time.Duration `yaml:"expiration" json:"expiration" default:"24h"`               // the multipart upload not updated within expiration is aborted
 }
 
 type multipart struct {
-	PartSize    string `yaml:"partsize" json:"partsize"`
-	Concurrency int    `yaml:"concurrency" json:"concurrency"`
+	PartSize    string        `yaml:"partsize" json:"partsize"`
+	Concurrency int           `yaml:"concurrency" json:"concurrency"`
+	Resume      bool          `yaml:"resume" json:"resume"`
+	Path        string        `yaml:"path" json:"path"`
+	Expiration  time.Duration `yaml:"expiration" json:"expiration"`
 }
 
 // Limit limit config
@@ -99,6 +212,52 @@ type Limit struct {
 	Path   string `yaml:"path" json:"path" default:"var/lib/baetyl/data/stats.yml"`
 }
 
+// Journal task journal config
+type Journal struct {
+	Enable    bool          `yaml:"enable" json:"enable" default:"false"`
+	Path      string        `yaml:"path" json:"path" default:"var/lib/baetyl/data/journal"`
+	Retention time.Duration `yaml:"retention" json:"retention" default:"24h"` // how long the finished tasks are kept
+}
+
+// SyncConfig directory sync config
+type SyncConfig struct {
+	Path string `yaml:"path" json:"path" default:"var/lib/baetyl/data/sync"` // directory to keep the indexes of synced and watched files
+}
+
+// Schedule time windows allowed to execute tasks, the tasks received outside the windows are deferred
+type Schedule struct {
+	Windows  []Window      `yaml:"windows" json:"windows"`                 // tasks are executed at any time if empty
+	Interval time.Duration `yaml:"interval" json:"interval" default:"30s"` // interval to check the deferred tasks
+}
+
+type schedule struct {
+	Windows  []Window      `yaml:"windows" json:"windows"`
+	Interval time.Duration `yaml:"interval" json:"interval"`
+}
+
+// Throttle bandwidth limit of uploads
+type Throttle struct {
+	Rate      int64              `yaml:"rate" json:"rate"`           // bytes per second, such as 128k, unlimited if 0
+	Schedules []ThrottleSchedule `yaml:"schedules" json:"schedules"` // rates in time windows, the first matched is applied
+}
+
+type throttle struct {
+	Rate      string             `yaml:"rate" json:"rate"`
+	Schedules []ThrottleSchedule `yaml:"schedules" json:"schedules"`
+}
+
+// ThrottleSchedule bandwidth limit in time window
+type ThrottleSchedule struct {
+	Window `yaml:",inline" json:",inline"`
+	Rate   int64 `yaml:"rate" json:"rate"` // bytes per second, unlimited if 0
+}
+
+type throttleSchedule struct {
+	Start string `yaml:"start" json:"start"`
+	End   string `yaml:"end" json:"end"`
+	Rate  string `yaml:"rate" json:"rate"`
+}
+
 type limit struct {
 	Enable bool   `yaml:"enable" json:"enable"`
 	Data   string `yaml:"data" json:"data"`
@@ -127,6 +286,85 @@ func (l *Limit) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+// UnmarshalYAML customizes unmarshal
+func (s *Schedule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var ss schedule
+	err := unmarshal(&ss)
+	if err != nil {
+		return err
+	}
+	for _, w := range ss.Windows {
+		if err = w.validate(); err != nil {
+			return err
+		}
+	}
+	s.Windows = ss.Windows
+	if ss.Interval != 0 {
+		s.Interval = ss.Interval
+	}
+	return nil
+}
+
+// UnmarshalYAML customizes unmarshal
+func (t *Throttle) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var ts throttle
+	err := unmarshal(&ts)
+	if err != nil {
+		return err
+	}
+	if ts.Rate != "" {
+		t.Rate, err = units.RAMInBytes(ts.Rate)
+		if err != nil {
+			return err
+		}
+	}
+	t.Schedules = ts.Schedules
+	return nil
+}
+
+// UnmarshalYAML customizes unmarshal
+func (s *ThrottleSchedule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var ss throttleSchedule
+	err := unmarshal(&ss)
+	if err != nil {
+		return err
+	}
+	s.Window = Window{Start: ss.Start, End: ss.End}
+	if err = s.Window.validate(); err != nil {
+		return err
+	}
+	if ss.Rate != "" {
+		s.Rate, err = units.RAMInBytes(ss.Rate)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnmarshalYAML customizes unmarshal
+func (r *Route) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var rs route
+	err := unmarshal(&rs)
+	if err != nil {
+		return err
+	}
+	*r = Route{Ext: rs.Ext, Meta: rs.Meta, Topic: rs.Topic, Client: rs.Client, Bucket: rs.Bucket}
+	if rs.MinSize != "" {
+		r.MinSize, err = units.RAMInBytes(rs.MinSize)
+		if err != nil {
+			return err
+		}
+	}
+	if rs.MaxSize != "" {
+		r.MaxSize, err = units.RAMInBytes(rs.MaxSize)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // UnmarshalYAML customizes unmarshal
 func (m *MultiPart) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var ms multipart
@@ -143,6 +381,15 @@ func (m *MultiPart) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if ms.Concurrency != 0 {
 		m.Concurrency = ms.Concurrency
 	}
+	if ms.Resume {
+		m.Resume = ms.Resume
+	}
+	if ms.Path != "" {
+		m.Path = ms.Path
+	}
+	if ms.Expiration != 0 {
+		m.Expiration = ms.Expiration
+	}
 	return nil
 }
 
@@ -23,6 +23,9 @@ func TestConfig(t *testing.T) {
 	assert.Equal(t, Kind("BOS"), c.Clients[0].Kind)
 	assert.Equal(t, int64(10485760), c.Clients[0].MultiPart.PartSize)
 	assert.Equal(t, 10, c.Clients[0].MultiPart.Concurrency)
+	assert.False(t, c.Clients[0].MultiPart.Resume)
+	assert.Equal(t, "var/lib/baetyl/data/multipart", c.Clients[0].MultiPart.Path)
+	assert.Equal(t, 24*time.Hour, c.Clients[0].MultiPart.Expiration)
 	assert.Equal(t, 1000, c.Clients[0].Pool.Worker)
 	assert.Equal(t, time.Duration(30000000000), c.Clients[0].Pool.Idletime)
 	assert.Equal(t, "bos-remote-demo", c.Clients[0].Bucket)
@@ -31,11 +34,22 @@ func TestConfig(t *testing.T) {
 	assert.Equal(t, int64(9663676416), c.Clients[0].Limit.Data)
 	assert.Equal(t, "var/lib/baetyl/data/stats.yml", c.Clients[0].Limit.Path)
 	assert.Equal(t, time.Duration(60000000000), c.Clients[0].Record.Interval)
+	assert.False(t, c.Clients[0].Journal.Enable)
+	assert.Equal(t, "var/lib/baetyl/data/journal", c.Clients[0].Journal.Path)
+	assert.Equal(t, 24*time.Hour, c.Clients[0].Journal.Retention)
+	assert.Equal(t, "var/lib/baetyl/data/sync", c.Clients[0].Sync.Path)
+	assert.Equal(t, "{{.Endpoint}}/{{.Bucket}}/{{.RemotePath}}", c.Clients[0].HTTP.URL)
+	assert.Equal(t, "PUT", c.Clients[0].HTTP.Method)
+	assert.Equal(t, "file", c.Clients[0].HTTP.Field)
+	assert.False(t, c.Clients[0].Encryption.Enable)
+	assert.True(t, c.Clients[0].Object.empty())
 
 	assert.Len(t, c.Rules, 1)
 	assert.Equal(t, uint32(1), c.Rules[0].Source.QOS)
 	assert.Equal(t, "broker/topic1", c.Rules[0].Source.Topic)
 	assert.Equal(t, "baidubos", c.Rules[0].Target.Client)
+	assert.Empty(t, c.Rules[0].Target.Clients)
+	assert.Equal(t, PolicyAll, c.Rules[0].Target.Policy)
 
 	// round 2: load bad configuration yaml file
 	err = utils.LoadYAML("example/test/baetyl/service.yml", &c)
@@ -52,3 +66,46 @@ func TestDumpYAML(t *testing.T) {
 	err = DumpYAML(tmpfile.Name(), cfg)
 	assert.Nil(t, err)
 }
+
+func TestScheduleConfig(t *testing.T) {
+	var s Schedule
+	err := utils.UnmarshalYAML([]byte(`
+windows:
+  - start: "22:00"
+    end: "06:00"
+`), &s)
+	assert.NoError(t, err)
+	assert.Equal(t, []Window{{Start: "22:00", End: "06:00"}}, s.Windows)
+	assert.Equal(t, 30*time.Second, s.Interval)
+
+	err = utils.UnmarshalYAML([]byte(`
+windows:
+  - start: "22:00"
+    end: "6"
+`), &s)
+	assert.Error(t, err)
+}
+
+func TestWatchConfig(t *testing.T) {
+	var c Config
+	err := utils.UnmarshalYAML([]byte(`
+rules:
+  - name: watch
+    source:
+      watch:
+        paths:
+          - var/lib/app/output
+        after: delete
+    target:
+      client: baidubos
+`), &c)
+	assert.NoError(t, err)
+	assert.Len(t, c.Rules, 1)
+	w := c.Rules[0].Source.Watch
+	assert.NotNil(t, w)
+	assert.Equal(t, []string{"var/lib/app/output"}, w.Paths)
+	assert.Equal(t, "{{.Path}}", w.RemotePath)
+	assert.Equal(t, 10*time.Second, w.Stable)
+	assert.Equal(t, 5*time.Second, w.Interval)
+	assert.Equal(t, AfterDelete, w.After)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	dm "github.com/baetyl/baetyl-go/v2/context"
+	"github.com/baetyl/baetyl-go/v2/errors"
+)
+
+// the validity of cos signature
+const cosSignExpiry = time.Hour
+
+// NewCOSHandler creates a new handler of tencent cloud cos, the endpoint is the one of region
+// such as cos.ap-guangzhou.myqcloud.com, the bucket is <name>-<appid> accessed by virtual host
+func NewCOSHandler(ctx dm.Context, cfg ClientInfo) (StorageHandler, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.Errorf("failed to create cos client (%s): endpoint is required", cfg.Name)
+	}
+	return newSignedHandler(ctx, cfg, cosSigner{}, "x-cos-meta-")
+}
+
+// cosSigner signs the request by the signature in header,
+// see https://cloud.tencent.com/document/product/436/7778
+type cosSigner struct{}
+
+func (cosSigner) sign(req *http.Request, Bucket, remotePath string, creds accessKey) {
+	if creds.token != "" {
+		req.Header.Set("x-cos-security-token", creds.token)
+	}
+	now := time.Now()
+	keyTime := fmt.Sprintf("%d;%d", now.Unix(), now.Add(cosSignExpiry).Unix())
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if k == "content-md5" || k == "content-type" || strings.HasPrefix(k, "x-cos-") {
+			headers[k] = strings.Join(v, ",")
+		}
+	}
+	headerList, httpHeaders := cosCanonical(headers)
+	params := map[string]string{}
+	for k, v := range req.URL.Query() {
+		params[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+	paramList, httpParams := cosCanonical(params)
+	httpString := strings.ToLower(req.Method) + "\n" + req.URL.Path + "\n" + httpParams + "\n" + httpHeaders + "\n"
+	s := "sha1\n" + keyTime + "\n" + cosSHA1(httpString) + "\n"
+	signKey := cosHMAC(creds.sk, keyTime)
+	req.Header.Set("Authorization", strings.Join([]string{
+		"q-sign-algorithm=sha1",
+		"q-ak=" + creds.ak,
+		"q-sign-time=" + keyTime,
+		"q-key-time=" + keyTime,
+		"q-header-list=" + headerList,
+		"q-url-param-list=" + paramList,
+		"q-signature=" + cosHMAC(signKey, s),
+	}, "&"))
+}
+
+// cosCanonical returns the sorted list of keys joined by ; and the sorted key=value pairs joined by &,
+// both the keys and values are url encoded
+func cosCanonical(m map[string]string) (string, string) {
+	var keys []string
+	encoded := map[string]string{}
+	for k, v := range m {
+		ek := cosEscape(k)
+		keys = append(keys, ek)
+		encoded[ek] = cosEscape(v)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+encoded[k])
+	}
+	return strings.Join(keys, ";"), strings.Join(pairs, "&")
+}
+
+func cosEscape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+func cosSHA1(s string) string {
+	h := sha1.New()
+	h.Write([]byte(s))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func cosHMAC(key, s string) string {
+	mac := hmac.New(sha1.New, []byte(key))
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/docker/distribution/uuid"
+	"github.com/mholt/archiver"
+	"github.com/nwaples/rardecode"
+)
+
+func (cli *Client) handleDownloadEvent(e *DownloadEvent) (*Result, error) {
+	if strings.Contains(e.LocalPath, "..") {
+		return nil, errors.Errorf("failed to pass LocalPath (%s) check: the local path can't contains ..", e.LocalPath)
+	}
+	remotePath, err := cli.refreshSts(e.RemotePath)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	opts, err := cli.mergeOptions(e.Options)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	local := path.Join(cli.pwd, e.LocalPath)
+	err = os.MkdirAll(path.Dir(local), 0755)
+	if err != nil {
+		return nil, errors.Errorf("failed to make dir (%s): %s", path.Dir(local), err.Error())
+	}
+	// download to a temp file beside the local path, then rename to make the write atomic
+	t := path.Join(path.Dir(local), "."+path.Base(local)+"."+uuid.Generate().String())
+	defer os.RemoveAll(t)
+	if h, ok := cli.handler.(OptionsHandler); ok && opts != nil {
+		err = h.GetObjectToFileWithOptions(cli.cfg.Bucket, remotePath, t, opts)
+	} else {
+		err = cli.handler.GetObjectToFile(cli.cfg.Bucket, remotePath, t)
+	}
+	if err != nil {
+		cli.log.Error("failed to get object to file", log.Any("remotePath", remotePath), log.Any("bucket", cli.cfg.Bucket), log.Error(err))
+		atomic.AddUint64(&cli.fs.fail, 1)
+		return nil, errors.Trace(err)
+	}
+	if cli.crypter != nil {
+		// the object not encrypted is kept as it is
+		plain := t + ".plain"
+		defer os.RemoveAll(plain)
+		ok, err := cli.crypter.decrypt(t, plain)
+		if err != nil {
+			atomic.AddUint64(&cli.fs.fail, 1)
+			return nil, errors.Trace(err)
+		}
+		if ok {
+			t = plain
+		}
+	}
+	fsize, md5 := cli.fileSizeMd5(t)
+	res := &Result{
+		Bucket:     cli.cfg.Bucket,
+		RemotePath: remotePath,
+		LocalPath:  e.LocalPath,
+		Size:       fsize,
+		MD5:        md5,
+	}
+	if e.MD5 != "" {
+		if !strings.EqualFold(md5, e.MD5) {
+			atomic.AddUint64(&cli.fs.fail, 1)
+			return res, errors.Errorf("failed to verify object (%s): md5 (%s) mismatched, expected (%s)", remotePath, md5, e.MD5)
+		}
+	}
+	if e.Unpack {
+		err = unpack(t, remotePath, local)
+	} else {
+		err = os.Rename(t, local)
+	}
+	if err != nil {
+		atomic.AddUint64(&cli.fs.fail, 1)
+		return res, errors.Trace(err)
+	}
+	cli.log.Info("get object to file successfully", log.Any("localFile", local), log.Any("remotePath", remotePath), log.Any("bucket", cli.cfg.Bucket))
+	atomic.AddUint64(&cli.fs.success, 1)
+	return res, nil
+}
+
+// unpack extracts the archive into a temp dir beside the destination, then replaces the destination with it,
+// the format of archive is detected by the extension of name. All entries are checked before anything is written,
+// the entry or link escaping the temp dir is rejected
+func unpack(archive, name, dst string) error {
+	a, err := archiver.ByExtension(name)
+	if err != nil {
+		return errors.Errorf("failed to unpack (%s): %s", name, err.Error())
+	}
+	w, ok := a.(archiver.Walker)
+	if !ok {
+		return errors.Errorf("failed to unpack (%s): format unsupported", name)
+	}
+	entries, err := checkArchive(w, archive)
+	if err != nil {
+		return errors.Errorf("failed to unpack (%s): %s", name, err.Error())
+	}
+	t := path.Join(path.Dir(dst), "."+path.Base(dst)+"."+uuid.Generate().String())
+	defer os.RemoveAll(t)
+	err = os.MkdirAll(t, 0755)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	i := 0
+	err = w.Walk(archive, func(f archiver.File) error {
+		e := entries[i]
+		i++
+		return e.extract(t, f)
+	})
+	if err != nil {
+		return errors.Errorf("failed to unpack (%s): %s", name, err.Error())
+	}
+	if _, err = os.Stat(dst); err != nil {
+		return errors.Trace(os.Rename(t, dst))
+	}
+	old := t + ".old"
+	if err = os.Rename(dst, old); err != nil {
+		return errors.Trace(err)
+	}
+	if err = os.Rename(t, dst); err != nil {
+		os.Rename(old, dst)
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.RemoveAll(old))
+}
+
+// archiveEntry an entry of archive, the path is resolved with the symlinks of archive
+type archiveEntry struct {
+	name string
+	mode os.FileMode
+	link string
+	hard bool
+	// path the resolved path to write, empty if the entry is skipped
+	path string
+}
+
+// checkArchive walks the archive and checks all entries without writing anything
+func checkArchive(w archiver.Walker, archive string) ([]*archiveEntry, error) {
+	var entries []*archiveEntry
+	links := map[string]string{}
+	err := w.Walk(archive, func(f archiver.File) error {
+		e := &archiveEntry{}
+		switch h := f.Header.(type) {
+		case *tar.Header:
+			e.name, e.mode, e.link = h.Name, h.FileInfo().Mode(), h.Linkname
+			e.hard = h.Typeflag == tar.TypeLink
+		case zip.FileHeader:
+			e.name, e.mode = h.Name, h.Mode()
+		case *rardecode.FileHeader:
+			e.name, e.mode = h.Name, h.Mode()
+		default:
+			return errors.Errorf("header (%T) of entry (%s) unsupported", f.Header, f.Name())
+		}
+		if _, ok := f.Header.(*tar.Header); !ok && e.mode&os.ModeSymlink != 0 {
+			// the target of symlink is stored as the content in zip and rar
+			data, err := ioutil.ReadAll(f)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			e.link = string(data)
+		}
+		if path.IsAbs(e.name) || hasDotDot(e.name) {
+			return errors.Errorf("entry (%s) is outside of the archive", e.name)
+		}
+		e.name = path.Clean(e.name)
+		if path.IsAbs(e.link) {
+			return errors.Errorf("link (%s) of entry (%s) is outside of the archive", e.link, e.name)
+		}
+		if e.mode&os.ModeSymlink != 0 {
+			links[e.name] = e.link
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, e := range entries {
+		if e.name == "." || !(e.hard || e.mode.IsDir() || e.mode.IsRegular() || e.mode&os.ModeSymlink != 0) {
+			continue
+		}
+		// the entry written through a symlink is rejected, so every symlink is created where its name says
+		dir := path.Dir(e.name)
+		if d, ok := resolve(dir, links); !ok || d != dir {
+			return nil, errors.Errorf("entry (%s) is outside of the archive or under a symlink", e.name)
+		}
+		e.path = e.name
+		if e.mode&os.ModeSymlink != 0 {
+			if _, ok := resolve(e.name, links); !ok {
+				return nil, errors.Errorf("link (%s) of entry (%s) is outside of the archive", e.link, e.name)
+			}
+			continue
+		}
+		var ok bool
+		if e.path, ok = resolve(e.name, links); !ok {
+			return nil, errors.Errorf("entry (%s) is outside of the archive", e.name)
+		}
+		if e.hard {
+			// the hard link is made to the resolved file, so it never shares a relative symlink
+			target, ok := resolve(e.link, links)
+			if !ok {
+				return nil, errors.Errorf("link (%s) of entry (%s) is outside of the archive", e.link, e.name)
+			}
+			e.link = target
+		}
+	}
+	return entries, nil
+}
+
+// extract writes the checked entry into dir
+func (e *archiveEntry) extract(dir string, f archiver.File) error {
+	if e.path == "" {
+		return nil
+	}
+	p := path.Join(dir, e.path)
+	if e.mode.IsDir() {
+		return errors.Trace(os.MkdirAll(p, 0755))
+	}
+	if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+		return errors.Trace(err)
+	}
+	switch {
+	case e.hard:
+		return errors.Trace(os.Link(path.Join(dir, e.link), p))
+	case e.mode&os.ModeSymlink != 0:
+		return errors.Trace(os.Symlink(e.link, p))
+	}
+	out, err := os.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, e.mode.Perm()|0600)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = io.Copy(out, f)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return errors.Trace(err)
+}
+
+// resolve resolves the path relative to the unpacked dir with the symlinks of archive like the file system does,
+// reports false if the path escapes the unpacked dir or the symlinks loop
+func resolve(p string, links map[string]string) (string, bool) {
+	var cur []string
+	rest := strings.Split(p, "/")
+	for n := 0; len(rest) > 0; {
+		c := rest[0]
+		rest = rest[1:]
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			if len(cur) == 0 {
+				return "", false
+			}
+			cur = cur[:len(cur)-1]
+			continue
+		}
+		cur = append(cur, c)
+		target, ok := links[strings.Join(cur, "/")]
+		if !ok {
+			continue
+		}
+		if n++; n > 255 || path.IsAbs(target) {
+			return "", false
+		}
+		cur = cur[:len(cur)-1]
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+	if len(cur) == 0 {
+		return ".", true
+	}
+	return path.Join(cur...), true
+}
+
+func hasDotDot(p string) bool {
+	for _, c := range strings.Split(p, "/") {
+		if c == ".." {
+			return true
+		}
+	}
+	return false
+}
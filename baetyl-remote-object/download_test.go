@@ -0,0 +1,191 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleDownloadEvent(t *testing.T) {
+	cli, h := newMockClient(t)
+	assert.NoError(t, copyFile("example/etc/baetyl/service-bos.yml", path.Join(h.dir, "bucket", "a/service.yml")))
+	md5, err := utils.CalculateFileMD5("example/etc/baetyl/service-bos.yml")
+	assert.NoError(t, err)
+
+	// round 1: local path can't contain ..
+	_, err = cli.handleDownloadEvent(&DownloadEvent{RemotePath: "a/service.yml", LocalPath: "../service.yml"})
+	assert.Error(t, err)
+
+	// round 2: object not found
+	_, err = cli.handleDownloadEvent(&DownloadEvent{RemotePath: "a/none.yml", LocalPath: "b/service.yml"})
+	assert.Error(t, err)
+	assert.False(t, utils.FileExists(path.Join(cli.pwd, "b/service.yml")))
+
+	// round 3: md5 mismatched
+	_, err = cli.handleDownloadEvent(&DownloadEvent{RemotePath: "a/service.yml", LocalPath: "b/service.yml", MD5: "0123"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "md5")
+	assert.False(t, utils.FileExists(path.Join(cli.pwd, "b/service.yml")))
+
+	// round 4: downloaded and verified
+	_, err = cli.handleDownloadEvent(&DownloadEvent{RemotePath: "a/service.yml", LocalPath: "b/service.yml", MD5: md5})
+	assert.NoError(t, err)
+	got, err := utils.CalculateFileMD5(path.Join(cli.pwd, "b/service.yml"))
+	assert.NoError(t, err)
+	assert.Equal(t, md5, got)
+	files, err := ioutil.ReadDir(path.Join(cli.pwd, "b"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.Equal(t, uint64(1), cli.fs.success)
+}
+
+func TestHandleDownloadEventUnpack(t *testing.T) {
+	cli, h := newMockClient(t)
+	pwd, err := os.Getwd()
+	assert.NoError(t, err)
+	files, _, err := collectFiles(pwd, &PackageEvent{LocalPaths: []string{"example/etc"}})
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(path.Join(h.dir, "bucket"), 0755))
+	assert.NoError(t, packFiles(files, nil, path.Join(h.dir, "bucket", "model.tar"), false))
+
+	// the existing directory is replaced
+	assert.NoError(t, os.MkdirAll(path.Join(cli.pwd, "model"), 0755))
+	assert.NoError(t, ioutil.WriteFile(path.Join(cli.pwd, "model", "old"), []byte("old"), 0644))
+	_, err = cli.handleDownloadEvent(&DownloadEvent{RemotePath: "model.tar", LocalPath: "model", Unpack: true})
+	assert.NoError(t, err)
+	assert.True(t, utils.FileExists(path.Join(cli.pwd, "model", ManifestName)))
+	assert.True(t, utils.FileExists(path.Join(cli.pwd, "model", "example/etc/baetyl/service-bos.yml")))
+	assert.False(t, utils.FileExists(path.Join(cli.pwd, "model", "old")))
+
+	// unsupported format
+	assert.NoError(t, copyFile("example/etc/baetyl/service-bos.yml", path.Join(h.dir, "bucket", "model.yml")))
+	_, err = cli.handleDownloadEvent(&DownloadEvent{RemotePath: "model.yml", LocalPath: "model", Unpack: true})
+	assert.Error(t, err)
+	assert.True(t, utils.FileExists(path.Join(cli.pwd, "model", ManifestName)))
+}
+
+// testEntry an entry to write into the test archive, the link is the target of symlink or hard link
+type testEntry struct {
+	name string
+	link string
+	hard bool
+}
+
+func writeTestTar(t *testing.T, file string, entries ...testEntry) {
+	f, err := os.Create(file)
+	assert.NoError(t, err)
+	defer f.Close()
+	w := tar.NewWriter(f)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.name))}
+		if e.link != "" {
+			h.Typeflag, h.Linkname, h.Size = tar.TypeSymlink, e.link, 0
+			if e.hard {
+				h.Typeflag = tar.TypeLink
+			}
+		}
+		assert.NoError(t, w.WriteHeader(h))
+		if h.Size > 0 {
+			_, err = w.Write([]byte(e.name))
+			assert.NoError(t, err)
+		}
+	}
+	assert.NoError(t, w.Close())
+}
+
+func writeTestZip(t *testing.T, file string, entries ...testEntry) {
+	f, err := os.Create(file)
+	assert.NoError(t, err)
+	defer f.Close()
+	w := zip.NewWriter(f)
+	for _, e := range entries {
+		h := &zip.FileHeader{Name: e.name}
+		content := e.name
+		h.SetMode(0644)
+		if e.link != "" {
+			h.SetMode(os.ModeSymlink | 0777)
+			content = e.link
+		}
+		fw, err := w.CreateHeader(h)
+		assert.NoError(t, err)
+		_, err = fw.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+}
+
+func TestUnpack(t *testing.T) {
+	dir := t.TempDir()
+	archive := path.Join(dir, "archive")
+	dst := path.Join(dir, "out", "model")
+	assert.NoError(t, os.MkdirAll(path.Dir(dst), 0755))
+
+	// the links inside the archive are kept
+	writeTestTar(t, archive,
+		testEntry{name: "lib/v1/a.so"},
+		testEntry{name: "./lib/cur", link: "../lib/v1"},
+		testEntry{name: "b.so", link: "lib/cur/a.so"},
+		testEntry{name: "c.so", link: "lib/cur/a.so", hard: true},
+	)
+	assert.NoError(t, unpack(archive, "model.tar", dst))
+	data, err := ioutil.ReadFile(path.Join(dst, "b.so"))
+	assert.NoError(t, err)
+	assert.Equal(t, "lib/v1/a.so", string(data))
+	data, err = ioutil.ReadFile(path.Join(dst, "c.so"))
+	assert.NoError(t, err)
+	assert.Equal(t, "lib/v1/a.so", string(data))
+	target, err := os.Readlink(path.Join(dst, "lib/cur"))
+	assert.NoError(t, err)
+	assert.Equal(t, "../lib/v1", target)
+
+	writeTestZip(t, archive, testEntry{name: "lib/a.so"}, testEntry{name: "b.so", link: "lib/a.so"})
+	assert.NoError(t, unpack(archive, "model.zip", dst))
+	data, err = ioutil.ReadFile(path.Join(dst, "b.so"))
+	assert.NoError(t, err)
+	assert.Equal(t, "lib/a.so", string(data))
+
+	// the hostile archive is rejected before anything is written
+	hostile := [][]testEntry{
+		{{name: "../evil"}},
+		{{name: "/evil"}},
+		{{name: "a/../../evil"}},
+		{{name: "l", link: "../evil"}},
+		{{name: "l", link: "/evil"}},
+		{{name: "a/l", link: "../../evil"}},
+		{{name: "d", link: "."}, {name: "d/l", link: "../evil"}},
+		{{name: "d", link: "."}, {name: "l", link: "d/../evil"}},
+		{{name: "d", link: ".."}, {name: "d/evil"}},
+		{{name: "a", link: "b"}, {name: "b", link: "a"}},
+	}
+	for _, entries := range hostile {
+		for _, f := range []struct {
+			name  string
+			write func(*testing.T, string, ...testEntry)
+		}{
+			{name: "model.tar", write: writeTestTar},
+			{name: "model.zip", write: writeTestZip},
+		} {
+			f.write(t, archive, entries...)
+			err = unpack(archive, f.name, dst)
+			assert.Error(t, err, f.name, entries)
+			assert.False(t, utils.FileExists(path.Join(dir, "evil")))
+			assert.False(t, utils.FileExists(path.Join(dir, "out", "evil")))
+			// the last unpacked destination is kept
+			assert.True(t, utils.FileExists(path.Join(dst, "b.so")))
+		}
+	}
+
+	for _, link := range []string{"../evil", "/evil"} {
+		writeTestTar(t, archive, testEntry{name: "h", link: link, hard: true})
+		assert.Error(t, unpack(archive, "model.tar", dst))
+	}
+	files, err := ioutil.ReadDir(path.Dir(dst))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+}
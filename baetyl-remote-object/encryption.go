@@ -0,0 +1,659 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/docker/distribution/uuid"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The algorithms of client-side encryption
+const (
+	EncryptAES256GCM = "AES-256-GCM"        // the data key encrypting the file, or the static key wrapping the data key
+	WrapRSAOAEP      = "RSA-OAEP-SHA256"    // the data key wrapped by RSA public key
+	WrapX25519       = "X25519-HKDF-SHA256" // the data key wrapped by the key agreed with an ephemeral X25519 key
+)
+
+// The meta of object encrypted
+const (
+	MetaEncryption      = "encryption"
+	MetaEncryptionKeyID = "encryption-key-id"
+	MetaEncryptionWrap  = "encryption-wrap"
+)
+
+const (
+	envelopeMagic   = "BAETYLE1"
+	envelopeSegment = 64 * 1024
+	envelopeMaxHead = 64 * 1024
+	envelopeMaxSeg  = 1 << 20
+	envelopeInfo    = "baetyl-remote-object envelope"
+)
+
+var oidX25519 = asn1.ObjectIdentifier{1, 3, 101, 110}
+
+// envelope the header of object encrypted, the object is laid out as the magic, the length of header in 4 bytes,
+// the header in json, then the segments of file sealed by the data key in order
+type envelope struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Wrap      string `json:"wrap"`
+	Key       []byte `json:"key"`           // the data key wrapped
+	Ephemeral []byte `json:"epk,omitempty"` // the ephemeral public key of X25519
+	Nonce     []byte `json:"nonce"`         // the base nonce of segments
+	Segment   int    `json:"segment"`       // the size of plain segment
+}
+
+// crypter encrypts the files before upload, and decrypts the objects downloaded
+type crypter struct {
+	keyID    string
+	wrap     string
+	rsaPub   *rsa.PublicKey
+	rsaPriv  *rsa.PrivateKey
+	xPub     []byte
+	xPriv    []byte
+	static   []byte
+	index    string              // the directory of records of files uploaded, nothing recorded if empty
+	secret   []byte              // the key of hmac of records
+	previous map[string]*crypter // the crypters of previous keys by key id, to decrypt only
+}
+
+// encryptedRecord the record of file encrypted and uploaded, the object is regarded as the file
+// if the digest of file matched and the object is still the one uploaded
+type encryptedRecord struct {
+	Digest string `yaml:"digest" json:"digest"` // the hmac of the md5 of file and key id, the md5 itself isn't revealed
+	MD5    string `yaml:"md5" json:"md5"`       // the md5 of object encrypted
+	Size   int64  `yaml:"size" json:"size"`     // the size of object encrypted
+}
+
+func newCrypter(cfg Encryption) (*crypter, error) {
+	c := &crypter{}
+	var fingerprint []byte
+	switch {
+	case cfg.KeyFile != "":
+		if cfg.PublicKey != "" || cfg.PrivateKey != "" {
+			return nil, errors.New("failed to load encryption key: key file and key pair can't be both set")
+		}
+		key, err := loadStaticKey(cfg.KeyFile)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		c.wrap, c.static, fingerprint = EncryptAES256GCM, key, key
+	case cfg.PublicKey != "" || cfg.PrivateKey != "":
+		if cfg.PrivateKey != "" {
+			if err := c.loadPrivateKey(cfg.PrivateKey); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+		if cfg.PublicKey != "" {
+			if err := c.loadPublicKey(cfg.PublicKey); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+		if c.rsaPub != nil {
+			der, err := x509.MarshalPKIXPublicKey(c.rsaPub)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			c.wrap, fingerprint = WrapRSAOAEP, der
+		} else {
+			c.wrap, fingerprint = WrapX25519, c.xPub
+		}
+	default:
+		return nil, errors.New("failed to load encryption key: key file or key pair is required")
+	}
+	c.keyID = cfg.KeyID
+	if c.keyID == "" {
+		sum := sha256.Sum256(fingerprint)
+		c.keyID = hex.EncodeToString(sum[:8])
+	}
+	c.previous = make(map[string]*crypter)
+	for _, p := range cfg.Previous {
+		pc, err := newCrypter(Encryption{KeyID: p.KeyID, PublicKey: p.PublicKey, PrivateKey: p.PrivateKey, KeyFile: p.KeyFile})
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if pc.keyID == c.keyID {
+			return nil, errors.Errorf("failed to load encryption key: previous key (%s) is the current one", pc.keyID)
+		}
+		c.previous[pc.keyID] = pc
+	}
+	if cfg.Index != "" {
+		secret, err := loadSecret(path.Join(cfg.Index, ".secret"))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		c.index, c.secret = cfg.Index, secret
+	}
+	return c, nil
+}
+
+// uploaded returns the record of the file in md5 encrypted and uploaded to the object by the current key, nil if not found
+func (c *crypter) uploaded(bucket, remotePath, sum string) *encryptedRecord {
+	if c.index == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(c.record(bucket, remotePath))
+	if err != nil {
+		return nil
+	}
+	var r encryptedRecord
+	if err = yaml.Unmarshal(data, &r); err != nil || !hmac.Equal([]byte(r.Digest), []byte(c.digest(sum))) {
+		return nil
+	}
+	return &r
+}
+
+// recordUploaded records the file in md5 encrypted into the object in md5 and size
+func (c *crypter) recordUploaded(bucket, remotePath, sum, objectMD5 string, size int64) error {
+	if c.index == "" {
+		return nil
+	}
+	data, err := yaml.Marshal(&encryptedRecord{Digest: c.digest(sum), MD5: objectMD5, Size: size})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	file := c.record(bucket, remotePath)
+	t := file + ".tmp"
+	if err = ioutil.WriteFile(t, data, 0600); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(t, file))
+}
+
+// record returns the file of record of object
+func (c *crypter) record(bucket, remotePath string) string {
+	sum := sha256.Sum256([]byte(bucket + "/" + remotePath))
+	return path.Join(c.index, hex.EncodeToString(sum[:16])+".yml")
+}
+
+// digest returns the hmac of the md5 of file and the key id
+func (c *crypter) digest(sum string) string {
+	h := hmac.New(sha256.New, c.secret)
+	h.Write([]byte(strings.ToLower(sum) + "\x00" + c.keyID))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// loadSecret loads the secret of hmac, which is generated at the first time
+func loadSecret(file string) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err == nil && len(data) == 32 {
+		return data, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Errorf("failed to load encryption index (%s): %s", file, err.Error())
+	}
+	if err = os.MkdirAll(path.Dir(file), 0700); err != nil {
+		return nil, errors.Trace(err)
+	}
+	secret := make([]byte, 32)
+	if _, err = rand.Read(secret); err != nil {
+		return nil, errors.Trace(err)
+	}
+	t := file + ".tmp"
+	if err = ioutil.WriteFile(t, secret, 0600); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return secret, errors.Trace(os.Rename(t, file))
+}
+
+// encrypt encrypts the file into a temp file in dir, returns the temp file and the meta with encryption recorded
+func (c *crypter) encrypt(filename, dir string, meta map[string]string) (string, map[string]string, error) {
+	key := make([]byte, 32)
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(key); err != nil {
+		return "", nil, errors.Trace(err)
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, errors.Trace(err)
+	}
+	env := &envelope{
+		Algorithm: EncryptAES256GCM,
+		KeyID:     c.keyID,
+		Wrap:      c.wrap,
+		Nonce:     nonce,
+		Segment:   envelopeSegment,
+	}
+	if err := c.wrapKey(env, key); err != nil {
+		return "", nil, errors.Trace(err)
+	}
+	head, err := json.Marshal(env)
+	if err != nil {
+		return "", nil, errors.Trace(err)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return "", nil, errors.Trace(err)
+	}
+
+	src, err := os.Open(filename)
+	if err != nil {
+		return "", nil, errors.Trace(err)
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return "", nil, errors.Trace(err)
+	}
+	t := path.Join(dir, uuid.Generate().String())
+	dst, err := os.OpenFile(t, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", nil, errors.Trace(err)
+	}
+	w := bufio.NewWriter(dst)
+	err = c.seal(w, src, fi.Size(), aead, env, head)
+	if err == nil {
+		err = w.Flush()
+	}
+	if e := dst.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(t)
+		return "", nil, errors.Errorf("failed to encrypt file (%s): %s", filename, err.Error())
+	}
+
+	return t, c.meta(meta), nil
+}
+
+// meta returns the meta with the encryption of crypter recorded
+func (c *crypter) meta(meta map[string]string) map[string]string {
+	res := make(map[string]string, len(meta)+3)
+	for k, v := range meta {
+		res[k] = v
+	}
+	res[MetaEncryption] = EncryptAES256GCM
+	res[MetaEncryptionKeyID] = c.keyID
+	res[MetaEncryptionWrap] = c.wrap
+	return res
+}
+
+// seal writes the header, then the segments of size bytes read from r, the last segment is marked as final
+func (c *crypter) seal(w io.Writer, r io.Reader, size int64, aead cipher.AEAD, env *envelope, head []byte) error {
+	prefix := make([]byte, len(envelopeMagic)+4)
+	copy(prefix, envelopeMagic)
+	binary.BigEndian.PutUint32(prefix[len(envelopeMagic):], uint32(len(head)))
+	if _, err := w.Write(append(prefix, head...)); err != nil {
+		return err
+	}
+	buf := make([]byte, env.Segment, env.Segment+aead.Overhead())
+	for i := uint64(0); ; i++ {
+		n := int64(env.Segment)
+		if size < n {
+			n = size
+		}
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			return err
+		}
+		size -= n
+		nonce, aad := segmentNonce(env.Nonce, i, size == 0)
+		if _, err := w.Write(aead.Seal(buf[:0], nonce, buf[:n], aad)); err != nil {
+			return err
+		}
+		if size == 0 {
+			return nil
+		}
+	}
+}
+
+// decrypt decrypts the object downloaded into dst, returns false if the object isn't encrypted
+func (c *crypter) decrypt(filename, dst string) (bool, error) {
+	src, err := os.Open(filename)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	defer src.Close()
+	r := bufio.NewReader(src)
+	prefix, err := r.Peek(len(envelopeMagic) + 4)
+	if err != nil || string(prefix[:len(envelopeMagic)]) != envelopeMagic {
+		return false, nil
+	}
+	n := binary.BigEndian.Uint32(prefix[len(envelopeMagic):])
+	if n > envelopeMaxHead {
+		return false, errors.Errorf("failed to decrypt object: header too large (%d)", n)
+	}
+	r.Discard(len(prefix))
+	head := make([]byte, n)
+	if _, err = io.ReadFull(r, head); err != nil {
+		return false, errors.Errorf("failed to decrypt object: %s", err.Error())
+	}
+	var env envelope
+	if err = json.Unmarshal(head, &env); err != nil {
+		return false, errors.Errorf("failed to decrypt object: header invalid: %s", err.Error())
+	}
+	if env.Algorithm != EncryptAES256GCM || env.Segment <= 0 || env.Segment > envelopeMaxSeg || len(env.Nonce) != 12 {
+		return false, errors.Errorf("failed to decrypt object: algorithm (%s) or segment (%d) unsupported", env.Algorithm, env.Segment)
+	}
+	dc := c
+	if env.KeyID != c.keyID {
+		if dc = c.previous[env.KeyID]; dc == nil {
+			return false, errors.Errorf("failed to decrypt object: key (%s) mismatched, expected (%s) or the previous keys", env.KeyID, c.keyID)
+		}
+	}
+	key, err := dc.unwrapKey(&env)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	w := bufio.NewWriter(f)
+	err = c.open(w, r, aead, &env)
+	if err == nil {
+		err = w.Flush()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(dst)
+		return false, errors.Errorf("failed to decrypt object: %s", err.Error())
+	}
+	return true, nil
+}
+
+// open reads and opens the segments in order, the segment followed by nothing must be the final one
+func (c *crypter) open(w io.Writer, r *bufio.Reader, aead cipher.AEAD, env *envelope) error {
+	buf := make([]byte, env.Segment+aead.Overhead())
+	for i := uint64(0); ; i++ {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		_, err = r.Peek(1)
+		final := err == io.EOF
+		nonce, aad := segmentNonce(env.Nonce, i, final)
+		plain, err := aead.Open(buf[:0], nonce, buf[:n], aad)
+		if err != nil {
+			return errors.Errorf("segment (%d) corrupted or truncated", i)
+		}
+		if _, err = w.Write(plain); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
+
+// wrapKey wraps the data key by the public key or the static key
+func (c *crypter) wrapKey(env *envelope, key []byte) error {
+	switch c.wrap {
+	case WrapRSAOAEP:
+		wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, c.rsaPub, key, nil)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		env.Key = wrapped
+	case WrapX25519:
+		priv := make([]byte, curve25519.ScalarSize)
+		if _, err := rand.Read(priv); err != nil {
+			return errors.Trace(err)
+		}
+		pub, err := curve25519.X25519(priv, curve25519.Basepoint)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		kek, err := x25519KEK(priv, c.xPub, pub, c.xPub)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		env.Ephemeral = pub
+		env.Key, err = sealKey(kek, key, []byte(env.KeyID))
+		if err != nil {
+			return errors.Trace(err)
+		}
+	default:
+		var err error
+		env.Key, err = sealKey(c.static, key, []byte(env.KeyID))
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// unwrapKey unwraps the data key by the private key or the static key
+func (c *crypter) unwrapKey(env *envelope) ([]byte, error) {
+	if env.Wrap != c.wrap {
+		return nil, errors.Errorf("failed to decrypt object: wrap (%s) mismatched, expected (%s)", env.Wrap, c.wrap)
+	}
+	var key []byte
+	var err error
+	switch c.wrap {
+	case WrapRSAOAEP:
+		if c.rsaPriv == nil {
+			return nil, errors.New("failed to decrypt object: private key is required")
+		}
+		key, err = rsa.DecryptOAEP(sha256.New(), nil, c.rsaPriv, env.Key, nil)
+	case WrapX25519:
+		if c.xPriv == nil {
+			return nil, errors.New("failed to decrypt object: private key is required")
+		}
+		var kek []byte
+		kek, err = x25519KEK(c.xPriv, env.Ephemeral, env.Ephemeral, c.xPub)
+		if err == nil {
+			key, err = openKey(kek, env.Key, []byte(env.KeyID))
+		}
+	default:
+		key, err = openKey(c.static, env.Key, []byte(env.KeyID))
+	}
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("failed to decrypt object: failed to unwrap data key")
+	}
+	return key, nil
+}
+
+// segmentNonce returns the nonce of segment xored with its index, and the additional data marking the final segment
+func segmentNonce(base []byte, i uint64, final bool) ([]byte, []byte) {
+	nonce := make([]byte, len(base))
+	copy(nonce, base)
+	var idx [8]byte
+	binary.BigEndian.PutUint64(idx[:], i)
+	for k := range idx {
+		nonce[len(nonce)-8+k] ^= idx[k]
+	}
+	aad := append(idx[:], 0)
+	if final {
+		aad[8] = 1
+	}
+	return nonce, aad
+}
+
+// x25519KEK derives the key encryption key from the key agreed between the private key and the peer public key,
+// salted by the ephemeral public key and the recipient public key
+func x25519KEK(priv, peer, ephemeral, recipient []byte) ([]byte, error) {
+	shared, err := curve25519.X25519(priv, peer)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	salt := append(append([]byte{}, ephemeral...), recipient...)
+	kek := make([]byte, 32)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(envelopeInfo)), kek); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return kek, nil
+}
+
+// sealKey seals the data key by the key encryption key, the nonce is prepended
+func sealKey(kek, key, aad []byte) ([]byte, error) {
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return aead.Seal(nonce, nonce, key, aad), nil
+}
+
+func openKey(kek, wrapped, aad []byte) ([]byte, error) {
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key too short")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// loadStaticKey loads the key of 32 bytes in raw, hex or base64
+func loadStaticKey(filename string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Errorf("failed to load encryption key (%s): %s", filename, err.Error())
+	}
+	if len(data) == 32 {
+		return data, nil
+	}
+	s := string(bytes.TrimSpace(data))
+	if key, err := hex.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, errors.Errorf("failed to load encryption key (%s): the key should be 32 bytes in raw, hex or base64", filename)
+}
+
+// pkixKey the public key in PKIX or the private key in PKCS #8, to parse the key of X25519
+type pkixKey struct {
+	Algo      pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+type pkcs8Key struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+// loadPublicKey loads the PEM public key of RSA in PKIX or PKCS #1, or X25519 in PKIX
+func (c *crypter) loadPublicKey(filename string) error {
+	block, err := loadPEM(filename)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var pub *rsa.PublicKey
+	var xPub []byte
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		var k pkixKey
+		if _, err = asn1.Unmarshal(block.Bytes, &k); err == nil && k.Algo.Algorithm.Equal(oidX25519) {
+			xPub = k.PublicKey.RightAlign()
+			break
+		}
+		var key interface{}
+		if key, err = x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+			var ok bool
+			if pub, ok = key.(*rsa.PublicKey); !ok {
+				err = errors.New("the key should be RSA or X25519")
+			}
+		}
+	default:
+		err = errors.Errorf("PEM type (%s) unsupported", block.Type)
+	}
+	if err == nil && xPub != nil && len(xPub) != curve25519.PointSize {
+		err = errors.New("X25519 key invalid")
+	}
+	if err != nil {
+		return errors.Errorf("failed to load encryption key (%s): %s", filename, err.Error())
+	}
+	if (c.rsaPub != nil && (pub == nil || !c.rsaPub.Equal(pub))) || (c.xPub != nil && !bytes.Equal(c.xPub, xPub)) {
+		return errors.New("failed to load encryption key: public key and private key mismatched")
+	}
+	c.rsaPub, c.xPub = pub, xPub
+	return nil
+}
+
+// loadPrivateKey loads the PEM private key of RSA in PKCS #8 or PKCS #1, or X25519 in PKCS #8,
+// the public key is derived from it
+func (c *crypter) loadPrivateKey(filename string) error {
+	block, err := loadPEM(filename)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		c.rsaPriv, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		var k pkcs8Key
+		if _, err = asn1.Unmarshal(block.Bytes, &k); err == nil && k.Algo.Algorithm.Equal(oidX25519) {
+			if _, err = asn1.Unmarshal(k.PrivateKey, &c.xPriv); err == nil && len(c.xPriv) != curve25519.ScalarSize {
+				err = errors.New("X25519 key invalid")
+			}
+			break
+		}
+		var key interface{}
+		if key, err = x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+			var ok bool
+			if c.rsaPriv, ok = key.(*rsa.PrivateKey); !ok {
+				err = errors.New("the key should be RSA or X25519")
+			}
+		}
+	default:
+		err = errors.Errorf("PEM type (%s) unsupported", block.Type)
+	}
+	if err == nil && c.xPriv != nil {
+		c.xPub, err = curve25519.X25519(c.xPriv, curve25519.Basepoint)
+	}
+	if err != nil {
+		return errors.Errorf("failed to load encryption key (%s): %s", filename, err.Error())
+	}
+	if c.rsaPriv != nil {
+		c.rsaPub = &c.rsaPriv.PublicKey
+	}
+	return nil
+}
+
+func loadPEM(filename string) (*pem.Block, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Errorf("failed to load encryption key (%s): %s", filename, err.Error())
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.Errorf("failed to load encryption key (%s): PEM not found", filename)
+	}
+	return block, nil
+}
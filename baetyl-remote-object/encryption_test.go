@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/curve25519"
+)
+
+func writePEM(t *testing.T, filename, typ string, der []byte) string {
+	assert.NoError(t, ioutil.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600))
+	return filename
+}
+
+// newTestKeys generates the keys of RSA, X25519 and the static key in dir
+func newTestKeys(t *testing.T, dir string) map[string]Encryption {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	rsaPriv, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	assert.NoError(t, err)
+	rsaPub, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	assert.NoError(t, err)
+
+	xKey := make([]byte, curve25519.ScalarSize)
+	_, err = rand.Read(xKey)
+	assert.NoError(t, err)
+	xPubKey, err := curve25519.X25519(xKey, curve25519.Basepoint)
+	assert.NoError(t, err)
+	algo := pkix.AlgorithmIdentifier{Algorithm: oidX25519}
+	xPub, err := asn1.Marshal(pkixKey{Algo: algo, PublicKey: asn1.BitString{Bytes: xPubKey, BitLength: 256}})
+	assert.NoError(t, err)
+	inner, err := asn1.Marshal(xKey)
+	assert.NoError(t, err)
+	xPriv, err := asn1.Marshal(pkcs8Key{Algo: algo, PrivateKey: inner})
+	assert.NoError(t, err)
+
+	static := make([]byte, 32)
+	_, err = rand.Read(static)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(path.Join(dir, "static.key"), []byte(hex.EncodeToString(static)+"\n"), 0600))
+
+	return map[string]Encryption{
+		"rsa": {
+			PublicKey:  writePEM(t, path.Join(dir, "rsa.pub"), "PUBLIC KEY", rsaPub),
+			PrivateKey: writePEM(t, path.Join(dir, "rsa.key"), "PRIVATE KEY", rsaPriv),
+		},
+		"x25519": {
+			PublicKey:  writePEM(t, path.Join(dir, "x25519.pub"), "PUBLIC KEY", xPub),
+			PrivateKey: writePEM(t, path.Join(dir, "x25519.key"), "PRIVATE KEY", xPriv),
+		},
+		"static": {KeyFile: path.Join(dir, "static.key")},
+	}
+}
+
+func TestCrypter(t *testing.T) {
+	dir := t.TempDir()
+	keys := newTestKeys(t, dir)
+	wraps := map[string]string{"rsa": WrapRSAOAEP, "x25519": WrapX25519, "static": EncryptAES256GCM}
+	for name, cfg := range keys {
+		t.Run(name, func(t *testing.T) {
+			c, err := newCrypter(cfg)
+			assert.NoError(t, err)
+			assert.Equal(t, wraps[name], c.wrap)
+			assert.Len(t, c.keyID, 16)
+
+			// round 1: encrypt and decrypt files of sizes around the segment
+			for _, size := range []int{0, 1, envelopeSegment - 1, envelopeSegment, envelopeSegment + 1, 3*envelopeSegment + 5} {
+				data := make([]byte, size)
+				_, err = rand.Read(data)
+				assert.NoError(t, err)
+				src := path.Join(dir, "src")
+				assert.NoError(t, ioutil.WriteFile(src, data, 0644))
+				enc, meta, err := c.encrypt(src, dir, map[string]string{"k": "v"})
+				assert.NoError(t, err)
+				assert.Equal(t, map[string]string{"k": "v", MetaEncryption: EncryptAES256GCM, MetaEncryptionKeyID: c.keyID, MetaEncryptionWrap: c.wrap}, meta)
+				cipher, err := ioutil.ReadFile(enc)
+				assert.NoError(t, err)
+				assert.False(t, size > 16 && bytes.Contains(cipher, data))
+				dst := path.Join(dir, "dst")
+				ok, err := c.decrypt(enc, dst)
+				assert.NoError(t, err)
+				assert.True(t, ok)
+				plain, err := ioutil.ReadFile(dst)
+				assert.NoError(t, err)
+				assert.Equal(t, data, plain)
+				assert.NoError(t, os.Remove(enc))
+			}
+
+			// round 2: the object tampered or truncated
+			data := make([]byte, 2*envelopeSegment)
+			src := path.Join(dir, "src")
+			assert.NoError(t, ioutil.WriteFile(src, data, 0644))
+			enc, _, err := c.encrypt(src, dir, nil)
+			assert.NoError(t, err)
+			cipher, err := ioutil.ReadFile(enc)
+			assert.NoError(t, err)
+			tampered := append([]byte{}, cipher...)
+			tampered[len(tampered)-20] ^= 1
+			assert.NoError(t, ioutil.WriteFile(enc, tampered, 0644))
+			_, err = c.decrypt(enc, path.Join(dir, "dst"))
+			assert.Error(t, err)
+			assert.False(t, utils.FileExists(path.Join(dir, "dst")))
+			assert.NoError(t, ioutil.WriteFile(enc, cipher[:len(cipher)-envelopeSegment-16], 0644))
+			_, err = c.decrypt(enc, path.Join(dir, "dst"))
+			assert.Error(t, err)
+
+			// round 3: the key of object mismatched
+			assert.NoError(t, ioutil.WriteFile(enc, cipher, 0644))
+			other := cfg
+			other.KeyID = "other"
+			o, err := newCrypter(other)
+			assert.NoError(t, err)
+			_, err = o.decrypt(enc, path.Join(dir, "dst"))
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), "mismatched")
+
+			// round 4: the object is decrypted by the previous key after the key rotated
+			rotated, err := newCrypter(Encryption{KeyID: "rotated", KeyFile: keys["static"].KeyFile, Previous: []Encryption{cfg}})
+			assert.NoError(t, err)
+			ok, err := rotated.decrypt(enc, path.Join(dir, "dst"))
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		})
+	}
+
+	// the object not encrypted is kept
+	c, err := newCrypter(keys["static"])
+	assert.NoError(t, err)
+	plain := path.Join(dir, "plain")
+	assert.NoError(t, ioutil.WriteFile(plain, []byte("abc"), 0644))
+	ok, err := c.decrypt(plain, path.Join(dir, "dst"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// the private key is required to decrypt
+	c, err = newCrypter(Encryption{PublicKey: keys["x25519"].PublicKey})
+	assert.NoError(t, err)
+	enc, _, err := c.encrypt(plain, dir, nil)
+	assert.NoError(t, err)
+	_, err = c.decrypt(enc, path.Join(dir, "dst"))
+	assert.Error(t, err)
+
+	// keys invalid
+	for _, cfg := range []Encryption{
+		{},
+		{KeyFile: plain},
+		{KeyFile: keys["static"].KeyFile, PublicKey: keys["rsa"].PublicKey},
+		{PublicKey: keys["rsa"].PublicKey, PrivateKey: keys["x25519"].PrivateKey},
+		{PublicKey: plain},
+		{KeyFile: keys["static"].KeyFile, Previous: []Encryption{{KeyFile: keys["static"].KeyFile}}},
+		{KeyFile: keys["static"].KeyFile, Previous: []Encryption{{KeyFile: plain}}},
+	} {
+		_, err = newCrypter(cfg)
+		assert.Error(t, err)
+	}
+}
+
+func TestClientEncryption(t *testing.T) {
+	cli, h := newMockClient(t)
+	keys := newTestKeys(t, t.TempDir())
+	var err error
+	cli.crypter, err = newCrypter(keys["rsa"])
+	assert.NoError(t, err)
+	md5, err := utils.CalculateFileMD5("example/etc/baetyl/service-bos.yml")
+	assert.NoError(t, err)
+
+	res, err := cli.upload("example/etc/baetyl/service-bos.yml", "", "a/service.yml", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, md5, res.MD5)
+	data, err := ioutil.ReadFile(path.Join(h.dir, "bucket", "a/service.yml"))
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(data, []byte(envelopeMagic)))
+	files, err := ioutil.ReadDir(cli.cfg.TempPath)
+	assert.NoError(t, err)
+	assert.Len(t, files, 0)
+
+	res, err = cli.handleDownloadEvent(&DownloadEvent{RemotePath: "a/service.yml", LocalPath: "b/service.yml", MD5: md5})
+	assert.NoError(t, err)
+	assert.Equal(t, md5, res.MD5)
+	got, err := utils.CalculateFileMD5(path.Join(cli.pwd, "b/service.yml"))
+	assert.NoError(t, err)
+	assert.Equal(t, md5, got)
+	files, err = ioutil.ReadDir(path.Join(cli.pwd, "b"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	// the file encrypted is kept if the upload is resumable, and uploaded again as it is
+	cli.cfg.MultiPart = MultiPart{Resume: true, PartSize: 1, Expiration: time.Hour}
+	h.putErr = fmt.Errorf("network unreachable")
+	_, err = cli.upload("example/etc/baetyl/service-bos.yml", "", "a/kept.yml", nil, nil)
+	assert.Error(t, err)
+	files, err = ioutil.ReadDir(cli.cfg.TempPath)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	staged, err := ioutil.ReadFile(path.Join(cli.cfg.TempPath, files[0].Name()))
+	assert.NoError(t, err)
+	_, err = cli.upload("example/etc/baetyl/service-bos.yml", "", "a/kept.yml", nil, nil)
+	assert.NoError(t, err)
+	data, err = ioutil.ReadFile(path.Join(h.dir, "bucket", "a/kept.yml"))
+	assert.NoError(t, err)
+	assert.Equal(t, staged, data)
+	files, err = ioutil.ReadDir(cli.cfg.TempPath)
+	assert.NoError(t, err)
+	assert.Len(t, files, 0)
+
+	// the file unchanged is skipped if the object uploaded is recorded and still there
+	cli.cfg.MultiPart = MultiPart{}
+	cfg := keys["rsa"]
+	cfg.Index = path.Join(t.TempDir(), "index")
+	cli.crypter, err = newCrypter(cfg)
+	assert.NoError(t, err)
+	h.check = true
+	puts := h.puts
+	res, err = cli.upload("example/etc/baetyl/service-bos.yml", "", "a/service.yml", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, puts+1, h.puts)
+	res, err = cli.upload("example/etc/baetyl/service-bos.yml", "", "a/service.yml", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusSkipped, res.Status)
+	assert.Equal(t, puts+1, h.puts)
+	records, err := ioutil.ReadDir(cfg.Index)
+	assert.NoError(t, err)
+	for _, r := range records {
+		data, err := ioutil.ReadFile(path.Join(cfg.Index, r.Name()))
+		assert.NoError(t, err)
+		assert.NotContains(t, string(data), md5)
+	}
+
+	// uploaded again if the object changed, or the file is encrypted by another key
+	assert.NoError(t, ioutil.WriteFile(path.Join(h.dir, "bucket", "a/service.yml"), []byte("changed"), 0644))
+	_, err = cli.upload("example/etc/baetyl/service-bos.yml", "", "a/service.yml", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, puts+2, h.puts)
+	cfg.KeyID = "rotated"
+	cli.crypter, err = newCrypter(cfg)
+	assert.NoError(t, err)
+	_, err = cli.upload("example/etc/baetyl/service-bos.yml", "", "a/service.yml", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, puts+3, h.puts)
+}
@@ -11,23 +11,78 @@ type EventType string
 
 // The type of event from cloud
 const (
-	Upload  EventType = "UPLOAD"
-	Package EventType = "PACKAGE"
+	Upload   EventType = "UPLOAD"
+	Package  EventType = "PACKAGE"
+	Download EventType = "DOWNLOAD"
+	Sync     EventType = "SYNC"
+)
+
+// ResultStatus the status of event result
+type ResultStatus string
+
+// The status of event result
+const (
+	StatusSucceeded ResultStatus = "succeeded"
+	StatusSkipped   ResultStatus = "skipped"
+	StatusFailed    ResultStatus = "failed"
 )
 
 // Event event message
 type Event struct {
-	Time    time.Time   `json:"time"`
-	Type    EventType   `json:"type"`
-	Content interface{} `json:"content"`
+	Time       time.Time   `json:"time"`
+	Type       EventType   `json:"type"`
+	Content    interface{} `json:"content"`
+	RequestID  string      `json:"requestId,omitempty"`  // echoed in the result to correlate with the request
+	ReplyTopic string      `json:"replyTopic,omitempty"` // topic to publish the result, overrides the reply topic of rule
+	NotBefore  *time.Time  `json:"notBefore,omitempty"`  // the event is not executed before the time
+	Deadline   *time.Time  `json:"deadline,omitempty"`   // the event bypasses the schedule if no window opens before the deadline, and fails after it
+}
+
+// Result the result of event published to the reply topic
+type Result struct {
+	RequestID  string       `json:"requestId,omitempty"`
+	Type       EventType    `json:"type"`
+	Status     ResultStatus `json:"status"`
+	Bucket     string       `json:"bucket,omitempty"`
+	RemotePath string       `json:"remotePath,omitempty"`
+	LocalPath  string       `json:"localPath,omitempty"`
+	Size       int64        `json:"size,omitempty"`
+	MD5        string       `json:"md5,omitempty"`
+	ETag       string       `json:"etag,omitempty"`
+	Uploaded   int          `json:"uploaded,omitempty"` // the count of files uploaded by sync
+	Skipped    int          `json:"skipped,omitempty"`  // the count of files unchanged since last sync
+	Deleted    int          `json:"deleted,omitempty"`  // the count of remote objects deleted by sync
+	Duration   int64        `json:"duration"`           // in milliseconds
+	Error      string       `json:"error,omitempty"`
+	Client     string       `json:"client,omitempty"`  // the target client of the result in targets
+	Targets    []*Result    `json:"targets,omitempty"` // the results of targets if the rule has multiple targets
+}
+
+// newResult completes the result of event message with the error
+func newResult(msg *EventMessage, res *Result, err error) *Result {
+	if res == nil {
+		res = &Result{}
+	}
+	if msg.Event != nil {
+		res.RequestID = msg.Event.RequestID
+		res.Type = msg.Event.Type
+	}
+	if err != nil {
+		res.Status = StatusFailed
+		res.Error = err.Error()
+	} else if res.Status == "" {
+		res.Status = StatusSucceeded
+	}
+	return res
 }
 
 // EventMessage config
 type EventMessage struct {
-	ID    uint64
-	QOS   uint32
-	Topic string
-	Event *Event
+	ID        uint64
+	QOS       uint32
+	Topic     string
+	Event     *Event
+	JournalID string // the id of task in journal, empty if not journaled
 }
 
 // NewEvent creates a new event
@@ -39,6 +94,18 @@ func NewEvent(v []byte) (*Event, error) {
 		e.Content = &UploadEvent{}
 		json.Unmarshal(v, &e)
 		return &e, nil
+	case Package:
+		e.Content = &PackageEvent{}
+		json.Unmarshal(v, &e)
+		return &e, nil
+	case Download:
+		e.Content = &DownloadEvent{}
+		json.Unmarshal(v, &e)
+		return &e, nil
+	case Sync:
+		e.Content = &SyncEvent{}
+		json.Unmarshal(v, &e)
+		return &e, nil
 	default:
 		return nil, fmt.Errorf("event type unexpected")
 	}
@@ -50,4 +117,36 @@ type UploadEvent struct {
 	LocalPath  string            `yaml:"localPath" json:"localPath" validate:"nonzero"`
 	Zip        bool              `yaml:"zip" json:"zip"`
 	Meta       map[string]string `yaml:"meta" json:"meta" default:"{}"`
+	Bucket     string            `yaml:"-" json:"-"`                       // set by the route matched only, the bucket of client if empty
+	Options    *ObjectOptions    `yaml:"options" json:"options,omitempty"` // overrides the object options of client
+}
+
+// PackageEvent package event, bundles the files of local paths into one archive with a manifest
+type PackageEvent struct {
+	RemotePath string            `yaml:"remotePath" json:"remotePath" validate:"nonzero"`
+	LocalPaths []string          `yaml:"localPaths" json:"localPaths" validate:"nonzero"`
+	Include    []string          `yaml:"include" json:"include"` // glob patterns of files to include, all files included if empty
+	Exclude    []string          `yaml:"exclude" json:"exclude"` // glob patterns of files to exclude
+	Zip        bool              `yaml:"zip" json:"zip"`         // zip if true, otherwise tar
+	Meta       map[string]string `yaml:"meta" json:"meta" default:"{}"`
+}
+
+// DownloadEvent download event, fetches an object to local path
+type DownloadEvent struct {
+	RemotePath string         `yaml:"remotePath" json:"remotePath" validate:"nonzero"`
+	LocalPath  string         `yaml:"localPath" json:"localPath" validate:"nonzero"`
+	MD5        string         `yaml:"md5" json:"md5"`                   // md5 in hex to verify the object, not verified if empty
+	Unpack     bool           `yaml:"unpack" json:"unpack"`             // extract the object of zip or tar into local path as a directory
+	Options    *ObjectOptions `yaml:"options" json:"options,omitempty"` // the options the object uploaded with, such as SSE-C to read it
+}
+
+// SyncEvent sync event, mirrors the files of local directory to remote prefix one by one,
+// only the files new or changed since last sync are uploaded
+type SyncEvent struct {
+	RemotePath string            `yaml:"remotePath" json:"remotePath" validate:"nonzero"` // the remote prefix
+	LocalPath  string            `yaml:"localPath" json:"localPath" validate:"nonzero"`   // the local directory
+	Include    []string          `yaml:"include" json:"include"`                          // glob patterns of files to include, all files included if empty
+	Exclude    []string          `yaml:"exclude" json:"exclude"`                          // glob patterns of files to exclude
+	Delete     bool              `yaml:"delete" json:"delete"`                            // delete the remote objects whose local files are gone
+	Meta       map[string]string `yaml:"meta" json:"meta" default:"{}"`
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 
@@ -37,3 +38,56 @@ func TestNewEvent(t *testing.T) {
 	assert.Nil(t, got)
 	assert.Equal(t, "event type unexpected", err.Error())
 }
+
+func TestNewPackageEvent(t *testing.T) {
+	d := []byte(`{"type":"PACKAGE","content":{"remotePath":"a.tar","localPaths":["var/log","etc"],"include":["*.log"],"exclude":["*.tmp"]}}`)
+	got, err := NewEvent(d)
+	assert.NoError(t, err)
+	p, ok := got.Content.(*PackageEvent)
+	assert.True(t, ok)
+	assert.Equal(t, "a.tar", p.RemotePath)
+	assert.Equal(t, []string{"var/log", "etc"}, p.LocalPaths)
+	assert.Equal(t, []string{"*.log"}, p.Include)
+	assert.Equal(t, []string{"*.tmp"}, p.Exclude)
+	assert.False(t, p.Zip)
+}
+
+func TestNewDownloadEvent(t *testing.T) {
+	d := []byte(`{"type":"DOWNLOAD","content":{"remotePath":"a.zip","localPath":"var/model","md5":"abc","unpack":true}}`)
+	got, err := NewEvent(d)
+	assert.NoError(t, err)
+	e, ok := got.Content.(*DownloadEvent)
+	assert.True(t, ok)
+	assert.Equal(t, "a.zip", e.RemotePath)
+	assert.Equal(t, "var/model", e.LocalPath)
+	assert.Equal(t, "abc", e.MD5)
+	assert.True(t, e.Unpack)
+}
+
+func TestNewSyncEvent(t *testing.T) {
+	d := []byte(`{"type":"SYNC","content":{"remotePath":"logs","localPath":"var/log","exclude":["*.tmp"],"delete":true}}`)
+	got, err := NewEvent(d)
+	assert.NoError(t, err)
+	e, ok := got.Content.(*SyncEvent)
+	assert.True(t, ok)
+	assert.Equal(t, "logs", e.RemotePath)
+	assert.Equal(t, "var/log", e.LocalPath)
+	assert.Equal(t, []string{"*.tmp"}, e.Exclude)
+	assert.True(t, e.Delete)
+}
+
+func TestNewResult(t *testing.T) {
+	msg := &EventMessage{Event: &Event{Type: Upload, RequestID: "r1"}}
+	res := newResult(msg, nil, nil)
+	assert.Equal(t, "r1", res.RequestID)
+	assert.Equal(t, Upload, res.Type)
+	assert.Equal(t, StatusSucceeded, res.Status)
+
+	res = newResult(msg, &Result{Status: StatusSkipped}, nil)
+	assert.Equal(t, StatusSkipped, res.Status)
+
+	res = newResult(msg, &Result{RemotePath: "a"}, fmt.Errorf("failed"))
+	assert.Equal(t, StatusFailed, res.Status)
+	assert.Equal(t, "failed", res.Error)
+	assert.Equal(t, "a", res.RemotePath)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+)
+
+// The policies of rule with multiple targets
+const (
+	PolicyAll      = "all"      // succeeds if all targets succeed
+	PolicyAny      = "any"      // succeeds if any target succeeds
+	PolicyFallback = "fallback" // the targets are tried one by one until one succeeds
+)
+
+// dispatcher submits the event messages of rule to the target
+type dispatcher interface {
+	Journal(rule string, msg *EventMessage) (bool, error)
+	CallAsync(msg *EventMessage, cb ruleHook) error
+	Resume(rule string, cb ruleHook) error
+}
+
+// fanout dispatches the event message to multiple clients by the policy, the callback is invoked once
+// after all targets tried, so the message is acknowledged and the source file is handled once.
+// The message is journaled by the first client which records the state of all targets
+type fanout struct {
+	policy  string
+	clients []*Client
+	log     *log.Logger
+}
+
+func newFanout(policy string, clients []*Client, rule string) (*fanout, error) {
+	switch policy {
+	case PolicyAll, PolicyAny, PolicyFallback:
+	default:
+		return nil, errors.Errorf("policy (%s) of rule (%s) invalid, should be all, any or fallback", policy, rule)
+	}
+	return &fanout{
+		policy:  policy,
+		clients: clients,
+		log:     log.With(log.Any("rule", rule), log.Any("policy", policy)),
+	}, nil
+}
+
+// fanoutCall the state of a message dispatched to targets
+type fanoutCall struct {
+	msg     *EventMessage
+	cb      ruleHook
+	start   time.Time
+	results []*Result
+	errs    []error
+	pending int
+	lock    sync.Mutex
+}
+
+// Journal journals the message by the first client
+func (f *fanout) Journal(rule string, msg *EventMessage) (bool, error) {
+	return f.clients[0].Journal(rule, msg)
+}
+
+// Resume dispatches the messages pending in the journal of the first client
+func (f *fanout) Resume(rule string, cb ruleHook) error {
+	msgs, err := f.clients[0].Pending(rule)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, msg := range msgs {
+		f.log.Info("resume task from journal", log.Any("task", msg.JournalID))
+		if err = f.CallAsync(msg, cb); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// CallAsync dispatches the message to all targets at the same time, or to the first one if fallback
+func (f *fanout) CallAsync(msg *EventMessage, cb ruleHook) error {
+	c := &fanoutCall{
+		msg:     msg,
+		cb:      cb,
+		start:   time.Now(),
+		results: make([]*Result, len(f.clients)),
+		errs:    make([]error, len(f.clients)),
+		pending: len(f.clients),
+	}
+	f.clients[0].finish(msg, TaskRunning, nil)
+	if f.policy == PolicyFallback {
+		f.submit(c, 0)
+		return nil
+	}
+	for i := range f.clients {
+		f.submit(c, i)
+	}
+	return nil
+}
+
+// submit submits a copy of message to the client at index, the copy isn't journaled by the client
+func (f *fanout) submit(c *fanoutCall, i int) {
+	cli := f.clients[i]
+	msg, err := copyMessage(c.msg)
+	if err != nil {
+		f.done(c, i, msg, nil, err)
+		return
+	}
+	err = cli.CallAsync(msg, func(m *EventMessage, res *Result, err error) {
+		f.done(c, i, m, res, err)
+	})
+	if err != nil {
+		f.done(c, i, msg, nil, err)
+	}
+}
+
+// done records the result of target, tries the next target if fallback, then finishes once all targets tried
+func (f *fanout) done(c *fanoutCall, i int, msg *EventMessage, res *Result, err error) {
+	res = newResult(msg, res, err)
+	res.Client = f.clients[i].cfg.Name
+	c.lock.Lock()
+	c.results[i], c.errs[i] = res, err
+	c.pending--
+	next := f.policy == PolicyFallback && err != nil && i+1 < len(f.clients)
+	finished := c.pending == 0 || (f.policy == PolicyFallback && !next)
+	c.lock.Unlock()
+	if err != nil {
+		f.log.Warn("failed to dispatch to target", log.Any("client", res.Client), log.Error(err))
+	}
+	if next {
+		f.submit(c, i+1)
+		return
+	}
+	if finished {
+		f.finish(c)
+	}
+}
+
+// finish reports the result of all targets by the policy
+func (f *fanout) finish(c *fanoutCall) {
+	var base *Result
+	var targets []*Result
+	var failed []string
+	for i, res := range c.results {
+		if res == nil {
+			continue
+		}
+		targets = append(targets, res)
+		if c.errs[i] != nil {
+			failed = append(failed, res.Client+": "+c.errs[i].Error())
+		} else if base == nil {
+			base = res
+		}
+	}
+	var err error
+	if base == nil || (f.policy == PolicyAll && len(failed) > 0) {
+		err = errors.Errorf("failed to dispatch to targets (%s)", strings.Join(failed, "; "))
+	}
+	res := &Result{}
+	if base != nil {
+		*res = *base
+		res.Client = ""
+		res.Error = ""
+	}
+	res.Targets = targets
+	if err != nil {
+		f.clients[0].finish(c.msg, TaskFailed, err)
+	} else {
+		f.clients[0].finish(c.msg, TaskSucceeded, nil)
+	}
+	if c.cb != nil {
+		res = newResult(c.msg, res, err)
+		res.Duration = int64(time.Since(c.start) / time.Millisecond)
+		c.cb(c.msg, res, err)
+	}
+}
+
+// copyMessage copies the message without journal id, the event is copied deeply since it may be changed by client,
+// the bucket routed is copied as well since it is not encoded
+func copyMessage(msg *EventMessage) (*EventMessage, error) {
+	m := *msg
+	m.JournalID = ""
+	if msg.Event == nil {
+		return &m, nil
+	}
+	data, err := json.Marshal(msg.Event)
+	if err != nil {
+		return &m, errors.Trace(err)
+	}
+	m.Event, err = NewEvent(data)
+	if err != nil {
+		return &m, errors.Trace(err)
+	}
+	if e, ok := msg.Event.Content.(*UploadEvent); ok {
+		m.Event.Content.(*UploadEvent).Bucket = e.Bucket
+	}
+	return &m, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/panjf2000/ants"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMockFanout(t *testing.T, policy string) (*fanout, []*mockHandler) {
+	var clients []*Client
+	var handlers []*mockHandler
+	for _, name := range []string{"a", "b", "c"} {
+		cli, h := newMockClient(t)
+		cli.cfg.Name = name
+		pool, err := ants.NewPoolWithFunc(cli.cfg.Pool.Worker, cli.call)
+		assert.NoError(t, err)
+		t.Cleanup(pool.Release)
+		cli.pool = pool
+		clients = append(clients, cli)
+		handlers = append(handlers, h)
+	}
+	// the targets share the local dir, and the target b fails since its remote dir is a file
+	pwd := t.TempDir()
+	assert.NoError(t, copyFile("example/etc/baetyl/service-bos.yml", path.Join(pwd, "service.yml")))
+	for _, cli := range clients {
+		cli.pwd = pwd
+		cli.cfg.Journal.Enable = true
+	}
+	assert.NoError(t, ioutil.WriteFile(handlers[1].dir, nil, 0644))
+	var err error
+	clients[0].journal, err = newJournal(Journal{Enable: true, Path: path.Join(t.TempDir(), "journal"), Retention: time.Hour}, "a")
+	assert.NoError(t, err)
+	f, err := newFanout(policy, clients, "rule")
+	assert.NoError(t, err)
+	return f, handlers
+}
+
+func TestFanout(t *testing.T) {
+	call := func(f *fanout) (*EventMessage, []*Result, []error) {
+		msg := &EventMessage{
+			Event: &Event{
+				Type:    Upload,
+				Content: &UploadEvent{RemotePath: "a/service.yml", LocalPath: "service.yml"},
+			},
+		}
+		_, err := f.Journal("rule", msg)
+		assert.NoError(t, err)
+		var results []*Result
+		var errs []error
+		done := make(chan struct{})
+		assert.NoError(t, f.CallAsync(msg, func(msg *EventMessage, res *Result, err error) {
+			results = append(results, res)
+			errs = append(errs, err)
+			close(done)
+		}))
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			t.Fatal("fanout not finished")
+		}
+		// the callback is invoked once
+		time.Sleep(100 * time.Millisecond)
+		return msg, results, errs
+	}
+
+	// round 1: all targets must succeed
+	f, handlers := newMockFanout(t, PolicyAll)
+	msg, results, errs := call(f)
+	assert.Len(t, results, 1)
+	assert.Error(t, errs[0])
+	assert.Contains(t, errs[0].Error(), "b: ")
+	assert.Equal(t, StatusFailed, results[0].Status)
+	assert.Len(t, results[0].Targets, 3)
+	assert.Equal(t, "a", results[0].Targets[0].Client)
+	assert.Equal(t, StatusSucceeded, results[0].Targets[0].Status)
+	assert.Equal(t, StatusFailed, results[0].Targets[1].Status)
+	assert.Equal(t, StatusSucceeded, results[0].Targets[2].Status)
+	assert.Equal(t, 1, handlers[0].puts)
+	assert.Equal(t, 1, handlers[2].puts)
+	assert.Equal(t, TaskFailed, f.clients[0].journal.index[msg.JournalID].State)
+
+	// round 2: any target suffices
+	f, handlers = newMockFanout(t, PolicyAny)
+	msg, results, errs = call(f)
+	assert.Len(t, results, 1)
+	assert.NoError(t, errs[0])
+	assert.Equal(t, StatusSucceeded, results[0].Status)
+	assert.Equal(t, "bucket", results[0].Bucket)
+	assert.Empty(t, results[0].Client)
+	assert.Len(t, results[0].Targets, 3)
+	assert.Equal(t, TaskSucceeded, f.clients[0].journal.index[msg.JournalID].State)
+
+	// round 3: the primary target succeeds without fallback
+	f, handlers = newMockFanout(t, PolicyFallback)
+	_, results, errs = call(f)
+	assert.NoError(t, errs[0])
+	assert.Len(t, results[0].Targets, 1)
+	assert.Equal(t, 1, handlers[0].puts)
+	assert.Equal(t, 0, handlers[2].puts)
+
+	// round 4: the targets are tried in order until one succeeds
+	f, handlers = newMockFanout(t, PolicyFallback)
+	f.clients[0], f.clients[1] = f.clients[1], f.clients[0]
+	f.clients[1].journal, f.clients[0].journal = nil, f.clients[1].journal
+	msg, results, errs = call(f)
+	assert.NoError(t, errs[0])
+	assert.Len(t, results[0].Targets, 2)
+	assert.Equal(t, "b", results[0].Targets[0].Client)
+	assert.Equal(t, StatusFailed, results[0].Targets[0].Status)
+	assert.Equal(t, "a", results[0].Targets[1].Client)
+	assert.Equal(t, StatusSucceeded, results[0].Targets[1].Status)
+	assert.Equal(t, 1, handlers[0].puts)
+	assert.Equal(t, 0, handlers[2].puts)
+	assert.Equal(t, TaskSucceeded, f.clients[0].journal.index[msg.JournalID].State)
+
+	// round 5: policy invalid
+	_, err := newFanout("some", f.clients, "rule")
+	assert.Error(t, err)
+}
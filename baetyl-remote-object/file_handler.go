@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	v1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/docker/distribution/uuid"
+)
+
+// the directory under the root of file storage to keep the sidecar meta of objects
+const fileMetaDir = ".meta"
+
+// the suffix of sidecar meta stored beside the object in storages without meta, such as SFTP and WEBDAV
+const sidecarSuffix = ".meta.json"
+
+// FileMeta the sidecar meta of object stored in file storage
+type FileMeta struct {
+	Size         int64             `json:"size"`
+	MD5          string            `json:"md5"` // md5 in hex, used as etag
+	Meta         map[string]string `json:"meta,omitempty"`
+	LastModified time.Time         `json:"lastModified"`
+}
+
+// matches reports whether the meta is of the object in the size with the md5
+func (m *FileMeta) matches(size int64, md5 string) bool {
+	return m.Size == size && strings.EqualFold(m.MD5, md5)
+}
+
+// FileHandler stores objects in a local or mounted directory (NFS, SMB, USB disk),
+// the object is stored in <endpoint>/<bucket>/<remotePath> and its meta in <endpoint>/.meta/<bucket>/<remotePath>.json
+type FileHandler struct {
+	root       string
+	cfg        ClientInfo
+	throttlers []*throttler
+	log        *log.Logger
+}
+
+// NewFileHandler creates a new file handler, the endpoint is the root directory
+func NewFileHandler(cfg ClientInfo) (StorageHandler, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.Errorf("failed to create file client (%s): endpoint is required as the root directory", cfg.Name)
+	}
+	root := strings.TrimPrefix(cfg.Endpoint, "file://")
+	err := os.MkdirAll(root, 0755)
+	if err != nil {
+		return nil, errors.Errorf("failed to create file client (%s): %s", cfg.Name, err.Error())
+	}
+	return &FileHandler{
+		root:       root,
+		cfg:        cfg,
+		throttlers: []*throttler{newThrottler(cfg.Throttle), globalThrottler},
+		log:        log.With(log.Any("storage", "file")),
+	}, nil
+}
+
+// PutObjectFromFile copies file to storage atomically, returns the md5 in hex as etag
+func (cli *FileHandler) PutObjectFromFile(Bucket, remotePath, filename string, meta map[string]string) (string, error) {
+	object, err := cli.object(Bucket, remotePath)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	defer f.Close()
+	h := md5.New()
+	size, err := writeFileAtomic(object, io.TeeReader(throttled(f, cli.throttlers...), h))
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	fm := &FileMeta{
+		Size:         size,
+		MD5:          hex.EncodeToString(h.Sum(nil)),
+		Meta:         meta,
+		LastModified: time.Now(),
+	}
+	data, err := json.Marshal(fm)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	_, err = writeFileAtomic(cli.meta(Bucket, remotePath), bytes.NewReader(data))
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return fm.MD5, nil
+}
+
+// GetObjectToFile copies object to file
+func (cli *FileHandler) GetObjectToFile(Bucket, remotePath, filename string) error {
+	object, err := cli.object(Bucket, remotePath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	src, err := os.Open(object)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(filename, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer dst.Close()
+	_, err = io.Copy(dst, src)
+	return errors.Trace(err)
+}
+
+// DeleteObject removes object and its meta
+func (cli *FileHandler) DeleteObject(Bucket, remotePath string) error {
+	object, err := cli.object(Bucket, remotePath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = os.Remove(object)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Trace(err)
+	}
+	err = os.Remove(cli.meta(Bucket, remotePath))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// FileExists reports whether the object exists with the md5 stored in its meta
+func (cli *FileHandler) FileExists(Bucket, remotePath, md5 string) bool {
+	object, err := cli.object(Bucket, remotePath)
+	if err != nil {
+		return false
+	}
+	fi, err := os.Stat(object)
+	if err != nil {
+		return false
+	}
+	data, err := ioutil.ReadFile(cli.meta(Bucket, remotePath))
+	if err != nil {
+		cli.log.Warn("failed to get object meta", log.Error(err))
+		return false
+	}
+	var fm FileMeta
+	if err = json.Unmarshal(data, &fm); err != nil {
+		cli.log.Warn("failed to get object meta", log.Error(err))
+		return false
+	}
+	return fm.matches(fi.Size(), md5)
+}
+
+func (cli *FileHandler) RefreshSts() (*v1.STSResponse, error) {
+	return nil, nil
+}
+
+// object returns the file of object, the remote path can't escape from the bucket
+func (cli *FileHandler) object(Bucket, remotePath string) (string, error) {
+	p, err := objectPath(Bucket, remotePath)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return filepath.Join(cli.root, filepath.FromSlash(p)), nil
+}
+
+func (cli *FileHandler) meta(Bucket, remotePath string) string {
+	return filepath.Join(cli.root, fileMetaDir, Bucket, filepath.FromSlash(path.Clean("/"+remotePath))+".json")
+}
+
+// objectPath returns the slash separated path <bucket>/<remotePath> of object in the storages of directory,
+// such as FILE, SFTP and WEBDAV, the remote path can't escape from the bucket
+func objectPath(Bucket, remotePath string) (string, error) {
+	if Bucket == "" || strings.Contains(Bucket, "/") || strings.HasPrefix(Bucket, ".") {
+		return "", errors.Errorf("bucket (%s) invalid", Bucket)
+	}
+	p := path.Clean("/" + remotePath)
+	if p == "/" || strings.Contains(remotePath, "..") {
+		return "", errors.Errorf("failed to pass remotePath (%s) check: the remote path can't be empty or contains ..", remotePath)
+	}
+	return Bucket + p, nil
+}
+
+// writeFileAtomic writes the content to a temp file beside the file, then renames it to make the write atomic
+func writeFileAtomic(file string, r io.Reader) (int64, error) {
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	t := filepath.Join(filepath.Dir(file), "."+filepath.Base(file)+"."+uuid.Generate().String())
+	f, err := os.OpenFile(t, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer os.Remove(t)
+	n, err := io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	return n, errors.Trace(os.Rename(t, file))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileHandler(t *testing.T) {
+	_, err := NewFileHandler(ClientInfo{Name: "file", Kind: File})
+	assert.Error(t, err)
+
+	dir := t.TempDir()
+	c := ClientInfo{Name: "file", Kind: File}
+	c.Endpoint = "file://" + path.Join(dir, "root")
+	h, err := NewObjectStorageHandler(nil, c)
+	assert.NoError(t, err)
+
+	src := path.Join(dir, "a.txt")
+	assert.NoError(t, ioutil.WriteFile(src, []byte("hello"), 0644))
+	md5, err := utils.CalculateFileMD5(src)
+	assert.NoError(t, err)
+
+	// round 1: put object with meta
+	assert.False(t, h.FileExists("bucket", "x/a.txt", md5))
+	etag, err := h.PutObjectFromFile("bucket", "x/a.txt", src, map[string]string{"k": "v"})
+	assert.NoError(t, err)
+	assert.Equal(t, md5, etag)
+	assert.True(t, utils.FileExists(path.Join(dir, "root", "bucket", "x", "a.txt")))
+	assert.True(t, utils.FileExists(path.Join(dir, "root", fileMetaDir, "bucket", "x", "a.txt.json")))
+	assert.True(t, h.FileExists("bucket", "x/a.txt", md5))
+	assert.False(t, h.FileExists("bucket", "x/a.txt", "0123"))
+	files, err := ioutil.ReadDir(path.Join(dir, "root", "bucket", "x"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	// round 2: get object
+	dst := path.Join(dir, "b.txt")
+	assert.NoError(t, h.GetObjectToFile("bucket", "x/a.txt", dst))
+	data, err := ioutil.ReadFile(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	assert.Error(t, h.GetObjectToFile("bucket", "x/none.txt", dst))
+
+	// round 3: the remote path can't escape from bucket
+	_, err = h.PutObjectFromFile("bucket", "../a.txt", src, nil)
+	assert.Error(t, err)
+	_, err = h.PutObjectFromFile("../bucket", "a.txt", src, nil)
+	assert.Error(t, err)
+	_, err = h.PutObjectFromFile("bucket", "", src, nil)
+	assert.Error(t, err)
+
+	// round 4: the object changed outside is not existing
+	assert.NoError(t, ioutil.WriteFile(path.Join(dir, "root", "bucket", "x", "a.txt"), []byte("changed"), 0644))
+	assert.False(t, h.FileExists("bucket", "x/a.txt", md5))
+
+	// round 5: delete object and its meta
+	assert.NoError(t, h.DeleteObject("bucket", "x/a.txt"))
+	assert.NoError(t, h.DeleteObject("bucket", "x/a.txt"))
+	_, err = os.Stat(path.Join(dir, "root", "bucket", "x", "a.txt"))
+	assert.True(t, os.IsNotExist(err))
+	assert.False(t, utils.FileExists(path.Join(dir, "root", fileMetaDir, "bucket", "x", "a.txt.json")))
+}
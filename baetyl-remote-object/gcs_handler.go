@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dm "github.com/baetyl/baetyl-go/v2/context"
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	v1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+)
+
+const (
+	gcsEndpoint = "https://storage.googleapis.com"
+	gcsScope    = "https://www.googleapis.com/auth/devstorage.read_write"
+	// the chunk size of resumable upload must be a multiple of 256 KiB
+	gcsChunkUnit = 256 * 1024
+)
+
+// gcsCredentials the service account json key of google cloud
+type gcsCredentials struct {
+	Type        string `json:"type"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// gcsObject the object resource of json api
+type gcsObject struct {
+	Name     string            `json:"name"`
+	Size     string            `json:"size,omitempty"`
+	MD5Hash  string            `json:"md5Hash,omitempty"`
+	CRC32C   string            `json:"crc32c,omitempty"`
+	ETag     string            `json:"etag,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// GCSHandler stores objects in google cloud storage by json api, authorized by the service account json key
+// of credentials, or anonymous for the fake server of custom endpoint
+type GCSHandler struct {
+	rest       *restClient
+	endpoint   string
+	token      *gcsToken // nil if anonymous
+	cfg        ClientInfo
+	throttlers []*throttler
+	log        *log.Logger
+}
+
+// NewGCSHandler creates a new gcs handler, the hmac key of ak and sk is used by the xml api which is s3 compatible
+func NewGCSHandler(ctx dm.Context, cfg ClientInfo) (StorageHandler, error) {
+	if cfg.Credentials == "" && cfg.Ak != "" && cfg.Sk != "" {
+		if cfg.Endpoint == "" {
+			cfg.Endpoint = gcsEndpoint
+		}
+		return NewS3Client(ctx, cfg)
+	}
+	rest, err := newRESTClient(cfg)
+	if err != nil {
+		return nil, errors.Errorf("failed to create gcs client (%s): %s", cfg.Name, err.Error())
+	}
+	h := &GCSHandler{
+		rest:       rest,
+		endpoint:   strings.TrimSuffix(cfg.Endpoint, "/"),
+		cfg:        cfg,
+		throttlers: []*throttler{newThrottler(cfg.Throttle), globalThrottler},
+		log:        log.With(log.Any("storage", "gcs")),
+	}
+	if h.endpoint == "" {
+		h.endpoint = gcsEndpoint
+	}
+	if cfg.Credentials != "" {
+		token, err := newGCSToken(cfg.Credentials, h.rest)
+		if err != nil {
+			return nil, errors.Errorf("failed to create gcs client (%s): %s", cfg.Name, err.Error())
+		}
+		h.token = token
+	} else if cfg.Endpoint == "" {
+		return nil, errors.Errorf("failed to create gcs client (%s): credentials or hmac key is required", cfg.Name)
+	}
+	return h, nil
+}
+
+// PutObjectFromFile uploads file by resumable upload in chunks of part size, returns the etag
+func (cli *GCSHandler) PutObjectFromFile(Bucket, remotePath, filename string, meta map[string]string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	md5, crc, err := gcsHashes(f, fi.Size())
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	session, err := cli.startUpload(Bucket, remotePath, fi.Size(), &gcsObject{
+		Name:     remotePath,
+		MD5Hash:  md5,
+		CRC32C:   crc,
+		Metadata: meta,
+	})
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	obj, err := cli.uploadChunks(session, f, fi.Size())
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return obj.ETag, nil
+}
+
+// startUpload initiates a resumable upload, returns the session uri, the hashes are verified by gcs once completed
+func (cli *GCSHandler) startUpload(Bucket, remotePath string, size int64, obj *gcsObject) (string, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	u := cli.endpoint + "/upload/storage/v1/b/" + url.PathEscape(Bucket) + "/o?uploadType=resumable"
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+	header, err := cli.send(req)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	session := header.Get("Location")
+	if session == "" {
+		return "", errors.New("failed to initiate resumable upload: session uri not found")
+	}
+	return session, nil
+}
+
+// uploadChunks uploads the file to session in chunks, the upload is resumed from the offset persisted by gcs
+// if a chunk failed, and retried at most backoff max times
+func (cli *GCSHandler) uploadChunks(session string, f *os.File, size int64) (*gcsObject, error) {
+	chunk := (cli.cfg.MultiPart.PartSize + gcsChunkUnit - 1) / gcsChunkUnit * gcsChunkUnit
+	if chunk <= 0 {
+		chunk = gcsChunkUnit
+	}
+	var off int64
+	var retries int
+	for {
+		end := off + chunk
+		if end > size {
+			end = size
+		}
+		obj, next, err := cli.uploadChunk(session, f, off, end, size)
+		if err == nil && obj == nil && next <= off {
+			err = errors.Errorf("failed to upload chunk: no bytes persisted from offset (%d)", off)
+		}
+		if err == nil {
+			if obj != nil {
+				return obj, nil
+			}
+			off = next
+			continue
+		}
+		if retries >= cli.cfg.Backoff.Max {
+			return nil, errors.Trace(err)
+		}
+		retries++
+		cli.log.Warn("failed to upload chunk, resume the upload", log.Any("offset", off), log.Error(err))
+		time.Sleep(cli.cfg.Backoff.Base * time.Duration(retries))
+		// query the offset persisted
+		obj, next, err = cli.uploadChunk(session, nil, 0, 0, size)
+		if err != nil {
+			continue
+		}
+		if obj != nil {
+			return obj, nil
+		}
+		off = next
+	}
+}
+
+// uploadChunk uploads the bytes of file in [off, end), the status of upload is queried if the file is nil,
+// returns the object if completed, otherwise the offset of next chunk
+func (cli *GCSHandler) uploadChunk(session string, f *os.File, off, end, size int64) (*gcsObject, int64, error) {
+	var body io.Reader = http.NoBody
+	contentRange := "bytes */" + strconv.FormatInt(size, 10)
+	if f != nil && end > off {
+		body = ioutil.NopCloser(throttled(io.NewSectionReader(f, off, end-off), cli.throttlers...))
+		contentRange = "bytes " + strconv.FormatInt(off, 10) + "-" + strconv.FormatInt(end-1, 10) + "/" + strconv.FormatInt(size, 10)
+	}
+	req, err := http.NewRequest(http.MethodPut, session, body)
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	if f != nil {
+		req.ContentLength = end - off
+	}
+	req.Header.Set("Content-Range", contentRange)
+	if err = cli.authorize(req); err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	// 308 means the upload is incomplete
+	res, err := cli.rest.do(req, http.StatusPermanentRedirect)
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusPermanentRedirect {
+		io.Copy(ioutil.Discard, res.Body)
+		// the range persisted, such as bytes=0-524287, nothing persisted if absent
+		r := res.Header.Get("Range")
+		if r == "" {
+			return nil, 0, nil
+		}
+		last, err := strconv.ParseInt(r[strings.LastIndex(r, "-")+1:], 10, 64)
+		if err != nil {
+			return nil, 0, errors.Errorf("failed to parse range (%s) of resumable upload", r)
+		}
+		return nil, last + 1, nil
+	}
+	var obj gcsObject
+	err = json.NewDecoder(res.Body).Decode(&obj)
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	return &obj, 0, nil
+}
+
+// GetObjectToFile download file
+func (cli *GCSHandler) GetObjectToFile(Bucket, remotePath, filename string) error {
+	req, err := http.NewRequest(http.MethodGet, cli.object(Bucket, remotePath)+"?alt=media", nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = cli.authorize(req); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(cli.rest.download(req, filename))
+}
+
+// DeleteObject delete object
+func (cli *GCSHandler) DeleteObject(Bucket, remotePath string) error {
+	req, err := http.NewRequest(http.MethodDelete, cli.object(Bucket, remotePath), nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = cli.send(req)
+	if isNotFound(err) {
+		return nil
+	}
+	return errors.Trace(err)
+}
+
+// FileExists checks the md5 of object, the object composed without md5 is regarded as not existing
+func (cli *GCSHandler) FileExists(Bucket, remotePath, md5 string) bool {
+	req, err := http.NewRequest(http.MethodGet, cli.object(Bucket, remotePath), nil)
+	if err != nil {
+		return false
+	}
+	if err = cli.authorize(req); err != nil {
+		cli.log.Warn("failed to get object meta", log.Error(err))
+		return false
+	}
+	res, err := cli.rest.do(req)
+	if err != nil {
+		if !isNotFound(err) {
+			cli.log.Warn("failed to get object meta", log.Error(err))
+		}
+		return false
+	}
+	defer res.Body.Close()
+	var obj gcsObject
+	if err = json.NewDecoder(res.Body).Decode(&obj); err != nil {
+		cli.log.Warn("failed to get object meta", log.Error(err))
+		return false
+	}
+	sum, err := base64.StdEncoding.DecodeString(obj.MD5Hash)
+	if err != nil || len(sum) == 0 {
+		return false
+	}
+	return strings.EqualFold(hex.EncodeToString(sum), md5)
+}
+
+func (cli *GCSHandler) RefreshSts() (*v1.STSResponse, error) {
+	return nil, nil
+}
+
+func (cli *GCSHandler) object(Bucket, remotePath string) string {
+	return cli.endpoint + "/storage/v1/b/" + url.PathEscape(Bucket) + "/o/" + url.PathEscape(remotePath)
+}
+
+// send authorizes the request and sends it, returns the header of response
+func (cli *GCSHandler) send(req *http.Request) (http.Header, error) {
+	if err := cli.authorize(req); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return cli.rest.discard(req)
+}
+
+func (cli *GCSHandler) authorize(req *http.Request) error {
+	if cli.token == nil {
+		return nil
+	}
+	token, err := cli.token.get()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// gcsHashes returns the md5 and crc32c of file in base64
+func gcsHashes(f *os.File, size int64) (string, string, error) {
+	h := md5.New()
+	c := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	_, err := io.Copy(io.MultiWriter(h, c), io.NewSectionReader(f, 0, size))
+	if err != nil {
+		return "", "", errors.Trace(err)
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), base64.StdEncoding.EncodeToString(c.Sum(nil)), nil
+}
+
+// gcsToken the access token of service account, which is refreshed by the signed jwt before expired
+type gcsToken struct {
+	creds  gcsCredentials
+	key    *rsa.PrivateKey
+	rest   *restClient
+	token  string
+	expiry time.Time
+	lock   sync.Mutex
+}
+
+func newGCSToken(file string, rest *restClient) (*gcsToken, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var creds gcsCredentials
+	if err = json.Unmarshal(data, &creds); err != nil {
+		return nil, errors.Errorf("failed to parse credentials (%s): %s", file, err.Error())
+	}
+	if creds.Type != "service_account" || creds.ClientEmail == "" || creds.TokenURI == "" {
+		return nil, errors.Errorf("credentials (%s) invalid: the json key of service account is required", file)
+	}
+	block, _ := pem.Decode([]byte(creds.PrivateKey))
+	if block == nil {
+		return nil, errors.Errorf("credentials (%s) invalid: private key not found", file)
+	}
+	var key *rsa.PrivateKey
+	if k, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		key, _ = k.(*rsa.PrivateKey)
+	} else if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		key = k
+	}
+	if key == nil {
+		return nil, errors.Errorf("credentials (%s) invalid: the private key must be rsa", file)
+	}
+	return &gcsToken{creds: creds, key: key, rest: rest}, nil
+}
+
+// get returns the access token, which is exchanged by the jwt signed if expired
+func (t *gcsToken) get() (string, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	if t.token != "" && now.Add(time.Minute).Before(t.expiry) {
+		return t.token, nil
+	}
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   t.creds.ClientEmail,
+		"scope": gcsScope,
+		"aud":   t.creds.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, t.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + enc.EncodeToString(sig)},
+	}
+	req, err := http.NewRequest(http.MethodPost, t.creds.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := t.rest.do(req)
+	if err != nil {
+		return "", errors.Errorf("failed to get access token: %s", err.Error())
+	}
+	defer res.Body.Close()
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", errors.Errorf("failed to get access token: %s", err.Error())
+	}
+	t.token = token.AccessToken
+	t.expiry = now.Add(time.Duration(token.ExpiresIn) * time.Second)
+	return t.token, nil
+}
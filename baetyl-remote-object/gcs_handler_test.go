@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockGCS a minimal json api of gcs, the chunk of failure is rejected once
+type mockGCS struct {
+	t        *testing.T
+	key      *rsa.PublicKey
+	objects  map[string][]byte
+	metas    map[string]*gcsObject
+	sessions map[string]*gcsObject
+	received map[string][]byte
+	failure  int
+	chunks   int
+	lock     sync.Mutex
+}
+
+func (m *mockGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if r.URL.Path == "/token" {
+		parts := strings.Split(r.FormValue("assertion"), ".")
+		assert.Len(m.t, parts, 3)
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if rsa.VerifyPKCS1v15(m.key, crypto.SHA256, sum[:], sig) != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"access_token":"token","expires_in":3600}`))
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/upload/storage/v1/b/"):
+		var obj gcsObject
+		json.NewDecoder(r.Body).Decode(&obj)
+		id := fmt.Sprint(len(m.sessions))
+		m.sessions[id] = &obj
+		w.Header().Set("Location", "http://"+r.Host+"/session/"+id)
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/session/"):
+		id := strings.TrimPrefix(r.URL.Path, "/session/")
+		data, _ := ioutil.ReadAll(r.Body)
+		cr := r.Header.Get("Content-Range")
+		total, _ := strconv.Atoi(cr[strings.LastIndex(cr, "/")+1:])
+		if !strings.HasPrefix(cr, "bytes */") {
+			m.chunks++
+			if m.chunks == m.failure {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			m.received[id] = append(m.received[id], data...)
+		}
+		if len(m.received[id]) < total {
+			if len(m.received[id]) > 0 {
+				w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(m.received[id])-1))
+			}
+			w.WriteHeader(http.StatusPermanentRedirect)
+			return
+		}
+		obj := m.sessions[id]
+		sum := md5.Sum(m.received[id])
+		if obj.MD5Hash != base64.StdEncoding.EncodeToString(sum[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		obj.ETag = "etag-" + id
+		m.objects[obj.Name], m.metas[obj.Name] = m.received[id], obj
+		json.NewEncoder(w).Encode(obj)
+	default:
+		name, err := url.PathUnescape(r.URL.EscapedPath()[strings.LastIndex(r.URL.EscapedPath(), "/o/")+3:])
+		assert.NoError(m.t, err)
+		if _, ok := m.objects[name]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch {
+		case r.Method == http.MethodDelete:
+			delete(m.objects, name)
+			w.WriteHeader(http.StatusNoContent)
+		case r.URL.Query().Get("alt") == "media":
+			w.Write(m.objects[name])
+		default:
+			json.NewEncoder(w).Encode(m.metas[name])
+		}
+	}
+}
+
+func TestGCSHandler(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	m := &mockGCS{
+		t:        t,
+		key:      &key.PublicKey,
+		objects:  map[string][]byte{},
+		metas:    map[string]*gcsObject{},
+		sessions: map[string]*gcsObject{},
+		received: map[string][]byte{},
+		failure:  2,
+	}
+	s := httptest.NewServer(m)
+	defer s.Close()
+
+	dir := t.TempDir()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+	creds, err := json.Marshal(gcsCredentials{
+		Type:        "service_account",
+		ClientEmail: "sa@project.iam.gserviceaccount.com",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		TokenURI:    s.URL + "/token",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(path.Join(dir, "sa.json"), creds, 0644))
+
+	c := *cfg
+	c.Kind = GCS
+	c.Endpoint = s.URL
+	c.Ak, c.Sk = "", ""
+	c.Credentials = path.Join(dir, "sa.json")
+	c.MultiPart = MultiPart{PartSize: 1}
+	c.Backoff = Backoff{Max: 1}
+	h, err := NewObjectStorageHandler(nil, c)
+	assert.NoError(t, err)
+
+	// round 1: upload in 3 chunks of 256 KiB, the second chunk is resumed after failure
+	src := path.Join(dir, "a.bin")
+	data := []byte(strings.Repeat("0123456789abcdef", 40*1024))
+	assert.NoError(t, ioutil.WriteFile(src, data, 0644))
+	etag, err := h.PutObjectFromFile("bucket", "a/b.bin", src, map[string]string{"k": "v"})
+	assert.NoError(t, err)
+	assert.Equal(t, "etag-0", etag)
+	assert.Equal(t, 4, m.chunks)
+	assert.Equal(t, data, m.objects["a/b.bin"])
+	assert.Equal(t, "v", m.metas["a/b.bin"].Metadata["k"])
+	sum, err := utils.CalculateFileMD5(src)
+	assert.NoError(t, err)
+	assert.True(t, h.FileExists("bucket", "a/b.bin", sum))
+	assert.False(t, h.FileExists("bucket", "a/b.bin", "0123"))
+	assert.False(t, h.FileExists("bucket", "a/none.bin", sum))
+
+	// round 2: empty file
+	empty := path.Join(dir, "empty")
+	assert.NoError(t, ioutil.WriteFile(empty, nil, 0644))
+	_, err = h.PutObjectFromFile("bucket", "empty", empty, nil)
+	assert.NoError(t, err)
+	assert.Len(t, m.objects["empty"], 0)
+
+	// round 3: get and delete object
+	dst := path.Join(dir, "dst.bin")
+	assert.NoError(t, h.GetObjectToFile("bucket", "a/b.bin", dst))
+	got, err := ioutil.ReadFile(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+	assert.Error(t, h.GetObjectToFile("bucket", "a/none.bin", dst))
+	assert.NoError(t, h.DeleteObject("bucket", "a/b.bin"))
+	assert.NoError(t, h.DeleteObject("bucket", "a/b.bin"))
+	assert.False(t, h.FileExists("bucket", "a/b.bin", sum))
+
+	// round 4: the upload fails if the chunk keeps failing
+	m.chunks, m.failure = 0, 1
+	c.Backoff = Backoff{}
+	h, err = NewObjectStorageHandler(nil, c)
+	assert.NoError(t, err)
+	_, err = h.PutObjectFromFile("bucket", "a/b.bin", src, nil)
+	assert.Error(t, err)
+
+	// credentials or endpoint is required
+	c.Endpoint, c.Credentials = "", ""
+	_, err = NewObjectStorageHandler(nil, c)
+	assert.Error(t, err)
+	c.Credentials = src
+	_, err = NewObjectStorageHandler(nil, c)
+	assert.Error(t, err)
+}
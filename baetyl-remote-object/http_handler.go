@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	mpart "mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	v1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/baetyl/baetyl-go/v2/utils"
+)
+
+// httpVars the variables of url template of HTTP storage, such as {{.Endpoint}}/upload?name={{.Filename}}
+type httpVars struct {
+	Endpoint   string
+	Bucket     string
+	RemotePath string // the remote path escaped by segments
+	Filename   string // the base name of remote path escaped
+}
+
+// HTTPHandler uploads objects to the url of http server by PUT or POST in multipart/form-data,
+// the token is sent as bearer token, otherwise ak and sk as basic auth if set
+type HTTPHandler struct {
+	rest       *restClient
+	url        *template.Template
+	cfg        ClientInfo
+	throttlers []*throttler
+	log        *log.Logger
+}
+
+// NewHTTPHandler creates a new http handler
+func NewHTTPHandler(cfg ClientInfo) (StorageHandler, error) {
+	cfg.HTTP.Method = strings.ToUpper(cfg.HTTP.Method)
+	if cfg.HTTP.Method != http.MethodPut && cfg.HTTP.Method != http.MethodPost {
+		return nil, errors.Errorf("failed to create http client (%s): method (%s) not supported", cfg.Name, cfg.HTTP.Method)
+	}
+	if cfg.HTTP.Method == http.MethodPost && cfg.HTTP.Field == "" {
+		return nil, errors.Errorf("failed to create http client (%s): field is required if method is POST", cfg.Name)
+	}
+	tpl, err := template.New("url").Option("missingkey=error").Parse(cfg.HTTP.URL)
+	if err != nil || cfg.HTTP.URL == "" {
+		return nil, errors.Errorf("failed to create http client (%s): url template (%s) invalid", cfg.Name, cfg.HTTP.URL)
+	}
+	rest, err := newRESTClient(cfg)
+	if err != nil {
+		return nil, errors.Errorf("failed to create http client (%s): %s", cfg.Name, err.Error())
+	}
+	return &HTTPHandler{
+		rest:       rest,
+		url:        tpl,
+		cfg:        cfg,
+		throttlers: []*throttler{newThrottler(cfg.Throttle), globalThrottler},
+		log:        log.With(log.Any("storage", "http")),
+	}, nil
+}
+
+// PutObjectFromFile uploads file, the meta is sent as headers of X-Meta-<key> if PUT, or form fields if POST,
+// returns the etag of response, or the md5 in hex if not returned
+func (cli *HTTPHandler) PutObjectFromFile(Bucket, remotePath, filename string, meta map[string]string) (string, error) {
+	md5, err := utils.CalculateFileMD5(filename)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	sum, err := hex.DecodeString(md5)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	var req *http.Request
+	if cli.cfg.HTTP.Method == http.MethodPut {
+		req, err = cli.request(http.MethodPut, Bucket, remotePath, ioutil.NopCloser(throttled(f, cli.throttlers...)))
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		req.ContentLength = fi.Size()
+		req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum))
+		for k, v := range meta {
+			req.Header.Set("X-Meta-"+k, v)
+		}
+	} else {
+		pr, pw := io.Pipe()
+		mw := mpart.NewWriter(pw)
+		go func() {
+			pw.CloseWithError(cli.writeForm(mw, f, path.Base(remotePath), meta))
+		}()
+		defer pr.Close()
+		req, err = cli.request(http.MethodPost, Bucket, remotePath, pr)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+	}
+	header, err := cli.send(req, true)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if etag := strings.Trim(header.Get("ETag"), "\""); etag != "" {
+		return etag, nil
+	}
+	return md5, nil
+}
+
+// GetObjectToFile downloads file by GET
+func (cli *HTTPHandler) GetObjectToFile(Bucket, remotePath, filename string) error {
+	req, err := cli.request(http.MethodGet, Bucket, remotePath, nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(cli.rest.download(req, filename))
+}
+
+// DeleteObject deletes object by DELETE
+func (cli *HTTPHandler) DeleteObject(Bucket, remotePath string) error {
+	req, err := cli.request(http.MethodDelete, Bucket, remotePath, nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = cli.send(req, false)
+	if isNotFound(err) {
+		return nil
+	}
+	return errors.Trace(err)
+}
+
+// FileExists checks object by HEAD if enabled, the Content-MD5 or etag is compared if returned,
+// otherwise the object is regarded as not the file since nothing can be verified
+func (cli *HTTPHandler) FileExists(Bucket, remotePath, md5 string) bool {
+	return cli.FileExistsWithSize(Bucket, remotePath, md5, -1)
+}
+
+// FileExistsWithSize checks object by HEAD if enabled, the Content-Length is compared with the size if not negative,
+// then the Content-MD5 or etag is compared if returned, otherwise the object is regarded as the file only if its size matched
+func (cli *HTTPHandler) FileExistsWithSize(Bucket, remotePath, md5 string, size int64) bool {
+	if !cli.cfg.HTTP.Head {
+		return false
+	}
+	req, err := cli.request(http.MethodHead, Bucket, remotePath, nil)
+	if err != nil {
+		return false
+	}
+	header, err := cli.send(req, false)
+	if err != nil {
+		if !isNotFound(err) {
+			cli.log.Warn("failed to get object meta", log.Error(err))
+		}
+		return false
+	}
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	sized := err == nil && size >= 0
+	if sized && length != size {
+		return false
+	}
+	if v := header.Get("Content-MD5"); v != "" {
+		sum, err := base64.StdEncoding.DecodeString(v)
+		return err == nil && strings.EqualFold(hex.EncodeToString(sum), md5)
+	}
+	if etag := strings.Trim(header.Get("ETag"), "\""); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return strings.EqualFold(etag, md5)
+	}
+	return sized
+}
+
+func (cli *HTTPHandler) RefreshSts() (*v1.STSResponse, error) {
+	return nil, nil
+}
+
+// writeForm writes the meta as fields, then the file as the configured field
+func (cli *HTTPHandler) writeForm(mw *mpart.Writer, f *os.File, name string, meta map[string]string) error {
+	for k, v := range meta {
+		if err := mw.WriteField(k, v); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	w, err := mw.CreateFormFile(cli.cfg.HTTP.Field, name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = io.Copy(w, throttled(f, cli.throttlers...)); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(mw.Close())
+}
+
+// request creates the request of the url rendered with the headers and auth configured
+func (cli *HTTPHandler) request(method, Bucket, remotePath string, body io.Reader) (*http.Request, error) {
+	segments := strings.Split(strings.TrimPrefix(remotePath, "/"), "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	var buf bytes.Buffer
+	err := cli.url.Execute(&buf, &httpVars{
+		Endpoint:   strings.TrimSuffix(cli.cfg.Endpoint, "/"),
+		Bucket:     Bucket,
+		RemotePath: strings.Join(segments, "/"),
+		Filename:   segments[len(segments)-1],
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if body == nil {
+		body = http.NoBody
+	}
+	req, err := http.NewRequest(method, buf.String(), body)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for k, v := range cli.cfg.HTTP.Headers {
+		req.Header.Set(k, v)
+	}
+	if cli.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cli.cfg.Token)
+	} else if cli.cfg.Ak != "" {
+		req.SetBasicAuth(cli.cfg.Ak, cli.cfg.Sk)
+	}
+	return req, nil
+}
+
+// send sends the request and discards the body of response, the status of upload must be one of configured if set
+func (cli *HTTPHandler) send(req *http.Request, upload bool) (http.Header, error) {
+	var status []int
+	if upload {
+		status = cli.cfg.HTTP.Status
+	}
+	res, err := cli.rest.do(req, status...)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if len(status) == 0 {
+		return res.Header, nil
+	}
+	for _, s := range status {
+		if res.StatusCode == s {
+			return res.Header, nil
+		}
+	}
+	u := *req.URL
+	u.RawQuery = "" // the query may contain token
+	return nil, errors.Trace(&restError{Method: req.Method, URL: u.String(), Status: res.StatusCode, Message: res.Status})
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockHTTP a minimal http server storing the files uploaded by PUT or POST
+type mockHTTP struct {
+	objects map[string][]byte
+	metas   map[string]string
+	auths   []string
+	status  int
+	plain   bool   // no Content-MD5 returned
+	posted  string // the Content-MD5 of last post
+	lock    sync.Mutex
+}
+
+func (m *mockHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.auths = append(m.auths, r.Header.Get("Authorization"))
+	name := r.URL.Path
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := ioutil.ReadAll(r.Body)
+		m.objects[name], m.metas[name] = data, r.Header.Get("X-Meta-K")
+	case http.MethodPost:
+		f, _, err := r.FormFile("upload")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data, _ := ioutil.ReadAll(f)
+		m.objects[name], m.metas[name] = data, r.FormValue("k")
+		m.posted = r.Header.Get("Content-MD5")
+	case http.MethodGet, http.MethodHead:
+		data, ok := m.objects[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !m.plain {
+			sum := md5.Sum(data)
+			w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+		}
+		w.Write(data)
+		return
+	case http.MethodDelete:
+		if _, ok := m.objects[name]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(m.objects, name)
+	}
+	if m.status != 0 {
+		w.WriteHeader(m.status)
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	m := &mockHTTP{objects: map[string][]byte{}, metas: map[string]string{}}
+	s := httptest.NewServer(m)
+	defer s.Close()
+
+	c := *cfg
+	c.Kind = HTTP
+	c.Endpoint = s.URL
+	c.Token = "token"
+	c.HTTP = HTTPConfig{
+		URL:     "{{.Endpoint}}/files/{{.Bucket}}/{{.RemotePath}}",
+		Method:  "put",
+		Headers: map[string]string{"X-Custom": "v"},
+		Head:    true,
+	}
+	h, err := NewObjectStorageHandler(nil, c)
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	small := path.Join(dir, "small.txt")
+	assert.NoError(t, ioutil.WriteFile(small, []byte("abc"), 0644))
+	sum, err := utils.CalculateFileMD5(small)
+	assert.NoError(t, err)
+
+	// round 1: put object with bearer token
+	etag, err := h.PutObjectFromFile("bucket", "a/b c.txt", small, map[string]string{"k": "v"})
+	assert.NoError(t, err)
+	assert.Equal(t, sum, etag)
+	assert.Equal(t, "abc", string(m.objects["/files/bucket/a/b c.txt"]))
+	assert.Equal(t, "v", m.metas["/files/bucket/a/b c.txt"])
+	assert.Equal(t, "Bearer token", m.auths[0])
+	assert.True(t, h.FileExists("bucket", "a/b c.txt", sum))
+	assert.False(t, h.FileExists("bucket", "a/b c.txt", "0123"))
+	assert.False(t, h.FileExists("bucket", "a/none.txt", sum))
+	sc := h.(SizeChecker)
+	assert.True(t, sc.FileExistsWithSize("bucket", "a/b c.txt", sum, 3))
+	assert.False(t, sc.FileExistsWithSize("bucket", "a/b c.txt", sum, 4))
+
+	// the object is the file only if its size matched without md5 returned
+	m.plain = true
+	assert.True(t, sc.FileExistsWithSize("bucket", "a/b c.txt", sum, 3))
+	assert.False(t, sc.FileExistsWithSize("bucket", "a/b c.txt", sum, 4))
+	assert.False(t, h.FileExists("bucket", "a/b c.txt", sum))
+	m.plain = false
+
+	// round 2: get and delete object
+	dst := path.Join(dir, "dst.txt")
+	assert.NoError(t, h.GetObjectToFile("bucket", "a/b c.txt", dst))
+	data, err := ioutil.ReadFile(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", string(data))
+	assert.NoError(t, h.DeleteObject("bucket", "a/b c.txt"))
+	assert.NoError(t, h.DeleteObject("bucket", "a/b c.txt"))
+
+	// round 3: post object in form with basic auth and the status expected
+	c.Token = ""
+	c.Ak, c.Sk = "user", "pass"
+	c.HTTP = HTTPConfig{
+		URL:    "{{.Endpoint}}/upload/{{.Filename}}",
+		Method: "POST",
+		Field:  "upload",
+		Status: []int{http.StatusCreated},
+	}
+	h, err = NewObjectStorageHandler(nil, c)
+	assert.NoError(t, err)
+	m.status = http.StatusCreated
+	_, err = h.PutObjectFromFile("bucket", "a/small.txt", small, map[string]string{"k": "v"})
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", string(m.objects["/upload/small.txt"]))
+	assert.Equal(t, "v", m.metas["/upload/small.txt"])
+	assert.Equal(t, "Basic dXNlcjpwYXNz", m.auths[len(m.auths)-1])
+	// the md5 of form differs from the file, so it is not sent
+	assert.Empty(t, m.posted)
+	// the file is always uploaded without head
+	assert.False(t, h.FileExists("bucket", "a/small.txt", sum))
+	m.status = http.StatusOK
+	_, err = h.PutObjectFromFile("bucket", "a/small.txt", small, nil)
+	assert.Error(t, err)
+
+	// round 4: config invalid
+	c.HTTP.Method = "PATCH"
+	_, err = NewObjectStorageHandler(nil, c)
+	assert.Error(t, err)
+	c.HTTP.Method = "PUT"
+	c.HTTP.URL = "{{.Endpoint"
+	_, err = NewObjectStorageHandler(nil, c)
+	assert.Error(t, err)
+}
+
+func TestHTTPHandlerTLS(t *testing.T) {
+	dir := t.TempDir()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	certFile, keyFile := path.Join(dir, "client.pem"), path.Join(dir, "client.key")
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	var clients []string
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clients = append(clients, fmt.Sprint(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	s.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	s.StartTLS()
+	defer s.Close()
+
+	c := *cfg
+	c.Kind = HTTP
+	c.Endpoint = s.URL
+	c.HTTP = HTTPConfig{URL: "{{.Endpoint}}/{{.RemotePath}}", Method: "PUT"}
+	c.TLS = utils.Certificate{Cert: certFile, Key: keyFile, InsecureSkipVerify: true}
+	h, err := NewObjectStorageHandler(nil, c)
+	assert.NoError(t, err)
+	small := path.Join(dir, "small.txt")
+	assert.NoError(t, ioutil.WriteFile(small, []byte("abc"), 0644))
+	_, err = h.PutObjectFromFile("bucket", "small.txt", small, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"client"}, clients)
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/docker/distribution/uuid"
+)
+
+// TaskState the state of task in journal
+type TaskState string
+
+// The state of task in journal
+const (
+	TaskQueued    TaskState = "queued"
+	TaskDeferred  TaskState = "deferred"
+	TaskRunning   TaskState = "running"
+	TaskSucceeded TaskState = "succeeded"
+	TaskFailed    TaskState = "failed"
+)
+
+// JournalEntry a task persisted in journal
+type JournalEntry struct {
+	ID      string    `yaml:"id" json:"id"`
+	Rule    string    `yaml:"rule" json:"rule"`
+	Topic   string    `yaml:"topic" json:"topic"`
+	Event   string    `yaml:"event" json:"event"` // the event in json
+	State   TaskState `yaml:"state" json:"state"`
+	Error   string    `yaml:"error,omitempty" json:"error,omitempty"`
+	Message uint64    `yaml:"message,omitempty" json:"message,omitempty"` // the id of mqtt message delivered with qos 1
+	Created time.Time `yaml:"created" json:"created"`
+	Updated time.Time `yaml:"updated" json:"updated"`
+}
+
+func (e *JournalEntry) pending() bool {
+	return e.State == TaskQueued || e.State == TaskDeferred || e.State == TaskRunning
+}
+
+// key identifies the task of the message delivered with qos 1, so the redelivery is not journaled again
+func (e *JournalEntry) key() string {
+	if e.Message == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s\x00%s\x00%d\x00%s", e.Rule, e.Topic, e.Message, e.Event)
+}
+
+// errTaskDuplicated the message redelivered is pending in journal already
+var errTaskDuplicated = errors.New("task duplicated in journal")
+
+// the records of log are compacted when exceeding the times of entries
+const journalCompaction = 2
+
+// journal persists the tasks of client in an append-only log, each transition appends the entry as a line of json,
+// the log is compacted when the records are much more than the entries, so the pending tasks can be resumed after restart
+type journal struct {
+	cfg     Journal
+	file    string
+	entries []*JournalEntry
+	index   map[string]*JournalEntry
+	keys    map[string]*JournalEntry
+	records int
+	linked  bool // whether the log file is synced in its dir
+	lock    sync.Mutex
+}
+
+func newJournal(cfg Journal, name string) (*journal, error) {
+	j := &journal{
+		cfg:   cfg,
+		file:  path.Join(cfg.Path, name+".log"),
+		index: make(map[string]*JournalEntry),
+		keys:  make(map[string]*JournalEntry),
+	}
+	if !utils.FileExists(j.file) {
+		return j, nil
+	}
+	f, err := os.Open(j.file)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 16*1024*1024)
+	for s.Scan() {
+		var e JournalEntry
+		// the last record may be torn by crash, it is ignored
+		if err = json.Unmarshal(s.Bytes(), &e); err != nil || e.ID == "" {
+			continue
+		}
+		if old, ok := j.index[e.ID]; ok {
+			*old = e
+			continue
+		}
+		j.entries = append(j.entries, &e)
+		j.index[e.ID] = &e
+	}
+	if err = s.Err(); err != nil {
+		return nil, errors.Errorf("failed to load journal (%s): %s", j.file, err.Error())
+	}
+	for _, e := range j.entries {
+		// the task running is interrupted by restart
+		if e.State == TaskRunning {
+			e.State = TaskQueued
+		}
+		if e.pending() && e.key() != "" {
+			j.keys[e.key()] = e
+		}
+	}
+	return j, errors.Trace(j.compact())
+}
+
+// add persists the event message of rule as a queued task, and sets the journal id of message.
+// The message delivered with qos 1 is deduplicated by its id and content, errTaskDuplicated is returned
+// if the message redelivered is pending
+func (j *journal) add(rule string, msg *EventMessage) error {
+	data, err := json.Marshal(msg.Event)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	now := time.Now()
+	e := &JournalEntry{
+		ID:      uuid.Generate().String(),
+		Rule:    rule,
+		Topic:   msg.Topic,
+		Event:   string(data),
+		State:   TaskQueued,
+		Created: now,
+		Updated: now,
+	}
+	if msg.QOS == 1 {
+		e.Message = msg.ID
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	k := e.key()
+	if old, ok := j.keys[k]; ok && k != "" {
+		msg.JournalID = old.ID
+		return errTaskDuplicated
+	}
+	err = j.append(e)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	j.entries = append(j.entries, e)
+	j.index[e.ID] = e
+	if k != "" {
+		j.keys[k] = e
+	}
+	msg.JournalID = e.ID
+	return nil
+}
+
+// update transits the state of task
+func (j *journal) update(id string, state TaskState, err error) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	e, ok := j.index[id]
+	if !ok {
+		return errors.Errorf("task (%s) not found in journal", id)
+	}
+	n := *e
+	n.State = state
+	n.Error = ""
+	if err != nil {
+		n.Error = err.Error()
+	}
+	n.Updated = time.Now()
+	if err = j.append(&n); err != nil {
+		return errors.Trace(err)
+	}
+	*e = n
+	if k := e.key(); !e.pending() && j.keys[k] == e {
+		delete(j.keys, k)
+	}
+	if j.records > journalCompaction*len(j.entries) {
+		return errors.Trace(j.compact())
+	}
+	return nil
+}
+
+// pending returns the messages of tasks queued, deferred or interrupted of rule
+func (j *journal) pending(rule string) ([]*EventMessage, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	var msgs []*EventMessage
+	for _, e := range j.entries {
+		if e.Rule != rule || !e.pending() {
+			continue
+		}
+		event, err := NewEvent([]byte(e.Event))
+		if err != nil {
+			return nil, errors.Errorf("failed to resume task (%s): %s", e.ID, err.Error())
+		}
+		msgs = append(msgs, &EventMessage{
+			Topic:     e.Topic,
+			Event:     event,
+			JournalID: e.ID,
+		})
+	}
+	return msgs, nil
+}
+
+// prune removes the pending tasks of the rules not in the given rules, returns the number of tasks removed
+func (j *journal) prune(rules []string) (int, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	exists := make(map[string]bool)
+	for _, r := range rules {
+		exists[r] = true
+	}
+	entries := j.entries[:0]
+	for _, e := range j.entries {
+		if e.pending() && !exists[e.Rule] {
+			delete(j.index, e.ID)
+			delete(j.keys, e.key())
+			continue
+		}
+		entries = append(entries, e)
+	}
+	n := len(j.entries) - len(entries)
+	j.entries = entries
+	if n == 0 {
+		return 0, nil
+	}
+	return n, errors.Trace(j.compact())
+}
+
+// append appends the entry as a record to the log and syncs it, so the task is persisted once returned
+func (j *journal) append(e *JournalEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	f, err := os.OpenFile(j.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if os.IsNotExist(err) {
+		// the dir is made once the first task journaled
+		if err = os.MkdirAll(j.cfg.Path, 0755); err != nil {
+			return errors.Errorf("failed to make dir (%s): %s", j.cfg.Path, err.Error())
+		}
+		f, err = os.OpenFile(j.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	}
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = f.Write(append(data, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// the log file created is synced in dir as well
+	if !j.linked {
+		if err = syncDir(j.cfg.Path); err != nil {
+			return errors.Trace(err)
+		}
+		j.linked = true
+	}
+	j.records++
+	return nil
+}
+
+// compact removes the finished tasks exceeding the retention and rewrites the log with one record per entry atomically
+func (j *journal) compact() error {
+	deadline := time.Now().Add(-j.cfg.Retention)
+	entries := j.entries[:0]
+	for _, e := range j.entries {
+		if !e.pending() && e.Updated.Before(deadline) {
+			delete(j.index, e.ID)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	j.entries = entries
+	var buf bytes.Buffer
+	for _, e := range j.entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	t := j.file + ".tmp"
+	f, err := os.OpenFile(t, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = f.Write(buf.Bytes())
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = os.Rename(t, j.file); err != nil {
+		return errors.Trace(err)
+	}
+	if err = syncDir(j.cfg.Path); err != nil {
+		return errors.Trace(err)
+	}
+	j.linked = true
+	j.records = len(j.entries)
+	return nil
+}
+
+// syncDir syncs the entries of dir, so that the file created or renamed in it survives a power loss
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return errors.Trace(err)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/panjf2000/ants"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournal(t *testing.T) {
+	cfg := Journal{Enable: true, Path: path.Join(t.TempDir(), "journal"), Retention: time.Hour}
+	j, err := newJournal(cfg, "cli")
+	assert.NoError(t, err)
+
+	msgs := make([]*EventMessage, 3)
+	for i := range msgs {
+		msgs[i] = &EventMessage{
+			Topic: "t",
+			Event: &Event{
+				Type:    Upload,
+				Content: &UploadEvent{RemotePath: fmt.Sprintf("r%d", i), LocalPath: fmt.Sprintf("l%d", i)},
+			},
+		}
+		assert.NoError(t, j.add("rule", msgs[i]))
+		assert.NotEmpty(t, msgs[i].JournalID)
+	}
+	assert.NoError(t, j.update(msgs[0].JournalID, TaskSucceeded, nil))
+	assert.NoError(t, j.update(msgs[1].JournalID, TaskRunning, nil))
+	assert.Error(t, j.update("none", TaskFailed, nil))
+
+	// reload, the task running is queued again
+	j, err = newJournal(cfg, "cli")
+	assert.NoError(t, err)
+	assert.Len(t, j.entries, 3)
+	assert.Equal(t, TaskQueued, j.index[msgs[1].JournalID].State)
+	pending, err := j.pending("rule")
+	assert.NoError(t, err)
+	assert.Len(t, pending, 2)
+	assert.Equal(t, msgs[1].JournalID, pending[0].JournalID)
+	assert.Equal(t, "t", pending[0].Topic)
+	u, ok := pending[0].Event.Content.(*UploadEvent)
+	assert.True(t, ok)
+	assert.Equal(t, "r1", u.RemotePath)
+	assert.Equal(t, "l1", u.LocalPath)
+	pending, err = j.pending("other")
+	assert.NoError(t, err)
+	assert.Len(t, pending, 0)
+
+	// the finished task exceeding retention is removed
+	assert.NoError(t, j.update(msgs[2].JournalID, TaskFailed, fmt.Errorf("failed")))
+	assert.Equal(t, "failed", j.index[msgs[2].JournalID].Error)
+	j.cfg.Retention = 0
+	assert.NoError(t, j.compact())
+	assert.Len(t, j.entries, 1)
+	assert.Equal(t, msgs[1].JournalID, j.entries[0].ID)
+}
+
+func TestJournalLog(t *testing.T) {
+	cfg := Journal{Enable: true, Path: path.Join(t.TempDir(), "journal"), Retention: time.Hour}
+	j, err := newJournal(cfg, "cli")
+	assert.NoError(t, err)
+
+	msgs := make([]*EventMessage, 10)
+	for i := range msgs {
+		msgs[i] = &EventMessage{
+			Topic: "t",
+			Event: &Event{Type: Upload, Content: &UploadEvent{RemotePath: fmt.Sprintf("r%d", i)}},
+		}
+		assert.NoError(t, j.add("rule", msgs[i]))
+	}
+	// each transition appends a record, the log is compacted when the records exceed twice the entries
+	for _, msg := range msgs {
+		assert.NoError(t, j.update(msg.JournalID, TaskRunning, nil))
+	}
+	assert.Equal(t, 20, j.records)
+	assert.NoError(t, j.update(msgs[0].JournalID, TaskSucceeded, nil))
+	assert.Equal(t, 10, j.records)
+	data, err := ioutil.ReadFile(j.file)
+	assert.NoError(t, err)
+	assert.Len(t, strings.Split(strings.TrimSpace(string(data)), "\n"), 10)
+
+	// the record torn by crash is ignored
+	f, err := os.OpenFile(j.file, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = f.WriteString(`{"id":"` + msgs[1].JournalID + `","sta`)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	j, err = newJournal(cfg, "cli")
+	assert.NoError(t, err)
+	assert.Len(t, j.entries, 10)
+	assert.Equal(t, TaskSucceeded, j.index[msgs[0].JournalID].State)
+	assert.Equal(t, TaskQueued, j.index[msgs[1].JournalID].State)
+}
+
+func TestJournalDuplicated(t *testing.T) {
+	cfg := Journal{Enable: true, Path: path.Join(t.TempDir(), "journal"), Retention: time.Hour}
+	j, err := newJournal(cfg, "cli")
+	assert.NoError(t, err)
+
+	msg := func(id uint64, qos uint32, remote string) *EventMessage {
+		return &EventMessage{
+			ID:    id,
+			QOS:   qos,
+			Topic: "t",
+			Event: &Event{Type: Upload, Content: &UploadEvent{RemotePath: remote}},
+		}
+	}
+	first := msg(1, 1, "r")
+	assert.NoError(t, j.add("rule", first))
+
+	// the redelivery is rejected while the task is pending, even after restart
+	dup := msg(1, 1, "r")
+	assert.Equal(t, errTaskDuplicated, j.add("rule", dup))
+	assert.Equal(t, first.JournalID, dup.JournalID)
+	j, err = newJournal(cfg, "cli")
+	assert.NoError(t, err)
+	assert.Equal(t, errTaskDuplicated, j.add("rule", msg(1, 1, "r")))
+
+	// the message reusing the id with another content, or delivered with qos 0, is a new task
+	assert.NoError(t, j.add("rule", msg(1, 1, "other")))
+	assert.NoError(t, j.add("rule", msg(1, 0, "r")))
+	assert.NoError(t, j.add("rule", msg(1, 0, "r")))
+	assert.NoError(t, j.add("other", msg(1, 1, "r")))
+
+	// the message is journaled again once the task finished
+	assert.NoError(t, j.update(first.JournalID, TaskSucceeded, nil))
+	assert.NoError(t, j.add("rule", msg(1, 1, "r")))
+}
+
+func TestJournalPrune(t *testing.T) {
+	cfg := Journal{Enable: true, Path: path.Join(t.TempDir(), "journal"), Retention: time.Hour}
+	j, err := newJournal(cfg, "cli")
+	assert.NoError(t, err)
+	var ids []string
+	for _, rule := range []string{"a", "b", "b"} {
+		msg := &EventMessage{Topic: "t", Event: &Event{Type: Upload, Content: &UploadEvent{RemotePath: "r"}}}
+		assert.NoError(t, j.add(rule, msg))
+		ids = append(ids, msg.JournalID)
+	}
+	assert.NoError(t, j.update(ids[1], TaskSucceeded, nil))
+
+	// the pending tasks of removed rule are pruned, the finished ones are kept until retention
+	n, err := j.prune([]string{"a"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	j, err = newJournal(cfg, "cli")
+	assert.NoError(t, err)
+	assert.Len(t, j.entries, 2)
+	assert.Equal(t, ids[0], j.entries[0].ID)
+	assert.Equal(t, ids[1], j.entries[1].ID)
+	pending, err := j.pending("b")
+	assert.NoError(t, err)
+	assert.Len(t, pending, 0)
+}
+
+func TestClientResume(t *testing.T) {
+	cli, h := newMockClient(t)
+	cli.cfg.Journal = Journal{Enable: true, Path: path.Join(h.dir, "journal"), Retention: time.Hour}
+	j, err := newJournal(cli.cfg.Journal, "cli")
+	assert.NoError(t, err)
+	cli.journal = j
+	cli.pool, err = ants.NewPoolWithFunc(cli.cfg.Pool.Worker, cli.call)
+	assert.NoError(t, err)
+	defer cli.pool.Release()
+	assert.NoError(t, os.MkdirAll(cli.pwd, 0755))
+	assert.NoError(t, copyFile("example/etc/baetyl/service-bos.yml", path.Join(cli.pwd, "service.yml")))
+
+	msg := &EventMessage{
+		Event: &Event{
+			Type:    Upload,
+			Content: &UploadEvent{RemotePath: "a/service.yml", LocalPath: "service.yml"},
+		},
+	}
+	journaled, err := cli.Journal("rule", msg)
+	assert.NoError(t, err)
+	assert.True(t, journaled)
+
+	results := make(chan *Result, 1)
+	assert.NoError(t, cli.Resume("rule", func(msg *EventMessage, res *Result, err error) {
+		results <- res
+	}))
+	select {
+	case res := <-results:
+		assert.Equal(t, StatusSucceeded, res.Status, res.Error)
+	case <-time.After(3 * time.Second):
+		t.Fatal("task not resumed")
+	}
+	assert.Equal(t, TaskSucceeded, j.index[msg.JournalID].State)
+	assert.Equal(t, 1, h.puts)
+
+	// no task to resume
+	pending, err := j.pending("rule")
+	assert.NoError(t, err)
+	assert.Len(t, pending, 0)
+}
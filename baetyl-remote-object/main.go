@@ -21,6 +21,8 @@ func main() {
 			log.L().Warn("failed to init default ipc", log.Error(err))
 		}
 
+		globalThrottler = newThrottler(cfg.Throttle)
+
 		// clients
 		clients := make(map[string]*Client)
 		defer func() {
@@ -36,6 +38,22 @@ func main() {
 			clients[c.Name] = client
 		}
 
+		// the pending tasks of rules removed or moved to another client are pruned before resumed,
+		// the tasks of rule are journaled by its first client
+		journaled := make(map[string][]string)
+		for _, r := range cfg.Rules {
+			name := r.Target.Client
+			if len(r.Target.Clients) > 0 {
+				name = r.Target.Clients[0]
+			}
+			journaled[name] = append(journaled[name], r.Name)
+		}
+		for name, c := range clients {
+			if err = c.Prune(journaled[name]); err != nil {
+				return err
+			}
+		}
+
 		// rulers
 		rulers := make([]*Ruler, 0)
 		defer func() {
@@ -72,10 +90,15 @@ func AddDefaultCfg(cfg *Config) error {
 		Name: IpcRule,
 		Source: struct {
 			QOS   uint32 `yaml:"qos" json:"qos" validate:"min=0, max=1"`
-			Topic string `yaml:"topic" json:"topic" validate:"nonzero"`
+			Topic string `yaml:"topic" json:"topic"`
+			Watch *Watch `yaml:"watch" json:"watch"`
 		}{QOS: 0, Topic: BaetylIpcTopic},
 		Target: struct {
-			Client string `yaml:"client" json:"client" default:"baetyl-sts"`
+			Client       string   `yaml:"client" json:"client" default:"baetyl-sts"`
+			Clients      []string `yaml:"clients" json:"clients"`
+			Policy       string   `yaml:"policy" json:"policy" default:"all"`
+			PathTemplate string   `yaml:"pathTemplate" json:"pathTemplate"`
+			Routes       []Route  `yaml:"routes" json:"routes"`
 		}{Client: MinioStsCli},
 	})
 	return nil
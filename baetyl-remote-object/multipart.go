@@ -0,0 +1,290 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/baetyl/baetyl-go/v2/utils"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Part a completed part of multipart upload
+type Part struct {
+	Number int    `yaml:"number" json:"number"`
+	ETag   string `yaml:"etag" json:"etag"`
+}
+
+// MultipartUploader the multipart upload operations of object storage
+type MultipartUploader interface {
+	InitMultipartUpload(Bucket, remotePath string, meta map[string]string) (string, error)
+	UploadPart(Bucket, remotePath, uploadID string, number int, f *os.File, off, size int64) (string, error)
+	CompleteMultipartUpload(Bucket, remotePath, uploadID string, parts []Part, meta map[string]string) (string, error)
+	AbortMultipartUpload(Bucket, remotePath, uploadID string) error
+}
+
+// checkpoint records the upload id and completed parts of a multipart upload, the upload is resumed
+// if the content uploaded is not changed, even if it is staged in another temp file
+type checkpoint struct {
+	Bucket     string    `yaml:"bucket" json:"bucket"`
+	RemotePath string    `yaml:"remotePath" json:"remotePath"`
+	File       string    `yaml:"file" json:"file"` // the file uploaded by the last attempt
+	Size       int64     `yaml:"size" json:"size"`
+	MD5        string    `yaml:"md5" json:"md5"`
+	PartSize   int64     `yaml:"partSize" json:"partSize"`
+	UploadID   string    `yaml:"uploadId" json:"uploadId"`
+	Parts      []Part    `yaml:"parts" json:"parts"`
+	Updated    time.Time `yaml:"updated" json:"updated"`
+}
+
+func (c *checkpoint) matches(o *checkpoint) bool {
+	return c.Bucket == o.Bucket && c.RemotePath == o.RemotePath && c.Size == o.Size && c.MD5 == o.MD5 &&
+		c.PartSize == o.PartSize
+}
+
+// resumer uploads files in parts and saves checkpoints, so that the upload interrupted by error or restart
+// is resumed from the last completed part
+type resumer struct {
+	cfg      MultiPart
+	uploader MultipartUploader
+	log      *log.Logger
+	lock     sync.Mutex
+}
+
+func newResumer(cfg MultiPart, uploader MultipartUploader, log *log.Logger) *resumer {
+	return &resumer{
+		cfg:      cfg,
+		uploader: uploader,
+		log:      log,
+	}
+}
+
+// enabled reports whether the file of size is uploaded by resumer
+func (r *resumer) enabled(size int64) bool {
+	return r != nil && r.cfg.Resume && size > r.cfg.PartSize
+}
+
+// upload uploads the file in parts and returns the etag, the checkpoint is not saved if resume is disabled
+func (r *resumer) upload(Bucket, remotePath, filename string, meta map[string]string) (string, error) {
+	return r.uploadWith(r.uploader, Bucket, remotePath, filename, meta)
+}
+
+// uploadWith uploads the file in parts by the uploader instead of the one of resumer, such as the uploader
+// applying the options of object
+func (r *resumer) uploadWith(u MultipartUploader, Bucket, remotePath, filename string, meta map[string]string) (string, error) {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	cp := &checkpoint{
+		Bucket:     Bucket,
+		RemotePath: remotePath,
+		File:       filename,
+		Size:       fi.Size(),
+		PartSize:   r.cfg.PartSize,
+	}
+	var file string
+	if r.cfg.Resume {
+		cp.MD5, err = utils.CalculateFileMD5(filename)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		file = r.file(cp)
+		r.clean(cp)
+	}
+	if old, err := r.load(file); err == nil {
+		if old.matches(cp) {
+			old.File = filename
+			cp = old
+			r.log.Info("resume multipart upload", log.Any("remotePath", remotePath), log.Any("uploadId", cp.UploadID), log.Any("parts", len(cp.Parts)))
+		} else {
+			r.abort(file, old)
+		}
+	}
+	if cp.UploadID == "" {
+		cp.UploadID, err = u.InitMultipartUpload(Bucket, remotePath, meta)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		err = r.save(file, cp)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+	}
+
+	err = r.uploadParts(u, file, cp)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	sort.Slice(cp.Parts, func(i, j int) bool {
+		return cp.Parts[i].Number < cp.Parts[j].Number
+	})
+	etag, err := u.CompleteMultipartUpload(Bucket, remotePath, cp.UploadID, cp.Parts, meta)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if file != "" {
+		os.Remove(file)
+	}
+	return etag, nil
+}
+
+// uploadParts uploads the parts not completed concurrently, the checkpoint is saved once a part completed
+func (r *resumer) uploadParts(u MultipartUploader, file string, cp *checkpoint) error {
+	f, err := os.Open(cp.File)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+
+	done := make(map[int]bool)
+	for _, p := range cp.Parts {
+		done[p.Number] = true
+	}
+	numbers := make(chan int)
+	count := int((cp.Size + cp.PartSize - 1) / cp.PartSize)
+	go func() {
+		defer close(numbers)
+		for n := 1; n <= count; n++ {
+			if !done[n] {
+				numbers <- n
+			}
+		}
+	}()
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	var errs []error
+	concurrency := r.cfg.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range numbers {
+				off := int64(n-1) * cp.PartSize
+				size := cp.PartSize
+				if off+size > cp.Size {
+					size = cp.Size - off
+				}
+				etag, err := u.UploadPart(cp.Bucket, cp.RemotePath, cp.UploadID, n, f, off, size)
+				lock.Lock()
+				if err != nil {
+					errs = append(errs, errors.Errorf("failed to upload part (%d): %s", n, err.Error()))
+				} else {
+					cp.Parts = append(cp.Parts, Part{Number: n, ETag: etag})
+					if err = r.save(file, cp); err != nil {
+						r.log.Warn("failed to save checkpoint", log.Error(err))
+					}
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// clean aborts the multipart uploads not updated within the expiration, and the ones of the same object as cp
+// but another content which are superseded, then removes their checkpoints
+func (r *resumer) clean(cp *checkpoint) {
+	files, err := filepath.Glob(path.Join(r.cfg.Path, "*.yml"))
+	if err != nil {
+		return
+	}
+	var deadline time.Time
+	if r.cfg.Expiration > 0 {
+		deadline = time.Now().Add(-r.cfg.Expiration)
+	}
+	var current string
+	if cp != nil {
+		current = r.file(cp)
+	}
+	for _, file := range files {
+		old, err := r.load(file)
+		if err != nil {
+			continue
+		}
+		if cp != nil && file != current && old.Bucket == cp.Bucket && old.RemotePath == cp.RemotePath {
+			r.log.Info("abort multipart upload superseded", log.Any("remotePath", old.RemotePath), log.Any("uploadId", old.UploadID))
+			r.abort(file, old)
+			continue
+		}
+		if old.Updated.Before(deadline) {
+			r.log.Info("abort stale multipart upload", log.Any("remotePath", old.RemotePath), log.Any("uploadId", old.UploadID))
+			r.abort(file, old)
+		}
+	}
+}
+
+func (r *resumer) abort(file string, cp *checkpoint) {
+	if cp.UploadID != "" {
+		err := r.uploader.AbortMultipartUpload(cp.Bucket, cp.RemotePath, cp.UploadID)
+		if err != nil {
+			r.log.Warn("failed to abort multipart upload", log.Any("uploadId", cp.UploadID), log.Error(err))
+		}
+	}
+	os.Remove(file)
+}
+
+// file returns the checkpoint file of upload, keyed by the object and the size and md5 of content uploaded
+// instead of the local file, since the file zipped, packaged or encrypted is staged in a new temp file every time
+func (r *resumer) file(cp *checkpoint) string {
+	sum := md5.Sum([]byte(fmt.Sprintf("%s/%s\x00%d\x00%s", cp.Bucket, cp.RemotePath, cp.Size, cp.MD5)))
+	return path.Join(r.cfg.Path, hex.EncodeToString(sum[:])+".yml")
+}
+
+func (r *resumer) load(file string) (*checkpoint, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if file == "" || !utils.FileExists(file) {
+		return nil, errors.Errorf("checkpoint (%s) not found", file)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var cp checkpoint
+	err = yaml.Unmarshal(data, &cp)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &cp, nil
+}
+
+func (r *resumer) save(file string, cp *checkpoint) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if file == "" {
+		return nil
+	}
+	err := os.MkdirAll(r.cfg.Path, 0755)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cp.Updated = time.Now()
+	data, err := yaml.Marshal(cp)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	t := file + ".tmp"
+	err = ioutil.WriteFile(t, data, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(t, file))
+}
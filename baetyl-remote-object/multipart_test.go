@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockUploader stores the parts of multipart uploads in memory
+type mockUploader struct {
+	uploads  map[string]map[int][]byte
+	objects  map[string][]byte
+	aborted  []string
+	failPart int // the part failed once
+	parts    int
+	id       int
+	lock     sync.Mutex
+}
+
+func newMockUploader() *mockUploader {
+	return &mockUploader{
+		uploads: make(map[string]map[int][]byte),
+		objects: make(map[string][]byte),
+	}
+}
+
+func (m *mockUploader) InitMultipartUpload(Bucket, remotePath string, meta map[string]string) (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.id++
+	id := fmt.Sprintf("upload-%d", m.id)
+	m.uploads[id] = make(map[int][]byte)
+	return id, nil
+}
+
+func (m *mockUploader) UploadPart(Bucket, remotePath, uploadID string, number int, f *os.File, off, size int64) (string, error) {
+	data, err := ioutil.ReadAll(io.NewSectionReader(f, off, size))
+	if err != nil {
+		return "", err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if number == m.failPart {
+		m.failPart = 0
+		return "", fmt.Errorf("network unreachable")
+	}
+	parts, ok := m.uploads[uploadID]
+	if !ok {
+		return "", fmt.Errorf("upload (%s) not found", uploadID)
+	}
+	parts[number] = data
+	m.parts++
+	return fmt.Sprintf("etag-%d", number), nil
+}
+
+func (m *mockUploader) CompleteMultipartUpload(Bucket, remotePath, uploadID string, parts []Part, meta map[string]string) (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var data []byte
+	for i, p := range parts {
+		if p.Number != i+1 {
+			return "", fmt.Errorf("part (%d) missing", i+1)
+		}
+		data = append(data, m.uploads[uploadID][p.Number]...)
+	}
+	m.objects[path.Join(Bucket, remotePath)] = data
+	delete(m.uploads, uploadID)
+	return "etag", nil
+}
+
+func (m *mockUploader) AbortMultipartUpload(Bucket, remotePath, uploadID string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.aborted = append(m.aborted, uploadID)
+	delete(m.uploads, uploadID)
+	return nil
+}
+
+func TestResumerUpload(t *testing.T) {
+	dir := t.TempDir()
+	file := path.Join(dir, "video.mp4")
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	assert.NoError(t, ioutil.WriteFile(file, data, 0644))
+
+	m := newMockUploader()
+	m.failPart = 3
+	cfg := MultiPart{PartSize: 100, Concurrency: 1, Resume: true, Path: path.Join(dir, "multipart"), Expiration: time.Hour}
+	r := newResumer(cfg, m, log.With())
+	assert.False(t, r.enabled(100))
+	assert.True(t, r.enabled(101))
+
+	// round 1: interrupted by the failure of part 3
+	_, err := r.upload("bucket", "a/video.mp4", file, nil)
+	assert.Error(t, err)
+	assert.Equal(t, 9, m.parts)
+
+	// round 2: resumed by another resumer, only part 3 is uploaded again
+	r = newResumer(cfg, m, log.With())
+	etag, err := r.upload("bucket", "a/video.mp4", file, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "etag", etag)
+	assert.Equal(t, 10, m.parts)
+	assert.Equal(t, data, m.objects["bucket/a/video.mp4"])
+	files, err := ioutil.ReadDir(cfg.Path)
+	assert.NoError(t, err)
+	assert.Len(t, files, 0)
+
+	// round 3: the upload of changed file is not resumed
+	m.failPart = 2
+	_, err = r.upload("bucket", "a/video.mp4", file, nil)
+	assert.Error(t, err)
+	assert.NoError(t, ioutil.WriteFile(file, data[:500], 0644))
+	_, err = r.upload("bucket", "a/video.mp4", file, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"upload-2"}, m.aborted)
+	assert.Equal(t, data[:500], m.objects["bucket/a/video.mp4"])
+
+	// round 4: the same content staged in another temp file is resumed
+	m.failPart = 4
+	_, err = r.upload("bucket", "a/video.mp4", file, nil)
+	assert.Error(t, err)
+	staged := path.Join(dir, "staged")
+	assert.NoError(t, copyFile(file, staged))
+	parts := m.parts
+	_, err = r.upload("bucket", "a/video.mp4", staged, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, parts+1, m.parts)
+	assert.Equal(t, data[:500], m.objects["bucket/a/video.mp4"])
+}
+
+func TestResumerClean(t *testing.T) {
+	dir := t.TempDir()
+	file := path.Join(dir, "video.mp4")
+	assert.NoError(t, ioutil.WriteFile(file, make([]byte, 300), 0644))
+
+	m := newMockUploader()
+	m.failPart = 1
+	cfg := MultiPart{PartSize: 100, Concurrency: 2, Resume: true, Path: path.Join(dir, "multipart"), Expiration: time.Hour}
+	r := newResumer(cfg, m, log.With())
+	_, err := r.upload("bucket", "a/video.mp4", file, nil)
+	assert.Error(t, err)
+
+	// the upload not expired is kept
+	r.clean(nil)
+	assert.Len(t, m.aborted, 0)
+	assert.Len(t, m.uploads, 1)
+
+	// the upload expired is aborted
+	r.cfg.Expiration = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	r.clean(nil)
+	assert.Equal(t, []string{"upload-1"}, m.aborted)
+	assert.Len(t, m.uploads, 0)
+	files, err := ioutil.ReadDir(cfg.Path)
+	assert.NoError(t, err)
+	assert.Len(t, files, 0)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+)
+
+// inotify notifies the changes of directories by inotify
+type inotify struct {
+	fd     int
+	file   *os.File
+	dirs   map[string]int
+	wds    map[int]string
+	events chan notification
+	lock   sync.Mutex
+}
+
+func newNotifier() (notifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, errors.Errorf("failed to init inotify: %s", err.Error())
+	}
+	n := &inotify{
+		fd: fd,
+		// the non-blocking fd is polled by runtime, so that read is interrupted by close
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		dirs:   make(map[string]int),
+		wds:    make(map[int]string),
+		events: make(chan notification, 1024),
+	}
+	go n.reading()
+	return n, nil
+}
+
+func (n *inotify) add(dir string) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if _, ok := n.dirs[dir]; ok {
+		return nil
+	}
+	mask := uint32(syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_MOVED_TO | syscall.IN_MODIFY)
+	wd, err := syscall.InotifyAddWatch(n.fd, dir, mask)
+	if err != nil {
+		return errors.Errorf("failed to watch dir (%s): %s", dir, err.Error())
+	}
+	n.dirs[dir] = wd
+	n.wds[wd] = dir
+	return nil
+}
+
+func (n *inotify) notifications() <-chan notification {
+	return n.events
+}
+
+func (n *inotify) close() error {
+	return n.file.Close()
+}
+
+func (n *inotify) reading() {
+	defer close(n.events)
+	buf := make([]byte, syscall.SizeofInotifyEvent*256+syscall.PathMax)
+	for {
+		c, err := n.file.Read(buf)
+		if err != nil {
+			return
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= c; {
+			e := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(e.Len)]
+			off += syscall.SizeofInotifyEvent + int(e.Len)
+			n.lock.Lock()
+			dir, ok := n.wds[int(e.Wd)]
+			if ok && e.Mask&syscall.IN_IGNORED != 0 {
+				delete(n.wds, int(e.Wd))
+				delete(n.dirs, dir)
+			}
+			n.lock.Unlock()
+			if !ok {
+				continue
+			}
+			p := path.Join(dir, string(bytes.TrimRight(name, "\x00")))
+			select {
+			case n.events <- notification{path: p, closed: e.Mask&(syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO) != 0}:
+			default:
+				// the changes are found by scanning if notifications overflow
+			}
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "github.com/baetyl/baetyl-go/v2/errors"
+
+func newNotifier() (notifier, error) {
+	return nil, errors.New("notifier not supported on this platform")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+)
+
+// The server-side encryption of objects
+const (
+	SSES3  = "SSE-S3"
+	SSEKMS = "SSE-KMS"
+	SSEC   = "SSE-C"
+
+	octetStream = "application/octet-stream"
+)
+
+// OptionsHandler the storage handler which uploads objects with options, such as S3 and BOS. The objects are read
+// with the options they are uploaded with, e.g. the customer key of SSE-C is sent if the object is encrypted by it
+type OptionsHandler interface {
+	PutObjectFromFileWithOptions(Bucket, remotePath, filename string, meta map[string]string, opts *ObjectOptions) (string, error)
+	GetObjectToFileWithOptions(Bucket, remotePath, filename string, opts *ObjectOptions) error
+	FileExistsWithOptions(Bucket, remotePath, md5 string, opts *ObjectOptions) bool
+}
+
+func (o *ObjectOptions) empty() bool {
+	return o == nil || (o.SSE == "" && o.SSEKMSKeyID == "" && o.SSECustomerKey == "" && o.StorageClass == "" &&
+		o.ACL == "" && o.ContentType == "" && o.ContentEncoding == "" && o.CacheControl == "" && len(o.Tags) == 0)
+}
+
+func (o *ObjectOptions) validate() error {
+	switch o.SSE {
+	case "", SSES3, SSEKMS:
+	case SSEC:
+		if o.SSECustomerKey == "" {
+			return errors.New("the customer key of SSE-C is required")
+		}
+	default:
+		return errors.Errorf("server-side encryption (%s) unsupported", o.SSE)
+	}
+	if o.SSEKMSKeyID != "" && o.SSE != SSEKMS {
+		return errors.Errorf("the kms key is only used by %s", SSEKMS)
+	}
+	for k := range o.Tags {
+		if k == "" {
+			return errors.New("the key of tag is required")
+		}
+	}
+	return nil
+}
+
+// merge returns the options of client overridden by the ones of event, the tags are merged by key.
+// The customer key can't be set by event, since it is a local file
+func (o *ObjectOptions) merge(e *ObjectOptions) (*ObjectOptions, error) {
+	res := *o
+	res.Tags = make(map[string]string, len(o.Tags))
+	for k, v := range o.Tags {
+		res.Tags[k] = v
+	}
+	if e != nil {
+		if e.SSECustomerKey != "" {
+			return nil, errors.New("the customer key of SSE-C can't be set by event")
+		}
+		if e.SSE != "" {
+			res.SSE, res.SSEKMSKeyID = e.SSE, e.SSEKMSKeyID
+		}
+		for _, f := range []struct{ dst, src *string }{
+			{&res.StorageClass, &e.StorageClass},
+			{&res.ACL, &e.ACL},
+			{&res.ContentType, &e.ContentType},
+			{&res.ContentEncoding, &e.ContentEncoding},
+			{&res.CacheControl, &e.CacheControl},
+		} {
+			if *f.src != "" {
+				*f.dst = *f.src
+			}
+		}
+		for k, v := range e.Tags {
+			res.Tags[k] = v
+		}
+	}
+	if err := res.validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &res, nil
+}
+
+// tagging returns the tags in url query, such as a=1&b=2
+func (o *ObjectOptions) tagging() string {
+	tags := url.Values{}
+	for k, v := range o.Tags {
+		tags.Set(k, v)
+	}
+	return tags.Encode()
+}
+
+// customerKey the key of SSE-C in base64 and the md5 of key in base64
+type customerKey struct {
+	raw string
+	key string
+	md5 string
+}
+
+func loadCustomerKey(o ObjectOptions) (*customerKey, error) {
+	if o.SSECustomerKey == "" {
+		return nil, nil
+	}
+	key, err := loadStaticKey(o.SSECustomerKey)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	sum := md5.Sum(key)
+	return &customerKey{
+		raw: string(key),
+		key: base64.StdEncoding.EncodeToString(key),
+		md5: base64.StdEncoding.EncodeToString(sum[:]),
+	}, nil
+}
+
+// detectContentType detects the content type by the extension of remote path, or by the first 512 bytes of file
+func detectContentType(filename, remotePath string) string {
+	if t := mime.TypeByExtension(path.Ext(remotePath)); t != "" {
+		return t
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return octetStream
+	}
+	defer f.Close()
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(f, buf)
+	if n == 0 {
+		return octetStream
+	}
+	return http.DetectContentType(buf[:n])
+}
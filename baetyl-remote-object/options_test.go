@@ -0,0 +1,416 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockOptions records the headers of requests to check the object options, the results of multipart upload
+// are in json for BOS or in xml for S3
+type mockOptions struct {
+	json    bool
+	headers map[string]http.Header // by the operation: init, part, complete, or the method
+	lock    sync.Mutex
+}
+
+func newMockOptions(json bool) *mockOptions {
+	return &mockOptions{json: json, headers: map[string]http.Header{}}
+}
+
+func (m *mockOptions) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	ioutil.ReadAll(r.Body)
+	q := r.URL.Query()
+	op := r.Method
+	switch {
+	case r.Method == http.MethodPost && q.Get("uploadId") == "":
+		op = "init"
+		if m.json {
+			fmt.Fprint(w, `{"bucket":"bucket","key":"key","uploadId":"1"}`)
+		} else {
+			fmt.Fprint(w, "<InitiateMultipartUploadResult><UploadId>1</UploadId></InitiateMultipartUploadResult>")
+		}
+	case r.Method == http.MethodPut && q.Get("partNumber") != "":
+		op = "part"
+		w.Header().Set("ETag", "\"part\"")
+	case r.Method == http.MethodPost:
+		op = "complete"
+		if m.json {
+			fmt.Fprint(w, `{"bucket":"bucket","key":"key","eTag":"etag"}`)
+		} else {
+			fmt.Fprint(w, "<CompleteMultipartUploadResult><ETag>\"etag\"</ETag></CompleteMultipartUploadResult>")
+		}
+	case r.Method == http.MethodGet:
+		fmt.Fprint(w, "data")
+	default:
+		w.Header().Set("ETag", "\"etag\"")
+	}
+	m.headers[op] = r.Header
+}
+
+// newTestOptions writes a file of 12 bytes and the SSE-C key in dir, returns the file and the options of client
+func newTestOptions(t *testing.T, dir string) (string, ObjectOptions) {
+	file := path.Join(dir, "a.txt")
+	assert.NoError(t, ioutil.WriteFile(file, []byte("hello world!"), 0644))
+	key := path.Join(dir, "sse.key")
+	assert.NoError(t, ioutil.WriteFile(key, make([]byte, 32), 0600))
+	return file, ObjectOptions{
+		SSE:            SSEKMS,
+		SSEKMSKeyID:    "kms-1",
+		SSECustomerKey: key,
+		StorageClass:   "STANDARD_IA",
+		ACL:            "private",
+		ContentType:    "text/plain",
+		CacheControl:   "no-cache",
+		Tags:           map[string]string{"a": "1"},
+	}
+}
+
+func TestObjectOptions(t *testing.T) {
+	var o *ObjectOptions
+	assert.True(t, o.empty())
+	assert.True(t, (&ObjectOptions{Tags: map[string]string{}}).empty())
+
+	client := ObjectOptions{SSE: SSES3, StorageClass: "STANDARD_IA", CacheControl: "no-cache", Tags: map[string]string{"a": "1", "b": "2"}}
+	res, err := client.merge(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, client, *res)
+
+	res, err = client.merge(&ObjectOptions{SSE: SSEKMS, SSEKMSKeyID: "kms-1", ACL: "public-read", Tags: map[string]string{"b": "3", "c": "4"}})
+	assert.NoError(t, err)
+	assert.Equal(t, ObjectOptions{
+		SSE:          SSEKMS,
+		SSEKMSKeyID:  "kms-1",
+		StorageClass: "STANDARD_IA",
+		ACL:          "public-read",
+		CacheControl: "no-cache",
+		Tags:         map[string]string{"a": "1", "b": "3", "c": "4"},
+	}, *res)
+	assert.Equal(t, "a=1&b=3&c=4", res.tagging())
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, client.Tags)
+
+	// the customer key can't be set by event
+	_, err = client.merge(&ObjectOptions{SSECustomerKey: "sse.key"})
+	assert.Error(t, err)
+
+	// options invalid
+	for _, o := range []ObjectOptions{
+		{SSE: "SSE-X"},
+		{SSE: SSEC},
+		{SSE: SSES3, SSEKMSKeyID: "kms-1"},
+		{Tags: map[string]string{"": "1"}},
+	} {
+		assert.Error(t, o.validate())
+	}
+	assert.NoError(t, (&ObjectOptions{SSE: SSEC, SSECustomerKey: "sse.key"}).validate())
+
+	dir := t.TempDir()
+	png := path.Join(dir, "a")
+	assert.NoError(t, ioutil.WriteFile(png, []byte("\x89PNG\x0D\x0A\x1A\x0A0000"), 0644))
+	assert.Equal(t, "image/png", detectContentType(png, "a"))
+	assert.Equal(t, "application/json", detectContentType(png, "a.json"))
+	empty := path.Join(dir, "empty")
+	assert.NoError(t, ioutil.WriteFile(empty, nil, 0644))
+	assert.Equal(t, octetStream, detectContentType(empty, "a"))
+	assert.Equal(t, octetStream, detectContentType(path.Join(dir, "none"), "a"))
+}
+
+func TestS3Options(t *testing.T) {
+	dir := t.TempDir()
+	file, opts := newTestOptions(t, dir)
+	m := newMockOptions(false)
+	// the customer key is only sent by https, the ca bundle of environment overrides the one of test server
+	t.Setenv("AWS_CA_BUNDLE", "")
+	s := httptest.NewTLSServer(m)
+	defer s.Close()
+	sess, err := session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials("ak", "sk", ""),
+		Endpoint:         aws.String(s.URL),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		HTTPClient:       s.Client(),
+	})
+	assert.NoError(t, err)
+	c := *cfg
+	c.Timeout = time.Minute
+	c.MultiPart = MultiPart{PartSize: s3manager.MinUploadPartSize, Concurrency: 1}
+	c.Object = opts
+	ssec, err := loadCustomerKey(opts)
+	assert.NoError(t, err)
+	h := &S3Handler{
+		s3Client:   s3.New(sess),
+		uploader:   s3manager.NewUploader(sess),
+		downloader: s3manager.NewDownloader(sess),
+		cfg:        c,
+		ssec:       ssec,
+		log:        log.With(log.Any("test", "s3")),
+	}
+
+	// round 1: put object with the options of client
+	etag, err := h.PutObjectFromFile("bucket", "a.txt", file, map[string]string{"k": "v"})
+	assert.NoError(t, err)
+	assert.Equal(t, "etag", etag)
+	put := m.headers[http.MethodPut]
+	assert.Equal(t, "aws:kms", put.Get("X-Amz-Server-Side-Encryption"))
+	assert.Equal(t, "kms-1", put.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"))
+	assert.Equal(t, "STANDARD_IA", put.Get("X-Amz-Storage-Class"))
+	assert.Equal(t, "private", put.Get("X-Amz-Acl"))
+	assert.Equal(t, "text/plain", put.Get("Content-Type"))
+	assert.Equal(t, "no-cache", put.Get("Cache-Control"))
+	assert.Equal(t, "a=1", put.Get("X-Amz-Tagging"))
+	assert.Equal(t, "v", put.Get("X-Amz-Meta-K"))
+	assert.Empty(t, put.Get("Content-Encoding"))
+	assert.NotContains(t, m.headers, http.MethodHead)
+
+	// the etag of object uploaded in parts by uploader is of the completion
+	large := path.Join(dir, "large.txt")
+	assert.NoError(t, ioutil.WriteFile(large, make([]byte, s3manager.MinUploadPartSize+1), 0644))
+	etag, err = h.PutObjectFromFile("bucket", "large.txt", large, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "etag", etag)
+	assert.Contains(t, m.headers, "complete")
+	assert.NotContains(t, m.headers, http.MethodHead)
+
+	// round 2: upload in parts with the options of event, the customer key is sent with each part
+	h.resumer = newResumer(MultiPart{PartSize: 5, Concurrency: 1, Resume: true, Path: path.Join(dir, "multipart")}, h, h.log)
+	o, err := opts.merge(&ObjectOptions{SSE: SSEC, ContentEncoding: "gzip", StorageClass: "GLACIER"})
+	assert.NoError(t, err)
+	etag, err = h.PutObjectFromFileWithOptions("bucket", "a.txt", file, nil, o)
+	assert.NoError(t, err)
+	assert.Equal(t, "etag", etag)
+	sum := md5.Sum(make([]byte, 32))
+	init := m.headers["init"]
+	assert.Equal(t, "AES256", init.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm"))
+	assert.Equal(t, base64.StdEncoding.EncodeToString(make([]byte, 32)), init.Get("X-Amz-Server-Side-Encryption-Customer-Key"))
+	assert.Equal(t, base64.StdEncoding.EncodeToString(sum[:]), init.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5"))
+	assert.Empty(t, init.Get("X-Amz-Server-Side-Encryption"))
+	assert.Equal(t, "GLACIER", init.Get("X-Amz-Storage-Class"))
+	assert.Equal(t, "gzip", init.Get("Content-Encoding"))
+	assert.Equal(t, "a=1", init.Get("X-Amz-Tagging"))
+	assert.Equal(t, "AES256", m.headers["part"].Get("X-Amz-Server-Side-Encryption-Customer-Algorithm"))
+	assert.Empty(t, m.headers["part"].Get("X-Amz-Storage-Class"))
+
+	// round 3: the objects of client encrypted by SSE-C are read with the customer key
+	h.cfg.Object.SSE, h.cfg.Object.SSEKMSKeyID = SSEC, ""
+	assert.NoError(t, h.GetObjectToFile("bucket", "a.txt", path.Join(dir, "b.txt")))
+	assert.Equal(t, "AES256", m.headers[http.MethodGet].Get("X-Amz-Server-Side-Encryption-Customer-Algorithm"))
+
+	// round 4: the object encrypted by SSE-C of event is read with the customer key, though the ones of client are not
+	h.cfg.Object = opts
+	assert.False(t, h.FileExistsWithOptions("bucket", "a.txt", "md5", o))
+	assert.Equal(t, "AES256", m.headers[http.MethodHead].Get("X-Amz-Server-Side-Encryption-Customer-Algorithm"))
+	assert.NoError(t, h.GetObjectToFileWithOptions("bucket", "a.txt", path.Join(dir, "c.txt"), o))
+	assert.Equal(t, "AES256", m.headers[http.MethodGet].Get("X-Amz-Server-Side-Encryption-Customer-Algorithm"))
+	assert.False(t, h.FileExists("bucket", "a.txt", "md5"))
+	assert.Empty(t, m.headers[http.MethodHead].Get("X-Amz-Server-Side-Encryption-Customer-Algorithm"))
+
+	// the customer key is required by SSE-C
+	h.ssec = nil
+	_, err = h.PutObjectFromFileWithOptions("bucket", "a.txt", file, nil, o)
+	assert.Error(t, err)
+}
+
+func TestBosOptions(t *testing.T) {
+	dir := t.TempDir()
+	file, opts := newTestOptions(t, dir)
+	m := newMockOptions(true)
+	s := httptest.NewServer(m)
+	defer s.Close()
+	c := *cfg
+	c.Ak, c.Sk, c.Endpoint = "ak", "sk", s.URL
+	c.MultiPart = MultiPart{PartSize: 5, Concurrency: 1}
+	c.Object = opts
+	sh, err := NewBosHandler(c)
+	assert.NoError(t, err)
+	h := sh.(*BosHandler)
+
+	// round 1: put object with the options of client
+	etag, err := h.PutObjectFromFile("bucket", "a.txt", file, map[string]string{"k": "v"})
+	assert.NoError(t, err)
+	assert.Equal(t, "etag", etag)
+	put := m.headers[http.MethodPut]
+	assert.Equal(t, "KMS", put.Get(bosSSE))
+	assert.Equal(t, "kms-1", put.Get(bosSSEKMSKeyID))
+	assert.Equal(t, "STANDARD_IA", put.Get("x-bce-storage-class"))
+	assert.Equal(t, "private", put.Get("x-bce-acl"))
+	assert.Equal(t, "text/plain", put.Get("Content-Type"))
+	assert.Equal(t, "no-cache", put.Get("Cache-Control"))
+	assert.Equal(t, "a=1", put.Get(bosTagging))
+	assert.Equal(t, "v", put.Get("x-bce-meta-k"))
+
+	// round 2: upload in parts with the options of event, the customer key is sent with each part
+	h.resumer = newResumer(MultiPart{PartSize: 5, Concurrency: 1, Resume: true, Path: path.Join(dir, "multipart")}, h, h.log)
+	o, err := opts.merge(&ObjectOptions{SSE: SSEC, ContentEncoding: "gzip", StorageClass: "COLD"})
+	assert.NoError(t, err)
+	etag, err = h.PutObjectFromFileWithOptions("bucket", "a.txt", file, nil, o)
+	assert.NoError(t, err)
+	assert.Equal(t, "etag", etag)
+	sum := md5.Sum(make([]byte, 32))
+	init := m.headers["init"]
+	assert.Equal(t, "AES256", init.Get(bosSSECustomerAlgorithm))
+	assert.Equal(t, base64.StdEncoding.EncodeToString(make([]byte, 32)), init.Get(bosSSECustomerKey))
+	assert.Equal(t, base64.StdEncoding.EncodeToString(sum[:]), init.Get(bosSSECustomerKeyMD5))
+	assert.Empty(t, init.Get(bosSSE))
+	assert.Equal(t, "COLD", init.Get("x-bce-storage-class"))
+	assert.Equal(t, "gzip", init.Get("Content-Encoding"))
+	assert.Equal(t, "AES256", m.headers["part"].Get(bosSSECustomerAlgorithm))
+	assert.Empty(t, m.headers["part"].Get("x-bce-storage-class"))
+
+	// round 3: the objects of client encrypted by SSE-C are read with the customer key
+	h.cfg.Object.SSE, h.cfg.Object.SSEKMSKeyID = SSEC, ""
+	assert.NoError(t, h.GetObjectToFile("bucket", "a.txt", path.Join(dir, "b.txt")))
+	assert.Equal(t, "AES256", m.headers[http.MethodGet].Get(bosSSECustomerAlgorithm))
+	data, err := ioutil.ReadFile(path.Join(dir, "b.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+
+	// the object encrypted by SSE-C of event is read with the customer key, though the ones of client are not
+	h.cfg.Object = opts
+	assert.False(t, h.FileExistsWithOptions("bucket", "a.txt", "md5", o))
+	assert.Equal(t, "AES256", m.headers[http.MethodHead].Get(bosSSECustomerAlgorithm))
+	assert.NoError(t, h.GetObjectToFileWithOptions("bucket", "a.txt", path.Join(dir, "c.txt"), o))
+	assert.Equal(t, "AES256", m.headers[http.MethodGet].Get(bosSSECustomerAlgorithm))
+	assert.False(t, h.FileExists("bucket", "a.txt", "md5"))
+	assert.Empty(t, m.headers[http.MethodHead].Get(bosSSECustomerAlgorithm))
+	assert.NoError(t, h.GetObjectToFile("bucket", "a.txt", path.Join(dir, "c.txt")))
+	assert.Empty(t, m.headers[http.MethodGet].Get(bosSSECustomerAlgorithm))
+
+	// round 4: the options supported by sdk only, in one request or in parts
+	h.cfg.Object = ObjectOptions{}
+	o = &ObjectOptions{StorageClass: "COLD", ContentType: "text/plain", CacheControl: "no-cache"}
+	h.resumer = newResumer(MultiPart{}, h, h.log)
+	_, err = h.PutObjectFromFileWithOptions("bucket", "a.txt", file, map[string]string{"k": "v"}, o)
+	assert.NoError(t, err)
+	put = m.headers[http.MethodPut]
+	assert.Equal(t, "COLD", put.Get("x-bce-storage-class"))
+	assert.Equal(t, "text/plain", put.Get("Content-Type"))
+	assert.Equal(t, "no-cache", put.Get("Cache-Control"))
+	assert.Equal(t, "v", put.Get("x-bce-meta-k"))
+	assert.Empty(t, put.Get("x-bce-acl"))
+	assert.Empty(t, put.Get(bosSSE))
+	h.resumer = newResumer(MultiPart{PartSize: 5, Concurrency: 1, Resume: true, Path: path.Join(dir, "sdk")}, h, h.log)
+	_, err = h.PutObjectFromFileWithOptions("bucket", "a.txt", file, nil, o)
+	assert.NoError(t, err)
+	init = m.headers["init"]
+	assert.Equal(t, "COLD", init.Get("x-bce-storage-class"))
+	assert.Equal(t, "text/plain", init.Get("Content-Type"))
+	assert.Empty(t, init.Get(bosSSECustomerAlgorithm))
+	assert.Empty(t, m.headers["part"].Get(bosSSECustomerAlgorithm))
+
+	// options invalid
+	c.Object = ObjectOptions{SSE: SSEC}
+	_, err = NewBosHandler(c)
+	assert.Error(t, err)
+	c.Object = ObjectOptions{SSECustomerKey: path.Join(dir, "a.txt")}
+	_, err = NewBosHandler(c)
+	assert.Error(t, err)
+}
+
+// mockOptionsHandler records the options of objects uploaded and read by mock handler
+type mockOptionsHandler struct {
+	*mockHandler
+	opts  []*ObjectOptions
+	reads []*ObjectOptions
+}
+
+func (m *mockOptionsHandler) PutObjectFromFileWithOptions(Bucket, remotePath, filename string, meta map[string]string, opts *ObjectOptions) (string, error) {
+	m.opts = append(m.opts, opts)
+	return m.PutObjectFromFile(Bucket, remotePath, filename, meta)
+}
+
+func (m *mockOptionsHandler) GetObjectToFileWithOptions(Bucket, remotePath, filename string, opts *ObjectOptions) error {
+	m.reads = append(m.reads, opts)
+	return m.GetObjectToFile(Bucket, remotePath, filename)
+}
+
+func (m *mockOptionsHandler) FileExistsWithOptions(Bucket, remotePath, md5 string, opts *ObjectOptions) bool {
+	m.reads = append(m.reads, opts)
+	return m.FileExists(Bucket, remotePath, md5)
+}
+
+func TestClientOptions(t *testing.T) {
+	cli, mh := newMockClient(t)
+	assert.NoError(t, copyFile("example/etc/baetyl/service-bos.yml", path.Join(cli.pwd, "a.yml")))
+
+	// the options are not supported by handler
+	_, err := cli.handleUploadEvent(&UploadEvent{LocalPath: "a.yml", RemotePath: "a.yml", Options: &ObjectOptions{ACL: "private"}})
+	assert.Error(t, err)
+	_, err = cli.handleUploadEvent(&UploadEvent{LocalPath: "a.yml", RemotePath: "a.yml"})
+	assert.NoError(t, err)
+	_, err = cli.handleDownloadEvent(&DownloadEvent{RemotePath: "a.yml", LocalPath: "b.yml", Options: &ObjectOptions{SSE: SSES3}})
+	assert.Error(t, err)
+	cli.cfg.Object = ObjectOptions{StorageClass: "COLD"}
+	_, err = cli.handleUploadEvent(&UploadEvent{LocalPath: "a.yml", RemotePath: "a.yml"})
+	assert.Error(t, err)
+	assert.Equal(t, 1, mh.puts)
+
+	// the object options of client are rejected by the kind unsupported
+	c := *cfg
+	c.Name = "file"
+	c.Kind = File
+	c.Endpoint = t.TempDir()
+	c.TempPath = path.Join(t.TempDir(), "tmp")
+	c.Limit.Path = path.Join(t.TempDir(), "stats.yml")
+	c.Pool = Pool{Worker: 1, Idletime: time.Minute}
+	c.Object = ObjectOptions{ACL: "private"}
+	_, err = NewClient(nil, c)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported")
+	c.Object = ObjectOptions{}
+	fc, err := NewClient(nil, c)
+	assert.NoError(t, err)
+	assert.NoError(t, fc.Close())
+
+	h := &mockOptionsHandler{mockHandler: mh}
+	cli.handler = h
+	cli.cfg.Object = ObjectOptions{StorageClass: "COLD", Tags: map[string]string{"a": "1"}}
+	_, err = cli.handleUploadEvent(&UploadEvent{LocalPath: "a.yml", RemotePath: "b"})
+	assert.NoError(t, err)
+	_, err = cli.handleUploadEvent(&UploadEvent{LocalPath: "a.yml", RemotePath: "c.json", Options: &ObjectOptions{ACL: "private", Tags: map[string]string{"b": "2"}}})
+	assert.NoError(t, err)
+	assert.Len(t, h.opts, 2)
+	assert.Equal(t, ObjectOptions{StorageClass: "COLD", ContentType: "text/plain; charset=utf-8", Tags: map[string]string{"a": "1"}}, *h.opts[0])
+	assert.Equal(t, ObjectOptions{StorageClass: "COLD", ACL: "private", ContentType: "application/json", Tags: map[string]string{"a": "1", "b": "2"}}, *h.opts[1])
+
+	// the content of object encrypted by client is unknown
+	keys := newTestKeys(t, t.TempDir())
+	cli.crypter, err = newCrypter(keys["static"])
+	assert.NoError(t, err)
+	_, err = cli.handleUploadEvent(&UploadEvent{LocalPath: "a.yml", RemotePath: "d.json"})
+	assert.NoError(t, err)
+	assert.Equal(t, octetStream, h.opts[2].ContentType)
+
+	_, err = cli.handleUploadEvent(&UploadEvent{LocalPath: "a.yml", RemotePath: "e", Options: &ObjectOptions{SSE: "SSE-X"}})
+	assert.Error(t, err)
+	assert.Len(t, h.opts, 3)
+
+	// the object uploaded with SSE-C of event is checked and read with the options of event
+	cli.crypter = nil
+	cli.cfg.Object = ObjectOptions{SSECustomerKey: "key"}
+	h.reads = nil
+	_, err = cli.handleUploadEvent(&UploadEvent{LocalPath: "a.yml", RemotePath: "f", Options: &ObjectOptions{SSE: SSEC}})
+	assert.NoError(t, err)
+	_, err = cli.handleDownloadEvent(&DownloadEvent{RemotePath: "f", LocalPath: "f.yml", Options: &ObjectOptions{SSE: SSEC}})
+	assert.NoError(t, err)
+	assert.Len(t, h.reads, 2)
+	for _, o := range h.reads {
+		assert.Equal(t, SSEC, o.SSE)
+	}
+}
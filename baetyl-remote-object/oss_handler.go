@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"sort"
+	"strings"
+
+	dm "github.com/baetyl/baetyl-go/v2/context"
+	"github.com/baetyl/baetyl-go/v2/errors"
+)
+
+// NewOSSHandler creates a new handler of aliyun oss, the endpoint is the one of region
+// such as oss-cn-hangzhou.aliyuncs.com, the bucket is accessed by virtual host
+func NewOSSHandler(ctx dm.Context, cfg ClientInfo) (StorageHandler, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.Errorf("failed to create oss client (%s): endpoint is required", cfg.Name)
+	}
+	return newSignedHandler(ctx, cfg, ossSigner{}, "x-oss-meta-")
+}
+
+// ossSigner signs the request by the signature in header,
+// see https://help.aliyun.com/document_detail/31951.html
+type ossSigner struct{}
+
+func (ossSigner) sign(req *http.Request, Bucket, remotePath string, creds accessKey) {
+	if creds.token != "" {
+		req.Header.Set("x-oss-security-token", creds.token)
+	}
+	var headers []string
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-oss-") {
+			headers = append(headers, k+":"+strings.TrimSpace(strings.Join(v, ",")))
+		}
+	}
+	sort.Strings(headers)
+	resource := "/" + Bucket + "/" + strings.TrimPrefix(remotePath, "/")
+	query := req.URL.Query()
+	var params []string
+	for k, v := range query {
+		if len(v) == 0 || v[0] == "" {
+			params = append(params, k)
+		} else {
+			params = append(params, k+"="+v[0])
+		}
+	}
+	if len(params) > 0 {
+		sort.Strings(params)
+		resource += "?" + strings.Join(params, "&")
+	}
+	s := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		req.Header.Get("Date"),
+	}, "\n") + "\n"
+	for _, h := range headers {
+		s += h + "\n"
+	}
+	s += resource
+	mac := hmac.New(sha1.New, []byte(creds.sk))
+	mac.Write([]byte(s))
+	req.Header.Set("Authorization", "OSS "+creds.ak+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"compress/flate"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/docker/distribution/uuid"
+	"github.com/mholt/archiver"
+)
+
+// ManifestName the name of manifest in package, reserved for the manifest generated
+const ManifestName = "manifest.json"
+
+// Manifest manifest of package
+type Manifest struct {
+	Time    time.Time      `json:"time"`
+	Files   []ManifestFile `json:"files"`
+	Missing []string       `json:"missing,omitempty"`
+}
+
+// ManifestFile file in package
+type ManifestFile struct {
+	Name    string    `json:"name"`
+	Source  string    `json:"source"` // the path relative to the local path packaged, the host layout is not exposed
+	Size    int64     `json:"size"`
+	MD5     string    `json:"md5"`
+	ModTime time.Time `json:"modTime"`
+}
+
+type packFile struct {
+	name   string
+	source string // the real path to read
+	rel    string // the path relative to the local path packaged
+	info   os.FileInfo
+}
+
+func (cli *Client) handlePackageEvent(e *PackageEvent) (*Result, error) {
+	files, missing, err := collectFiles(cli.pwd, e)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(files) == 0 {
+		return nil, errors.Errorf("failed to package: no file matched in %v", e.LocalPaths)
+	}
+	t := path.Join(cli.cfg.TempPath, uuid.Generate().String())
+	defer os.RemoveAll(t)
+	err = packFiles(files, missing, t, e.Zip)
+	if err != nil {
+		return nil, errors.Errorf("failed to package: %s", err.Error())
+	}
+	return cli.upload(t, cli.cfg.Bucket, e.RemotePath, e.Meta, nil)
+}
+
+// collectFiles collects the regular files of local paths matched the include and exclude patterns,
+// the local paths not found are returned as missing
+func collectFiles(pwd string, e *PackageEvent) ([]packFile, []string, error) {
+	var files []packFile
+	var missing []string
+	for _, lp := range e.LocalPaths {
+		if strings.Contains(lp, "..") {
+			return nil, nil, errors.Errorf("failed to pass LocalPath (%s) check: the local path can't contains ..", lp)
+		}
+		p, err := filepath.EvalSymlinks(path.Join(pwd, lp))
+		if err != nil {
+			missing = append(missing, lp)
+			continue
+		}
+		base := strings.TrimPrefix(path.Clean(lp), "/")
+		err = filepath.Walk(p, func(fp string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(p, fp)
+			if err != nil {
+				return err
+			}
+			name := path.Join(base, filepath.ToSlash(rel))
+			// the local path of a file is packaged as its base name
+			if rel == "." {
+				rel = path.Base(name)
+			}
+			if !matchFile(name, e.Include, e.Exclude) {
+				return nil
+			}
+			if name == ManifestName {
+				return errors.Errorf("the name (%s) is reserved for the manifest of package", name)
+			}
+			files = append(files, packFile{name: name, source: fp, rel: filepath.ToSlash(rel), info: info})
+			return nil
+		})
+		if err != nil {
+			return nil, nil, errors.Errorf("failed to walk path (%s): %s", lp, err.Error())
+		}
+	}
+	return files, missing, nil
+}
+
+// matchFile reports whether the path or base name of file matches any include pattern and no exclude pattern,
+// all files are included if no include pattern
+func matchFile(name string, include, exclude []string) bool {
+	match := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+			if ok, _ := path.Match(pattern, path.Base(name)); ok {
+				return true
+			}
+		}
+		return false
+	}
+	if match(exclude) {
+		return false
+	}
+	return len(include) == 0 || match(include)
+}
+
+// packFiles writes the files and a generated manifest into an archive of zip or tar
+func packFiles(files []packFile, missing []string, dst string, zip bool) error {
+	var w archiver.Writer
+	if zip {
+		w = &archiver.Zip{
+			CompressionLevel:     flate.DefaultCompression,
+			SelectiveCompression: true,
+		}
+	} else {
+		w = &archiver.Tar{}
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer out.Close()
+	err = w.Create(out)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// the time of manifest is the newest modification of files rather than now, so the package of files
+	// unchanged is identical and its interrupted multipart upload can be resumed
+	var manifest Manifest
+	for _, f := range files {
+		if f.info.ModTime().After(manifest.Time) {
+			manifest.Time = f.info.ModTime()
+		}
+	}
+	for _, f := range files {
+		sum, err := writeFile(w, f)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		manifest.Files = append(manifest.Files, ManifestFile{
+			Name:    f.name,
+			Source:  f.rel,
+			Size:    f.info.Size(),
+			MD5:     sum,
+			ModTime: f.info.ModTime(),
+		})
+	}
+	manifest.Missing = missing
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	mf := dst + "." + ManifestName
+	err = ioutil.WriteFile(mf, data, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer os.Remove(mf)
+	info, err := os.Stat(mf)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = writeFile(w, packFile{name: ManifestName, source: mf, info: timedInfo{FileInfo: info, modTime: manifest.Time}})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(w.Close())
+}
+
+// timedInfo overrides the modification time of file info
+type timedInfo struct {
+	os.FileInfo
+	modTime time.Time
+}
+
+func (i timedInfo) ModTime() time.Time {
+	return i.modTime
+}
+
+// writeFile writes the file into archive and returns its md5 in hex
+func writeFile(w archiver.Writer, f packFile) (string, error) {
+	src, err := os.Open(f.source)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	h := md5.New()
+	err = w.Write(archiver.File{
+		FileInfo: archiver.FileInfo{
+			FileInfo:   f.info,
+			CustomName: f.name,
+		},
+		ReadCloser: ioutil.NopCloser(io.TeeReader(src, h)),
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/mholt/archiver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchFile(t *testing.T) {
+	assert.True(t, matchFile("var/log/a.log", nil, nil))
+	assert.True(t, matchFile("var/log/a.log", []string{"*.log"}, nil))
+	assert.True(t, matchFile("var/log/a.log", []string{"var/log/*"}, nil))
+	assert.False(t, matchFile("var/log/a.log", []string{"*.txt"}, nil))
+	assert.False(t, matchFile("var/log/a.log", []string{"*.log"}, []string{"a.*"}))
+	assert.False(t, matchFile("var/log/a.log", nil, []string{"var/*/a.log"}))
+}
+
+func TestCollectFiles(t *testing.T) {
+	pwd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	// local path can't contain ..
+	_, _, err = collectFiles(pwd, &PackageEvent{LocalPaths: []string{"../example"}})
+	assert.Error(t, err)
+
+	e := &PackageEvent{
+		LocalPaths: []string{"example/etc", "example/test/baetyl/service.yml", "example/none"},
+		Exclude:    []string{"service-s3.yml"},
+	}
+	files, missing, err := collectFiles(pwd, e)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example/none"}, missing)
+	var names []string
+	for _, f := range files {
+		names = append(names, f.name)
+	}
+	assert.Equal(t, []string{
+		"example/etc/baetyl/service-bos.yml",
+		"example/etc/baetyl/service-minio.yml",
+		"example/test/baetyl/service.yml",
+	}, names)
+
+	e.Include = []string{"*-bos.yml"}
+	files, _, err = collectFiles(pwd, e)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	// the file named as the manifest is rejected
+	dir := t.TempDir()
+	assert.NoError(t, ioutil.WriteFile(path.Join(dir, ManifestName), []byte("{}"), 0644))
+	_, _, err = collectFiles(dir, &PackageEvent{LocalPaths: []string{"."}})
+	assert.Error(t, err)
+	_, _, err = collectFiles(dir, &PackageEvent{LocalPaths: []string{ManifestName}})
+	assert.Error(t, err)
+}
+
+func TestPackFiles(t *testing.T) {
+	pwd, err := os.Getwd()
+	assert.NoError(t, err)
+	files, _, err := collectFiles(pwd, &PackageEvent{LocalPaths: []string{"example/etc"}})
+	assert.NoError(t, err)
+	assert.Len(t, files, 3)
+
+	dir := t.TempDir()
+	for _, a := range []struct {
+		zip    bool
+		walker archiver.Walker
+	}{
+		{zip: false, walker: &archiver.Tar{}},
+		{zip: true, walker: &archiver.Zip{}},
+	} {
+		dst := path.Join(dir, "package")
+		err = packFiles(files, []string{"var/log"}, dst, a.zip)
+		assert.NoError(t, err)
+
+		var names []string
+		var manifest Manifest
+		err = a.walker.Walk(dst, func(f archiver.File) error {
+			switch h := f.Header.(type) {
+			case *tar.Header:
+				names = append(names, h.Name)
+			case zip.FileHeader:
+				names = append(names, h.Name)
+			}
+			if f.Name() == ManifestName {
+				data, err := ioutil.ReadAll(f)
+				assert.NoError(t, err)
+				assert.NoError(t, json.Unmarshal(data, &manifest))
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Len(t, names, 4)
+		assert.Contains(t, names, "example/etc/baetyl/service-bos.yml")
+		assert.Len(t, manifest.Files, 3)
+		assert.Equal(t, []string{"var/log"}, manifest.Missing)
+		for _, f := range manifest.Files {
+			assert.Len(t, f.MD5, 32)
+			assert.NotZero(t, f.Size)
+			assert.Equal(t, "example/etc/"+f.Source, f.Name)
+		}
+
+		// the package of files unchanged is identical, so its upload can be resumed
+		first, err := ioutil.ReadFile(dst)
+		assert.NoError(t, err)
+		time.Sleep(1100 * time.Millisecond)
+		assert.NoError(t, packFiles(files, []string{"var/log"}, dst, a.zip))
+		second, err := ioutil.ReadFile(dst)
+		assert.NoError(t, err)
+		assert.Equal(t, first, second)
+		os.Remove(dst)
+	}
+}
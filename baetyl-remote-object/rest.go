@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/utils"
+)
+
+// the max bytes of error response kept in error
+const restErrorLimit = 512
+
+// restError the error response of storage accessed by rest api
+type restError struct {
+	Method  string
+	URL     string
+	Status  int
+	Message string
+}
+
+func (e *restError) Error() string {
+	return fmt.Sprintf("failed to %s (%s): status %d: %s", e.Method, e.URL, e.Status, e.Message)
+}
+
+// isNotFound reports whether the error is the response of 404
+func isNotFound(err error) bool {
+	e, ok := errors.Cause(err).(*restError)
+	return ok && e.Status == http.StatusNotFound
+}
+
+// restClient the http client of storages accessed by rest api, such as AZURE and GCS
+type restClient struct {
+	cli *http.Client
+}
+
+// newRESTClient creates the http client, the timeout is applied to connect and wait for response
+// but not to transfer the body of large object
+func newRESTClient(cfg ClientInfo) (*restClient, error) {
+	transport := newTransport(cfg)
+	if cfg.TLS.CA != "" || cfg.TLS.Cert != "" || cfg.TLS.InsecureSkipVerify {
+		tlsCfg, err := utils.NewTLSConfigClient(cfg.TLS)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		transport.TLSClientConfig = tlsCfg
+	}
+	return &restClient{cli: &http.Client{Transport: transport}}, nil
+}
+
+// newHTTPClient creates the http client with the transport of newTransport and the default tls config
+func newHTTPClient(cfg ClientInfo) *http.Client {
+	return &http.Client{Transport: newTransport(cfg)}
+}
+
+// newTransport creates the transport whose timeout is applied to connect and wait for response headers only
+func newTransport(cfg ClientInfo) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout: cfg.Timeout,
+		}).DialContext,
+		TLSHandshakeTimeout:   cfg.Timeout,
+		ResponseHeaderTimeout: cfg.Timeout,
+		MaxIdleConnsPerHost:   cfg.MultiPart.Concurrency,
+	}
+}
+
+// do sends the request, returns the response if 2xx or the status accepted, otherwise the restError
+func (c *restClient) do(req *http.Request, accepted ...int) (*http.Response, error) {
+	res, err := c.cli.Do(req)
+	if err != nil {
+		if ue, ok := err.(*url.Error); ok {
+			// the url of transport error contains the query, which may contain token
+			if u, e := url.Parse(ue.URL); e == nil {
+				u.RawQuery = ""
+				ue.URL = u.String()
+			}
+		}
+		return nil, errors.Trace(err)
+	}
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+	for _, s := range accepted {
+		if res.StatusCode == s {
+			return res, nil
+		}
+	}
+	defer res.Body.Close()
+	data, _ := ioutil.ReadAll(io.LimitReader(res.Body, restErrorLimit))
+	u := *req.URL
+	u.RawQuery = "" // the query may contain token
+	return nil, errors.Trace(&restError{
+		Method:  req.Method,
+		URL:     u.String(),
+		Status:  res.StatusCode,
+		Message: strings.TrimSpace(string(data)),
+	})
+}
+
+// discard sends the request and discards the body of response
+func (c *restClient) discard(req *http.Request) (http.Header, error) {
+	res, err := c.do(req)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	return res.Header, nil
+}
+
+// download sends the request and writes the body of response to file
+func (c *restClient) download(req *http.Request, filename string) error {
+	res, err := c.do(req)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer res.Body.Close()
+	f, err := os.OpenFile(filename, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+	_, err = io.Copy(f, res.Body)
+	return errors.Trace(err)
+}
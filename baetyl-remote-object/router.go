@@ -0,0 +1,191 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/baetyl/baetyl-go/v2/mqtt"
+)
+
+// router routes the upload events of rule by the file attributes, the first matched route is applied.
+// The events are journaled by the first client of rule, the event routed to another client is dispatched
+// as a copy and its result is reported back to the journal of the first client
+type router struct {
+	routes  []Route
+	targets []dispatcher // the target of route, nil if routed to the target of rule
+	target  dispatcher   // the target of rule
+	owner   *Client      // the first client of rule which journals the events
+	sized   bool         // whether any route checks the file size
+	log     *log.Logger
+}
+
+func newRouter(routes []Route, target dispatcher, owner *Client, clients map[string]*Client, rule string) (*router, error) {
+	r := &router{
+		target: target,
+		owner:  owner,
+		log:    log.With(log.Any("rule", rule)),
+	}
+	for i, rt := range routes {
+		if rt.Client == "" && rt.Bucket == "" {
+			return nil, errors.Errorf("route (%d) of rule (%s) invalid: client or bucket is required", i, rule)
+		}
+		if rt.MaxSize > 0 && rt.MaxSize <= rt.MinSize {
+			return nil, errors.Errorf("route (%d) of rule (%s) invalid: maxSize should be larger than minSize", i, rule)
+		}
+		if rt.Topic != "" && !mqtt.CheckTopic(rt.Topic, true) {
+			return nil, errors.Errorf("route (%d) of rule (%s) invalid: topic (%s) invalid", i, rule, rt.Topic)
+		}
+		for _, p := range rt.Meta {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, errors.Errorf("route (%d) of rule (%s) invalid: meta pattern (%s) invalid", i, rule, p)
+			}
+		}
+		exts := make([]string, 0, len(rt.Ext))
+		for _, ext := range rt.Ext {
+			ext = strings.ToLower(ext)
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			exts = append(exts, ext)
+		}
+		rt.Ext = exts
+		var d dispatcher
+		if rt.Client != "" {
+			cli, ok := clients[rt.Client]
+			if !ok {
+				return nil, errors.Errorf("client (%s) not found", rt.Client)
+			}
+			if dispatcher(cli) != target {
+				d = cli
+			}
+		}
+		r.routes = append(r.routes, rt)
+		r.targets = append(r.targets, d)
+		r.sized = r.sized || rt.MinSize > 0 || rt.MaxSize > 0
+	}
+	return r, nil
+}
+
+// Journal routes the message, then journals it by the target of rule
+func (r *router) Journal(rule string, msg *EventMessage) (bool, error) {
+	r.route(msg)
+	return r.target.Journal(rule, msg)
+}
+
+// Resume routes the messages pending in the journal of the first client again
+func (r *router) Resume(rule string, cb ruleHook) error {
+	msgs, err := r.owner.Pending(rule)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, msg := range msgs {
+		r.log.Info("resume task from journal", log.Any("task", msg.JournalID))
+		if err = r.CallAsync(msg, cb); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// CallAsync dispatches the message to the target of the route matched, or to the target of rule
+func (r *router) CallAsync(msg *EventMessage, cb ruleHook) error {
+	target := r.route(msg)
+	if target == nil {
+		return r.target.CallAsync(msg, cb)
+	}
+	r.owner.finish(msg, TaskRunning, nil)
+	m, err := copyMessage(msg)
+	if err != nil {
+		r.owner.finish(msg, TaskFailed, err)
+		cb(msg, newResult(msg, nil, err), err)
+		return nil
+	}
+	return target.CallAsync(m, func(_ *EventMessage, res *Result, err error) {
+		if err != nil {
+			r.owner.finish(msg, TaskFailed, err)
+		} else {
+			r.owner.finish(msg, TaskSucceeded, nil)
+		}
+		cb(msg, res, err)
+	})
+}
+
+// route sets the bucket of upload event by the first route matched, and returns the target of route
+func (r *router) route(msg *EventMessage) dispatcher {
+	if msg.Event == nil || msg.Event.Type != Upload {
+		return nil
+	}
+	e, ok := msg.Event.Content.(*UploadEvent)
+	if !ok {
+		return nil
+	}
+	// the size of directory is unknown, it never matches the routes checking size
+	size := int64(-1)
+	if r.sized {
+		if fi, err := os.Stat(path.Join(r.owner.pwd, e.LocalPath)); err == nil && !fi.IsDir() {
+			size = fi.Size()
+		}
+	}
+	for i := range r.routes {
+		rt := &r.routes[i]
+		if !rt.match(msg.Topic, e, size) {
+			continue
+		}
+		e.Bucket = rt.Bucket
+		return r.targets[i]
+	}
+	return nil
+}
+
+// match checks whether the upload event of topic matches all conditions of route
+func (rt *Route) match(topic string, e *UploadEvent, size int64) bool {
+	if len(rt.Ext) > 0 {
+		local := strings.ToLower(e.LocalPath)
+		matched := false
+		for _, ext := range rt.Ext {
+			if strings.HasSuffix(local, ext) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if rt.MinSize > 0 && size < rt.MinSize {
+		return false
+	}
+	if rt.MaxSize > 0 && (size < 0 || size >= rt.MaxSize) {
+		return false
+	}
+	for k, p := range rt.Meta {
+		v, ok := e.Meta[k]
+		if !ok {
+			return false
+		}
+		if matched, _ := path.Match(p, v); !matched {
+			return false
+		}
+	}
+	if rt.Topic != "" && (topic == "" || !matchTopic(rt.Topic, topic)) {
+		return false
+	}
+	return true
+}
+
+// matchTopic checks whether the topic matches the filter with wildcards + and #
+func matchTopic(filter, topic string) bool {
+	fs, ts := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+		if i >= len(ts) || (f != "+" && f != ts[i]) {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
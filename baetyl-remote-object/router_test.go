@@ -0,0 +1,168 @@
+package main
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/panjf2000/ants"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchTopic(t *testing.T) {
+	assert.True(t, matchTopic("a/b", "a/b"))
+	assert.True(t, matchTopic("a/+", "a/b"))
+	assert.True(t, matchTopic("a/#", "a"))
+	assert.True(t, matchTopic("a/#", "a/b/c"))
+	assert.True(t, matchTopic("+/+/c", "a/b/c"))
+	assert.False(t, matchTopic("a/+", "a/b/c"))
+	assert.False(t, matchTopic("a/b/c", "a/b"))
+	assert.False(t, matchTopic("a/c", "a/b"))
+}
+
+func TestRouteMatch(t *testing.T) {
+	var target struct {
+		Routes []Route `yaml:"routes"`
+	}
+	assert.NoError(t, utils.UnmarshalYAML([]byte(`
+routes:
+- ext: [mp4, .MOV]
+  minSize: 100m
+  client: cold
+- meta:
+    camera: "front-*"
+  topic: video/+/upload
+  maxSize: 1k
+  bucket: front
+`), &target))
+	routes := target.Routes
+	assert.Len(t, routes, 2)
+	assert.Equal(t, int64(100*1024*1024), routes[0].MinSize)
+	assert.Equal(t, int64(1024), routes[1].MaxSize)
+
+	r, err := newRouter(routes, nil, nil, map[string]*Client{"cold": {}}, "rule")
+	assert.NoError(t, err)
+	video := &UploadEvent{LocalPath: "a/b.MP4"}
+	assert.True(t, r.routes[0].match("", video, 100*1024*1024))
+	assert.False(t, r.routes[0].match("", video, 1024))
+	assert.False(t, r.routes[0].match("", video, -1))
+	assert.True(t, r.routes[0].match("", &UploadEvent{LocalPath: "a/b.mov"}, 200*1024*1024))
+	assert.False(t, r.routes[0].match("", &UploadEvent{LocalPath: "a/b.jpg"}, 200*1024*1024))
+
+	front := &UploadEvent{LocalPath: "a.jpg", Meta: map[string]string{"camera": "front-1"}}
+	assert.True(t, r.routes[1].match("video/1/upload", front, 10))
+	assert.False(t, r.routes[1].match("video/1/upload", front, 1024))
+	assert.False(t, r.routes[1].match("video/1/upload", front, -1))
+	assert.False(t, r.routes[1].match("", front, 10))
+	assert.False(t, r.routes[1].match("video/1/upload", &UploadEvent{LocalPath: "a.jpg"}, 10))
+	assert.False(t, r.routes[1].match("video/1/upload", &UploadEvent{LocalPath: "a.jpg", Meta: map[string]string{"camera": "back-1"}}, 10))
+
+	// routes invalid
+	for _, rt := range []Route{
+		{Ext: []string{".mp4"}},
+		{Client: "none"},
+		{Bucket: "b", MinSize: 10, MaxSize: 10},
+		{Bucket: "b", Topic: "a/#/b"},
+		{Bucket: "b", Meta: map[string]string{"k": "["}},
+	} {
+		_, err = newRouter([]Route{rt}, nil, nil, map[string]*Client{"cold": {}}, "rule")
+		assert.Error(t, err)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	var clients []*Client
+	var handlers []*mockHandler
+	for _, name := range []string{"a", "b"} {
+		cli, h := newMockClient(t)
+		cli.cfg.Name = name
+		pool, err := ants.NewPoolWithFunc(cli.cfg.Pool.Worker, cli.call)
+		assert.NoError(t, err)
+		defer pool.Release()
+		cli.pool = pool
+		clients = append(clients, cli)
+		handlers = append(handlers, h)
+	}
+	pwd := t.TempDir()
+	for _, cli := range clients {
+		cli.pwd = pwd
+	}
+	assert.NoError(t, ioutil.WriteFile(path.Join(pwd, "big.mp4"), make([]byte, 2048), 0644))
+	assert.NoError(t, ioutil.WriteFile(path.Join(pwd, "small.mp4"), make([]byte, 10), 0644))
+	assert.NoError(t, ioutil.WriteFile(path.Join(pwd, "a.jpg"), make([]byte, 10), 0644))
+	assert.NoError(t, ioutil.WriteFile(path.Join(pwd, "a.txt"), make([]byte, 10), 0644))
+	var err error
+	clients[0].cfg.Journal = Journal{Enable: true, Path: path.Join(t.TempDir(), "journal"), Retention: time.Hour}
+	clients[0].journal, err = newJournal(clients[0].cfg.Journal, "a")
+	assert.NoError(t, err)
+
+	r, err := newRouter([]Route{
+		{Ext: []string{".mp4"}, MinSize: 1024, Client: "b", Bucket: "cold"},
+		{Ext: []string{".jpg"}, Bucket: "cdn"},
+		{Ext: []string{".mp4"}, Client: "a"},
+	}, clients[0], clients[0], map[string]*Client{"a": clients[0], "b": clients[1]}, "rule")
+	assert.NoError(t, err)
+	assert.Equal(t, []dispatcher{clients[1], nil, nil}, r.targets)
+
+	call := func(local string) (*EventMessage, *Result) {
+		msg := &EventMessage{
+			Event: &Event{
+				Type:    Upload,
+				Content: &UploadEvent{RemotePath: "x/" + local, LocalPath: local},
+			},
+		}
+		_, err := r.Journal("rule", msg)
+		assert.NoError(t, err)
+		results := make(chan *Result, 1)
+		assert.NoError(t, r.CallAsync(msg, func(msg *EventMessage, res *Result, err error) {
+			assert.NoError(t, err)
+			results <- res
+		}))
+		select {
+		case res := <-results:
+			return msg, res
+		case <-time.After(3 * time.Second):
+			t.Fatal("router not finished")
+		}
+		return nil, nil
+	}
+
+	// round 1: the big video is routed to the cold bucket of client b, and journaled by client a
+	msg, res := call("big.mp4")
+	assert.Equal(t, "cold", res.Bucket)
+	assert.Equal(t, "cold", msg.Event.Content.(*UploadEvent).Bucket)
+	assert.True(t, utils.FileExists(path.Join(handlers[1].dir, "cold", "x", "big.mp4")))
+	assert.Equal(t, TaskSucceeded, clients[0].journal.index[msg.JournalID].State)
+
+	// round 2: the image is routed to the cdn bucket of client a
+	msg, res = call("a.jpg")
+	assert.Equal(t, "cdn", res.Bucket)
+	assert.True(t, utils.FileExists(path.Join(handlers[0].dir, "cdn", "x", "a.jpg")))
+	assert.Equal(t, TaskSucceeded, clients[0].journal.index[msg.JournalID].State)
+
+	// round 3: the small video and others are uploaded to the bucket of client a
+	_, res = call("small.mp4")
+	assert.Equal(t, "bucket", res.Bucket)
+	_, res = call("a.txt")
+	assert.Equal(t, "bucket", res.Bucket)
+	assert.True(t, utils.FileExists(path.Join(handlers[0].dir, "bucket", "x", "small.mp4")))
+	assert.True(t, utils.FileExists(path.Join(handlers[0].dir, "bucket", "x", "a.txt")))
+	assert.Equal(t, 1, handlers[1].puts)
+	assert.Equal(t, 3, handlers[0].puts)
+
+	// round 4: the bucket in the payload is ignored, and the bucket of route is always applied
+	e, err := NewEvent([]byte(`{"type":"UPLOAD","content":{"remotePath":"x/a.txt","localPath":"a.txt","bucket":"other"}}`))
+	assert.NoError(t, err)
+	assert.Empty(t, e.Content.(*UploadEvent).Bucket)
+	msg = &EventMessage{Event: &Event{Type: Upload, Content: &UploadEvent{RemotePath: "x/big.mp4", LocalPath: "big.mp4", Bucket: "other"}}}
+	r.route(msg)
+	assert.Equal(t, "cold", msg.Event.Content.(*UploadEvent).Bucket)
+	msg.Event.Content.(*UploadEvent).LocalPath = "small.mp4"
+	r.route(msg)
+	assert.Empty(t, msg.Event.Content.(*UploadEvent).Bucket)
+	m, err := copyMessage(&EventMessage{Event: &Event{Type: Upload, Content: &UploadEvent{Bucket: "cold"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, "cold", m.Event.Content.(*UploadEvent).Bucket)
+}
@@ -81,13 +81,18 @@ func TestRule(t *testing.T) {
 		Name: "",
 		Source: struct {
 			QOS   uint32 `yaml:"qos" json:"qos" validate:"min=0, max=1"`
-			Topic string `yaml:"topic" json:"topic" validate:"nonzero"`
+			Topic string `yaml:"topic" json:"topic"`
+			Watch *Watch `yaml:"watch" json:"watch"`
 		}{
 			QOS:   1,
 			Topic: "t1",
 		},
 		Target: struct {
-			Client string `yaml:"client" json:"client" default:"baetyl-sts"`
+			Client       string   `yaml:"client" json:"client" default:"baetyl-sts"`
+			Clients      []string `yaml:"clients" json:"clients"`
+			Policy       string   `yaml:"policy" json:"policy" default:"all"`
+			PathTemplate string   `yaml:"pathTemplate" json:"pathTemplate"`
+			Routes       []Route  `yaml:"routes" json:"routes"`
 		}{
 			Client: "cli1",
 		},
@@ -109,3 +114,41 @@ func TestRule(t *testing.T) {
 	time.Sleep(time.Second)
 	ruler.Close()
 }
+
+func TestRulerRender(t *testing.T) {
+	cli, _ := newMockClient(t)
+	tpl, err := newPathTemplate(`{{.TopicSegment 1}}/{{.Date "2006"}}/{{.RemotePath}}`, nil)
+	assert.NoError(t, err)
+	r := &Ruler{targetCli: cli, tpl: tpl}
+
+	msg := &EventMessage{
+		Topic: "device/d1/upload",
+		Event: &Event{
+			Time:    time.Date(2021, 3, 4, 12, 0, 0, 0, time.Local),
+			Type:    Upload,
+			Content: &UploadEvent{RemotePath: "a.log", LocalPath: "var/log/a.log"},
+		},
+	}
+	assert.NoError(t, r.render(msg))
+	assert.Equal(t, "d1/2021/a.log", msg.Event.Content.(*UploadEvent).RemotePath)
+
+	// the other events are not rendered
+	msg = &EventMessage{
+		Topic: "device/d1/download",
+		Event: &Event{
+			Type:    Download,
+			Content: &DownloadEvent{RemotePath: "a.log", LocalPath: "var/log/a.log"},
+		},
+	}
+	assert.NoError(t, r.render(msg))
+	assert.Equal(t, "a.log", msg.Event.Content.(*DownloadEvent).RemotePath)
+
+	msg = &EventMessage{
+		Topic: "device",
+		Event: &Event{
+			Type:    Upload,
+			Content: &UploadEvent{RemotePath: "a.log", LocalPath: "var/log/a.log"},
+		},
+	}
+	assert.Error(t, r.render(msg))
+}
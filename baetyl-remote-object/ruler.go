@@ -1,7 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"path"
+	"strings"
 	"sync"
 
 	"github.com/256dpi/gomqtt/packet"
@@ -16,24 +19,74 @@ type Ruler struct {
 	ctx       context.Context
 	info      RuleInfo
 	sourceCli *mqtt.Client
-	targetCli *Client
+	watcher   *watcher
+	targetCli *Client    // the first target client
+	target    dispatcher // the target client, the fanout of multiple target clients, or the router of routes
+	tpl       *pathTemplate
 	log       *log.Logger
 	tm        sync.Map
 }
 
 // NewRuler can create a ruler
 func NewRuler(ctx context.Context, rule RuleInfo, targets map[string]*Client) (*Ruler, error) {
-	targetCli, ok := targets[rule.Target.Client]
-	if !ok {
-		return nil, errors.Errorf("client (%s) not found", rule.Target.Client)
+	names := rule.Target.Clients
+	if len(names) == 0 {
+		names = []string{rule.Target.Client}
+	}
+	var clients []*Client
+	for i, name := range names {
+		cli, ok := targets[name]
+		if !ok {
+			return nil, errors.Errorf("client (%s) not found", name)
+		}
+		for _, n := range names[:i] {
+			if n == name {
+				return nil, errors.Errorf("client (%s) of rule (%s) duplicated", name, rule.Name)
+			}
+		}
+		clients = append(clients, cli)
 	}
+	targetCli := clients[0]
 
 	ruler := &Ruler{
 		ctx:       ctx,
 		info:      rule,
 		targetCli: targetCli,
+		target:    targetCli,
 		log:       log.With(log.Any("rule", rule.Name)),
 	}
+	if len(clients) > 1 {
+		fan, err := newFanout(rule.Target.Policy, clients, rule.Name)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		ruler.target = fan
+	}
+	if len(rule.Target.Routes) > 0 {
+		rt, err := newRouter(rule.Target.Routes, ruler.target, targetCli, targets, rule.Name)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		ruler.target = rt
+	}
+	// the template of rule overrides the one of client
+	ruler.tpl = targetCli.tpl
+	if rule.Target.PathTemplate != "" {
+		tpl, err := newPathTemplate(rule.Target.PathTemplate, ctx)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		ruler.tpl = tpl
+	}
+	if rule.Source.Watch != nil {
+		if err := ruler.startWatcher(); err != nil {
+			return nil, errors.Trace(err)
+		}
+		return ruler, nil
+	}
+	if rule.Source.Topic == "" {
+		return nil, errors.Errorf("source topic of rule (%s) is required", rule.Name)
+	}
 	mqttCli, err := ruler.getBrokerClient(ctx)
 	if err != nil {
 		return nil, errors.Trace(err)
@@ -64,6 +117,10 @@ func NewRuler(ctx context.Context, rule RuleInfo, targets map[string]*Client) (*
 	if err != nil {
 		ruler.log.Error("error occurred when mqtt client start", log.Error(err))
 	}
+	err = ruler.target.Resume(rule.Name, ruler.callback)
+	if err != nil {
+		ruler.log.Error("error occurred when resume tasks", log.Error(err))
+	}
 	return ruler, nil
 }
 
@@ -76,6 +133,24 @@ func (r *Ruler) Close() {
 	if r.sourceCli != nil {
 		r.sourceCli.Close()
 	}
+	if r.watcher != nil {
+		r.watcher.close()
+	}
+}
+
+// startWatcher watches the local directories as the source, the results are not published without mqtt client
+func (r *Ruler) startWatcher() error {
+	w, err := newWatcher(r.ctx, *r.info.Source.Watch, r.info.Name, r.targetCli, r.callback)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	w.target = r.target
+	r.watcher = w
+	err = w.resume()
+	if err != nil {
+		r.log.Error("error occurred when resume tasks", log.Error(err))
+	}
+	return errors.Trace(w.start())
 }
 
 func (r *Ruler) processEvent(pkt *packet.Publish) (*Event, error) {
@@ -89,6 +164,29 @@ func (r *Ruler) processEvent(pkt *packet.Publish) (*Event, error) {
 
 // RuleHandler filter topic & handler
 func (r *Ruler) RuleHandler(msg *EventMessage) error {
+	err := r.render(msg)
+	if err != nil {
+		r.callback(msg, newResult(msg, nil, err), err)
+		return errors.Trace(err)
+	}
+	// the message journaled is acknowledged at once, the task is resumed from journal after restart
+	journaled, err := r.target.Journal(r.info.Name, msg)
+	if errors.Cause(err) == errTaskDuplicated {
+		// the message redelivered is pending in journal, only acknowledged again
+		if msg.QOS == 1 {
+			r.puback(msg)
+		}
+		return nil
+	}
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if journaled {
+		if msg.QOS == 1 {
+			r.puback(msg)
+		}
+		return r.target.CallAsync(msg, r.callback)
+	}
 	if msg.QOS == 1 {
 		if _, ok := r.tm.Load(msg.ID); !ok {
 			r.tm.Store(msg.ID, struct{}{})
@@ -96,24 +194,76 @@ func (r *Ruler) RuleHandler(msg *EventMessage) error {
 			return nil
 		}
 	}
-	return r.targetCli.CallAsync(msg, r.callback)
+	return r.target.CallAsync(msg, r.callback)
 }
 
-func (r *Ruler) callback(msg *EventMessage, err error) {
-	if msg.QOS == 1 {
+// render renders the remote path of upload event by the template of rule or client
+func (r *Ruler) render(msg *EventMessage) error {
+	if r.tpl == nil || msg.Event == nil || msg.Event.Type != Upload {
+		return nil
+	}
+	e, ok := msg.Event.Content.(*UploadEvent)
+	if !ok {
+		return errors.New("failed to convert interface{} to *UploadEvent")
+	}
+	if strings.Contains(e.LocalPath, "..") {
+		return errors.Errorf("failed to pass LocalPath (%s) check: the local path can't contains ..", e.LocalPath)
+	}
+	vars := newPathVars(r.targetCli.pwd, e.LocalPath, path.Clean(e.LocalPath))
+	vars.Topic = msg.Topic
+	vars.RemotePath = e.RemotePath
+	vars.Meta = e.Meta
+	if !msg.Event.Time.IsZero() {
+		vars.Time = msg.Event.Time
+	}
+	remotePath, err := r.tpl.render(vars)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	e.RemotePath = remotePath
+	return nil
+}
+
+func (r *Ruler) callback(msg *EventMessage, res *Result, err error) {
+	if msg.QOS == 1 && msg.JournalID == "" {
 		if err == nil {
-			puback := packet.NewPuback()
-			puback.ID = packet.ID(msg.ID)
-			err := r.sourceCli.Send(puback)
-			if err != nil {
-				r.log.Error("failed to send mqtt msg", log.Error(err))
-			}
+			r.puback(msg)
 		}
 		r.tm.Delete(msg.ID)
 	}
 	if err != nil {
 		r.log.Error("failed to invoke object client", log.Error(err))
 	}
+	r.reply(msg, res)
+}
+
+// reply publishes the result to the reply topic of event or rule
+func (r *Ruler) reply(msg *EventMessage, res *Result) {
+	topic := r.info.Reply.Topic
+	if msg.Event != nil && msg.Event.ReplyTopic != "" {
+		topic = msg.Event.ReplyTopic
+	}
+	if topic == "" || res == nil || r.sourceCli == nil {
+		return
+	}
+	payload, err := json.Marshal(res)
+	if err != nil {
+		r.log.Error("failed to marshal result", log.Error(err))
+		return
+	}
+	err = r.sourceCli.Publish(mqtt.QOS(r.info.Reply.QOS), topic, payload, 0, false, false)
+	if err != nil {
+		r.log.Error("failed to publish result", log.Any("topic", topic), log.Error(err))
+	}
+}
+
+func (r *Ruler) puback(msg *EventMessage) {
+	puback := packet.NewPuback()
+	puback.ID = packet.ID(msg.ID)
+	err := r.sourceCli.Send(puback)
+	if err != nil {
+		r.log.Error("failed to send mqtt msg", log.Error(err))
+	}
 }
 
 func (r *Ruler) getBrokerClient(ctx context.Context) (*mqtt.Client, error) {
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	v1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/docker/distribution/uuid"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPHandler stores objects in the directory of sftp server, the object is stored in <root>/<bucket>/<remotePath>
+// and its meta in the sidecar <root>/<bucket>/<remotePath>.meta.json
+type SFTPHandler struct {
+	addr       string
+	root       string // relative to the home of user if empty
+	ssh        *ssh.ClientConfig
+	conn       *sftpConn
+	cfg        ClientInfo
+	throttlers []*throttler
+	log        *log.Logger
+	lock       sync.Mutex
+}
+
+// sftpConn the sftp client over the ssh connection
+type sftpConn struct {
+	net  *activeConn
+	ssh  *ssh.Client
+	sftp *sftp.Client
+}
+
+// activeConn records the last time data received from the connection
+type activeConn struct {
+	net.Conn
+	last int64
+}
+
+func (c *activeConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.touch()
+	}
+	return n, err
+}
+
+func (c *activeConn) touch() {
+	atomic.StoreInt64(&c.last, time.Now().UnixNano())
+}
+
+// idle returns the duration since data received last time
+func (c *activeConn) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&c.last)))
+}
+
+// NewSFTPHandler creates a new sftp handler, the endpoint is sftp://<host>[:port][/root], ak is the user,
+// sk is the password, or the passphrase of the private key in credentials if it is encrypted,
+// the host key is checked by the known_hosts file
+func NewSFTPHandler(cfg ClientInfo) (StorageHandler, error) {
+	endpoint := cfg.Endpoint
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "sftp://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Hostname() == "" {
+		return nil, errors.Errorf("failed to create sftp client (%s): endpoint (%s) invalid", cfg.Name, cfg.Endpoint)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	if cfg.KnownHosts == "" {
+		return nil, errors.Errorf("failed to create sftp client (%s): knownHosts is required to check the host key", cfg.Name)
+	}
+	hostKey, err := knownhosts.New(cfg.KnownHosts)
+	if err != nil {
+		return nil, errors.Errorf("failed to create sftp client (%s): %s", cfg.Name, err.Error())
+	}
+	var auths []ssh.AuthMethod
+	if cfg.Credentials != "" {
+		data, err := ioutil.ReadFile(cfg.Credentials)
+		if err != nil {
+			return nil, errors.Errorf("failed to create sftp client (%s): %s", cfg.Name, err.Error())
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if _, ok := err.(*ssh.PassphraseMissingError); ok && cfg.Sk != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(cfg.Sk))
+		}
+		if err != nil {
+			return nil, errors.Errorf("failed to create sftp client (%s): %s", cfg.Name, err.Error())
+		}
+		auths = append(auths, ssh.PublicKeys(signer))
+	} else if cfg.Sk != "" {
+		auths = append(auths, ssh.Password(cfg.Sk))
+	} else {
+		return nil, errors.Errorf("failed to create sftp client (%s): sk or credentials is required", cfg.Name)
+	}
+	return &SFTPHandler{
+		addr: addr,
+		root: strings.TrimSuffix(u.Path, "/"),
+		ssh: &ssh.ClientConfig{
+			User:            cfg.Ak,
+			Auth:            auths,
+			HostKeyCallback: hostKey,
+			Timeout:         cfg.Timeout,
+		},
+		cfg:        cfg,
+		throttlers: []*throttler{newThrottler(cfg.Throttle), globalThrottler},
+		log:        log.With(log.Any("storage", "sftp")),
+	}, nil
+}
+
+// PutObjectFromFile uploads file to a temp file beside the object, then renames it, returns the md5 in hex as etag
+func (cli *SFTPHandler) PutObjectFromFile(Bucket, remotePath, filename string, meta map[string]string) (string, error) {
+	object, err := cli.object(Bucket, remotePath)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	defer f.Close()
+	var fm *FileMeta
+	err = cli.do(func(c *sftp.Client) error {
+		if err := c.MkdirAll(path.Dir(object)); err != nil {
+			return errors.Trace(err)
+		}
+		h := md5.New()
+		size, err := cli.put(c, object, io.TeeReader(throttled(f, cli.throttlers...), h))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		fm = &FileMeta{
+			Size:         size,
+			MD5:          hex.EncodeToString(h.Sum(nil)),
+			Meta:         meta,
+			LastModified: time.Now(),
+		}
+		data, err := json.Marshal(fm)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		_, err = cli.put(c, object+sidecarSuffix, bytes.NewReader(data))
+		return errors.Trace(err)
+	})
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return fm.MD5, nil
+}
+
+// GetObjectToFile download file
+func (cli *SFTPHandler) GetObjectToFile(Bucket, remotePath, filename string) error {
+	object, err := cli.object(Bucket, remotePath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	dst, err := os.OpenFile(filename, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer dst.Close()
+	return cli.do(func(c *sftp.Client) error {
+		_, err := cli.get(c, object, dst)
+		return errors.Trace(err)
+	})
+}
+
+// DeleteObject removes object and its sidecar meta
+func (cli *SFTPHandler) DeleteObject(Bucket, remotePath string) error {
+	object, err := cli.object(Bucket, remotePath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return cli.do(func(c *sftp.Client) error {
+		for _, p := range []string{object, object + sidecarSuffix} {
+			if err := c.Remove(p); err != nil && !os.IsNotExist(err) {
+				return errors.Trace(err)
+			}
+		}
+		return nil
+	})
+}
+
+// FileExists reports whether the object exists in the size and md5 stored in its sidecar meta
+func (cli *SFTPHandler) FileExists(Bucket, remotePath, md5 string) bool {
+	object, err := cli.object(Bucket, remotePath)
+	if err != nil {
+		return false
+	}
+	var ok bool
+	err = cli.do(func(c *sftp.Client) error {
+		st, err := c.Stat(object)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		var buf bytes.Buffer
+		if _, err = cli.get(c, object+sidecarSuffix, &buf); err != nil {
+			return errors.Trace(err)
+		}
+		var fm FileMeta
+		if err = json.Unmarshal(buf.Bytes(), &fm); err != nil {
+			return errors.Trace(err)
+		}
+		ok = fm.matches(st.Size(), md5)
+		return nil
+	})
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		cli.log.Warn("failed to get object meta", log.Error(err))
+	}
+	return ok
+}
+
+func (cli *SFTPHandler) RefreshSts() (*v1.STSResponse, error) {
+	return nil, nil
+}
+
+// object returns the remote path of object under the root
+func (cli *SFTPHandler) object(Bucket, remotePath string) (string, error) {
+	p, err := objectPath(Bucket, remotePath)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if cli.root == "" {
+		return p, nil
+	}
+	return cli.root + "/" + p, nil
+}
+
+// do runs the function with the sftp client which is connected if not yet,
+// the connection is closed if failed not by the status of sftp, then reconnected next time.
+// The connection is also closed if nothing received in the timeout while running, so a stalled server fails the function
+func (cli *SFTPHandler) do(fn func(c *sftp.Client) error) error {
+	c, err := cli.client()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if timeout := cli.ssh.Timeout; timeout > 0 {
+		c.net.touch()
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			t := time.NewTicker(timeout / 4)
+			defer t.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-t.C:
+					if c.net.idle() >= timeout {
+						cli.log.Warn("sftp server not responding, to close the connection", log.Any("addr", cli.addr))
+						cli.reset(c)
+						return
+					}
+				}
+			}
+		}()
+	}
+	err = fn(c.sftp)
+	if err != nil && !isSFTPStatus(err) {
+		cli.reset(c)
+	}
+	return err
+}
+
+func (cli *SFTPHandler) client() (*sftpConn, error) {
+	cli.lock.Lock()
+	defer cli.lock.Unlock()
+	if cli.conn != nil {
+		return cli.conn, nil
+	}
+	nc, err := net.DialTimeout("tcp", cli.addr, cli.ssh.Timeout)
+	if err != nil {
+		return nil, errors.Errorf("failed to connect sftp server (%s): %s", cli.addr, err.Error())
+	}
+	conn := &activeConn{Conn: nc}
+	if cli.ssh.Timeout > 0 {
+		// the deadline bounds the handshake only
+		nc.SetDeadline(time.Now().Add(cli.ssh.Timeout))
+	}
+	sc, chans, reqs, err := ssh.NewClientConn(conn, cli.addr, cli.ssh)
+	if err != nil {
+		nc.Close()
+		return nil, errors.Errorf("failed to connect sftp server (%s): %s", cli.addr, err.Error())
+	}
+	nc.SetDeadline(time.Time{})
+	client := ssh.NewClient(sc, chans, reqs)
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		client.Close()
+		return nil, errors.Errorf("failed to start sftp subsystem (%s): %s", cli.addr, err.Error())
+	}
+	cli.conn = &sftpConn{net: conn, ssh: client, sftp: c}
+	return cli.conn, nil
+}
+
+// reset closes the connection of the client if it is still in use
+func (cli *SFTPHandler) reset(c *sftpConn) {
+	cli.lock.Lock()
+	defer cli.lock.Unlock()
+	if cli.conn != c {
+		return
+	}
+	// the sftp client waits for the pending responses until the connection closed
+	c.ssh.Close()
+	c.sftp.Close()
+	cli.conn = nil
+}
+
+// put writes the content to a temp file beside the file, then renames it to make the write atomic
+func (cli *SFTPHandler) put(c *sftp.Client, file string, r io.Reader) (int64, error) {
+	t := path.Join(path.Dir(file), "."+path.Base(file)+"."+uuid.Generate().String())
+	f, err := c.OpenFile(t, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	n, err := io.Copy(f, r)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = rename(c, t, file)
+	}
+	if err != nil {
+		c.Remove(t)
+		return 0, errors.Trace(err)
+	}
+	return n, nil
+}
+
+// get reads the remote file to the writer
+func (cli *SFTPHandler) get(c *sftp.Client, file string, w io.Writer) (int64, error) {
+	f, err := c.Open(file)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer f.Close()
+	n, err := io.Copy(w, f)
+	return n, errors.Trace(err)
+}
+
+// rename overwrites the target, which is rejected by the rename of sftp version 3 without the posix extension
+func rename(c *sftp.Client, oldpath, newpath string) error {
+	if _, ok := c.HasExtension("posix-rename@openssh.com"); ok {
+		return errors.Trace(c.PosixRename(oldpath, newpath))
+	}
+	err := c.Remove(newpath)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Trace(err)
+	}
+	return errors.Trace(c.Rename(oldpath, newpath))
+}
+
+// isSFTPStatus reports whether the error is the status responded by the server, so the connection is still usable
+func isSFTPStatus(err error) bool {
+	err = errors.Cause(err)
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	}
+	if _, ok := err.(*sftp.StatusError); ok {
+		return true
+	}
+	return err == os.ErrNotExist || err == os.ErrPermission
+}
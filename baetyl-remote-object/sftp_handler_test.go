@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// mockSFTP a ssh server with the sftp subsystem serving the local files, the responses are dropped if stalled
+type mockSFTP struct {
+	lis     net.Listener
+	config  *ssh.ServerConfig
+	key     ssh.Signer
+	stalled int32
+}
+
+func newMockSFTP(t *testing.T) *mockSFTP {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	key, err := ssh.NewSignerFromKey(priv)
+	assert.NoError(t, err)
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "user" && string(pass) == "pass" {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	config.AddHostKey(key)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	m := &mockSFTP{lis: lis, config: config, key: key}
+	go m.serve()
+	return m
+}
+
+func (m *mockSFTP) serve() {
+	for {
+		conn, err := m.lis.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			_, chans, reqs, err := ssh.NewServerConn(conn, m.config)
+			if err != nil {
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			for nc := range chans {
+				ch, reqs, err := nc.Accept()
+				if err != nil {
+					continue
+				}
+				go func() {
+					for req := range reqs {
+						ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+						req.Reply(ok, nil)
+						if !ok {
+							continue
+						}
+						s, err := sftp.NewServer(&stallChannel{Channel: ch, m: m})
+						if err != nil {
+							ch.Close()
+							continue
+						}
+						go s.Serve()
+					}
+				}()
+			}
+		}()
+	}
+}
+
+// stallChannel drops the data written if the server is stalled
+type stallChannel struct {
+	ssh.Channel
+	m *mockSFTP
+}
+
+func (c *stallChannel) Write(b []byte) (int, error) {
+	if atomic.LoadInt32(&c.m.stalled) == 1 {
+		return len(b), nil
+	}
+	return c.Channel.Write(b)
+}
+
+func TestSFTPHandler(t *testing.T) {
+	dir := t.TempDir()
+	m := newMockSFTP(t)
+	defer m.lis.Close()
+	assert.NoError(t, os.MkdirAll(path.Join(dir, "server", "data"), 0755))
+	knownHosts := path.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{m.lis.Addr().String()}, m.key.PublicKey())
+	assert.NoError(t, ioutil.WriteFile(knownHosts, []byte(line+"\n"), 0644))
+
+	c := *cfg
+	c.Kind = SFTP
+	c.Endpoint = "sftp://" + m.lis.Addr().String() + path.Join(dir, "server", "data")
+	c.Ak = "user"
+	c.Sk = "pass"
+	c.KnownHosts = knownHosts
+	h, err := NewObjectStorageHandler(nil, c)
+	assert.NoError(t, err)
+
+	small := path.Join(dir, "small.txt")
+	assert.NoError(t, ioutil.WriteFile(small, []byte("abc"), 0644))
+	sum, err := utils.CalculateFileMD5(small)
+	assert.NoError(t, err)
+
+	// round 1: put object in the directories created
+	assert.False(t, h.FileExists("bucket", "a/b/small.txt", sum))
+	etag, err := h.PutObjectFromFile("bucket", "a/b/small.txt", small, map[string]string{"k": "v"})
+	assert.NoError(t, err)
+	assert.Equal(t, sum, etag)
+	data, err := ioutil.ReadFile(path.Join(dir, "server", "data", "bucket", "a", "b", "small.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", string(data))
+	assert.True(t, utils.FileExists(path.Join(dir, "server", "data", "bucket", "a", "b", "small.txt"+sidecarSuffix)))
+	assert.True(t, h.FileExists("bucket", "a/b/small.txt", sum))
+	assert.False(t, h.FileExists("bucket", "a/b/small.txt", "0123"))
+
+	// round 2: overwrite object
+	assert.NoError(t, ioutil.WriteFile(small, []byte("abcd"), 0644))
+	sum, err = utils.CalculateFileMD5(small)
+	assert.NoError(t, err)
+	_, err = h.PutObjectFromFile("bucket", "a/b/small.txt", small, nil)
+	assert.NoError(t, err)
+	assert.True(t, h.FileExists("bucket", "a/b/small.txt", sum))
+
+	// round 3: get and delete object
+	dst := path.Join(dir, "dst.txt")
+	assert.NoError(t, h.GetObjectToFile("bucket", "a/b/small.txt", dst))
+	data, err = ioutil.ReadFile(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, "abcd", string(data))
+	assert.Error(t, h.GetObjectToFile("bucket", "a/none.txt", dst))
+	assert.NoError(t, h.DeleteObject("bucket", "a/b/small.txt"))
+	assert.NoError(t, h.DeleteObject("bucket", "a/b/small.txt"))
+	assert.False(t, h.FileExists("bucket", "a/b/small.txt", sum))
+	_, err = h.PutObjectFromFile("bucket", "../small.txt", small, nil)
+	assert.Error(t, err)
+
+	// round 4: wrong password or unknown host key
+	c.Sk = "wrong"
+	h, err = NewObjectStorageHandler(nil, c)
+	assert.NoError(t, err)
+	_, err = h.PutObjectFromFile("bucket", "a/small.txt", small, nil)
+	assert.Error(t, err)
+
+	c.Sk = "pass"
+	assert.NoError(t, ioutil.WriteFile(knownHosts, nil, 0644))
+	h, err = NewObjectStorageHandler(nil, c)
+	assert.NoError(t, err)
+	_, err = h.PutObjectFromFile("bucket", "a/small.txt", small, nil)
+	assert.Error(t, err)
+
+	c.KnownHosts = ""
+	_, err = NewObjectStorageHandler(nil, c)
+	assert.Error(t, err)
+
+	// round 5: the connection to the server stalled is closed in the timeout, then reconnected
+	assert.NoError(t, ioutil.WriteFile(knownHosts, []byte(line+"\n"), 0644))
+	c.KnownHosts = knownHosts
+	c.Timeout = 200 * time.Millisecond
+	h, err = NewObjectStorageHandler(nil, c)
+	assert.NoError(t, err)
+	_, err = h.PutObjectFromFile("bucket", "a/small.txt", small, nil)
+	assert.NoError(t, err)
+	atomic.StoreInt32(&m.stalled, 1)
+	start := time.Now()
+	_, err = h.PutObjectFromFile("bucket", "a/small.txt", small, nil)
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(2*time.Second))
+	atomic.StoreInt32(&m.stalled, 0)
+	_, err = h.PutObjectFromFile("bucket", "a/small.txt", small, nil)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dm "github.com/baetyl/baetyl-go/v2/context"
+	"github.com/baetyl/baetyl-go/v2/errors"
+	bhttp "github.com/baetyl/baetyl-go/v2/http"
+	"github.com/baetyl/baetyl-go/v2/log"
+	v1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/baetyl/baetyl-go/v2/utils"
+)
+
+// accessKey the access key of object storage, the token is set if temporary
+type accessKey struct {
+	ak    string
+	sk    string
+	token string
+}
+
+// signer signs the request of object storage
+type signer interface {
+	sign(req *http.Request, Bucket, remotePath string, creds accessKey)
+}
+
+// SignedHandler stores objects by the rest api similar to s3 of native object storages, such as OSS and COS,
+// the bucket is accessed by virtual host, and the request is signed by the signer of storage
+type SignedHandler struct {
+	rest       *restClient
+	endpoint   *url.URL
+	signer     signer
+	metaPrefix string
+	core       *bhttp.Client // the client of baetyl core to get temporary credentials, nil if sts is not set
+	creds      accessKey
+	deadline   time.Time
+	cfg        ClientInfo
+	resumer    *resumer
+	throttlers []*throttler
+	log        *log.Logger
+	lock       sync.RWMutex
+}
+
+func newSignedHandler(ctx dm.Context, cfg ClientInfo, s signer, metaPrefix string) (*SignedHandler, error) {
+	endpoint := cfg.Endpoint
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil || u.Host == "" {
+		return nil, errors.Errorf("failed to create %s client (%s): endpoint (%s) invalid", strings.ToLower(string(cfg.Kind)), cfg.Name, cfg.Endpoint)
+	}
+	rest, err := newRESTClient(cfg)
+	if err != nil {
+		return nil, errors.Errorf("failed to create %s client (%s): %s", strings.ToLower(string(cfg.Kind)), cfg.Name, err.Error())
+	}
+	h := &SignedHandler{
+		rest:       rest,
+		endpoint:   u,
+		signer:     s,
+		metaPrefix: metaPrefix,
+		creds:      accessKey{ak: cfg.Ak, sk: cfg.Sk, token: cfg.Token},
+		cfg:        cfg,
+		throttlers: []*throttler{newThrottler(cfg.Throttle), globalThrottler},
+		log:        log.With(log.Any("storage", strings.ToLower(string(cfg.Kind)))),
+	}
+	if cfg.Sts != "" {
+		h.core, err = ctx.NewCoreHttpClient()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	h.resumer = newResumer(cfg.MultiPart, h, h.log)
+	return h, nil
+}
+
+// PutObjectFromFile upload file, the file larger than part size is uploaded in parts, returns the etag
+func (cli *SignedHandler) PutObjectFromFile(Bucket, remotePath, filename string, meta map[string]string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if cli.cfg.MultiPart.PartSize > 0 && fi.Size() > cli.cfg.MultiPart.PartSize {
+		return cli.resumer.upload(Bucket, remotePath, filename, meta)
+	}
+	md5, err := utils.CalculateFileMD5(filename)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	sum, err := hex.DecodeString(md5)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	req, err := cli.request(http.MethodPut, Bucket, remotePath, nil, cli.body(f, 0, fi.Size()), fi.Size())
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum))
+	cli.setMeta(req, meta)
+	header, err := cli.send(req, Bucket, remotePath)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return strings.Trim(header.Get("ETag"), "\""), nil
+}
+
+// InitMultipartUpload initiates a multipart upload with the meta
+func (cli *SignedHandler) InitMultipartUpload(Bucket, remotePath string, meta map[string]string) (string, error) {
+	req, err := cli.request(http.MethodPost, Bucket, remotePath, url.Values{"uploads": {""}}, nil, 0)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	cli.setMeta(req, meta)
+	var res struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = cli.sendXML(req, Bucket, remotePath, &res)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return res.UploadID, nil
+}
+
+// UploadPart uploads a part, returns the etag of part
+func (cli *SignedHandler) UploadPart(Bucket, remotePath, uploadID string, number int, f *os.File, off, size int64) (string, error) {
+	q := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	req, err := cli.request(http.MethodPut, Bucket, remotePath, q, cli.body(f, off, size), size)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	header, err := cli.send(req, Bucket, remotePath)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return header.Get("ETag"), nil
+}
+
+// CompleteMultipartUpload completes the multipart upload, the meta is set when initiated
+func (cli *SignedHandler) CompleteMultipartUpload(Bucket, remotePath, uploadID string, parts []Part, meta map[string]string) (string, error) {
+	type part struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	body := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+	for _, p := range parts {
+		body.Parts = append(body.Parts, part{PartNumber: p.Number, ETag: p.ETag})
+	}
+	data, err := xml.Marshal(body)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	req, err := cli.request(http.MethodPost, Bucket, remotePath, url.Values{"uploadId": {uploadID}}, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	var res struct {
+		ETag string `xml:"ETag"`
+	}
+	err = cli.sendXML(req, Bucket, remotePath, &res)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return strings.Trim(res.ETag, "\""), nil
+}
+
+// AbortMultipartUpload aborts the multipart upload
+func (cli *SignedHandler) AbortMultipartUpload(Bucket, remotePath, uploadID string) error {
+	req, err := cli.request(http.MethodDelete, Bucket, remotePath, url.Values{"uploadId": {uploadID}}, nil, 0)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = cli.send(req, Bucket, remotePath)
+	return errors.Trace(err)
+}
+
+// GetObjectToFile download file
+func (cli *SignedHandler) GetObjectToFile(Bucket, remotePath, filename string) error {
+	req, err := cli.request(http.MethodGet, Bucket, remotePath, nil, nil, 0)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cli.sign(req, Bucket, remotePath)
+	return errors.Trace(cli.rest.download(req, filename))
+}
+
+// DeleteObject delete object
+func (cli *SignedHandler) DeleteObject(Bucket, remotePath string) error {
+	req, err := cli.request(http.MethodDelete, Bucket, remotePath, nil, nil, 0)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = cli.send(req, Bucket, remotePath)
+	if isNotFound(err) {
+		return nil
+	}
+	return errors.Trace(err)
+}
+
+// FileExists checks the Content-MD5 of object if uploaded with it, otherwise the etag which is
+// the md5 of object unless it is uploaded in parts
+func (cli *SignedHandler) FileExists(Bucket, remotePath, md5 string) bool {
+	req, err := cli.request(http.MethodHead, Bucket, remotePath, nil, nil, 0)
+	if err != nil {
+		return false
+	}
+	header, err := cli.send(req, Bucket, remotePath)
+	if err != nil {
+		if !isNotFound(err) {
+			cli.log.Warn("failed to get object meta", log.Error(err))
+		}
+		return false
+	}
+	if v := header.Get("Content-MD5"); v != "" {
+		sum, err := base64.StdEncoding.DecodeString(v)
+		return err == nil && strings.EqualFold(hex.EncodeToString(sum), md5)
+	}
+	etag := strings.Trim(header.Get("ETag"), "\"")
+	return !strings.Contains(etag, "-") && strings.EqualFold(etag, md5)
+}
+
+// RefreshSts gets the temporary credentials from baetyl core if sts is set and the credentials expired
+func (cli *SignedHandler) RefreshSts() (*v1.STSResponse, error) {
+	if cli.core == nil {
+		return nil, nil
+	}
+	cli.lock.Lock()
+	defer cli.lock.Unlock()
+	if cli.deadline.After(time.Now()) {
+		return nil, nil
+	}
+	res, err := GetSts(cli.core, cli.cfg.Sts)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	cli.log.Debug("refresh sts", log.Any("expiration", res.Expiration))
+	cli.creds = accessKey{ak: res.AK, sk: res.SK, token: res.Token}
+	cli.deadline = res.Expiration
+	return res, nil
+}
+
+// body returns the section of file as request body, which is read no faster than the throttlers
+func (cli *SignedHandler) body(f *os.File, off, size int64) io.Reader {
+	return ioutil.NopCloser(throttled(io.NewSectionReader(f, off, size), cli.throttlers...))
+}
+
+func (cli *SignedHandler) setMeta(req *http.Request, meta map[string]string) {
+	for k, v := range meta {
+		req.Header.Set(cli.metaPrefix+k, v)
+	}
+}
+
+// request creates the request of object in the virtual host of bucket
+func (cli *SignedHandler) request(method, Bucket, remotePath string, query url.Values, body io.Reader, size int64) (*http.Request, error) {
+	u := *cli.endpoint
+	u.Host = Bucket + "." + u.Host
+	u.Path = u.Path + "/" + strings.TrimPrefix(remotePath, "/")
+	// the sub resource without value such as uploads is encoded without =
+	u.RawQuery = strings.Replace(query.Encode(), "=&", "&", -1)
+	u.RawQuery = strings.TrimSuffix(u.RawQuery, "=")
+	if body == nil || size == 0 {
+		body = http.NoBody
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	req.ContentLength = size
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	return req, nil
+}
+
+func (cli *SignedHandler) sign(req *http.Request, Bucket, remotePath string) {
+	cli.lock.RLock()
+	creds := cli.creds
+	cli.lock.RUnlock()
+	cli.signer.sign(req, Bucket, remotePath, creds)
+}
+
+// send signs the request and sends it, returns the header of response
+func (cli *SignedHandler) send(req *http.Request, Bucket, remotePath string) (http.Header, error) {
+	cli.sign(req, Bucket, remotePath)
+	return cli.rest.discard(req)
+}
+
+// sendXML signs the request and sends it, the body of response is decoded as xml
+func (cli *SignedHandler) sendXML(req *http.Request, Bucket, remotePath string, v interface{}) error {
+	cli.sign(req, Bucket, remotePath)
+	res, err := cli.rest.do(req)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer res.Body.Close()
+	return errors.Trace(xml.NewDecoder(res.Body).Decode(v))
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockSigned a minimal object storage with the rest api similar to s3, such as OSS and COS
+type mockSigned struct {
+	objects map[string][]byte
+	metas   map[string]http.Header
+	parts   map[string][]byte
+	uploads int
+	hosts   []string
+	auths   []string
+	tokens  []string
+	lock    sync.Mutex
+}
+
+func newMockSigned() *mockSigned {
+	return &mockSigned{
+		objects: map[string][]byte{},
+		metas:   map[string]http.Header{},
+		parts:   map[string][]byte{},
+	}
+}
+
+func (m *mockSigned) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.hosts = append(m.hosts, r.Host)
+	m.auths = append(m.auths, r.Header.Get("Authorization"))
+	m.tokens = append(m.tokens, r.Header.Get("x-oss-security-token")+r.Header.Get("x-cos-security-token"))
+	name := strings.Split(r.Host, ".")[0] + r.URL.Path
+	q := r.URL.Query()
+	data, _ := ioutil.ReadAll(r.Body)
+	switch {
+	case r.Method == http.MethodPost && q.Get("uploadId") == "":
+		m.uploads++
+		m.metas[name] = r.Header
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%d</UploadId></InitiateMultipartUploadResult>", m.uploads)
+	case r.Method == http.MethodPut && q.Get("uploadId") != "":
+		m.parts[name+q.Get("uploadId")+q.Get("partNumber")] = data
+		w.Header().Set("ETag", fmt.Sprintf("\"part-%s\"", q.Get("partNumber")))
+	case r.Method == http.MethodPost:
+		var body struct {
+			Parts []struct {
+				PartNumber int    `xml:"PartNumber"`
+				ETag       string `xml:"ETag"`
+			} `xml:"Part"`
+		}
+		xml.Unmarshal(data, &body)
+		var object []byte
+		for _, p := range body.Parts {
+			if p.ETag != fmt.Sprintf("\"part-%d\"", p.PartNumber) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			object = append(object, m.parts[fmt.Sprintf("%s%s%d", name, q.Get("uploadId"), p.PartNumber)]...)
+		}
+		m.objects[name] = object
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><ETag>\"%x-%d\"</ETag></CompleteMultipartUploadResult>", md5.Sum(object), len(body.Parts))
+	case r.Method == http.MethodPut:
+		sum := md5.Sum(data)
+		if r.Header.Get("Content-MD5") != base64.StdEncoding.EncodeToString(sum[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		m.objects[name], m.metas[name] = data, r.Header
+		w.Header().Set("ETag", fmt.Sprintf("\"%x\"", sum))
+	case r.Method == http.MethodDelete && q.Get("uploadId") != "":
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		object, ok := m.objects[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if m.metas[name].Get("Content-MD5") == "" {
+			w.Header().Set("ETag", "\"multipart-2\"")
+		} else {
+			w.Header().Set("ETag", fmt.Sprintf("\"%x\"", md5.Sum(object)))
+		}
+		w.Write(object)
+	case r.Method == http.MethodDelete:
+		if _, ok := m.objects[name]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(m.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestSignedHandler(t *testing.T) {
+	for _, kind := range []Kind{OSS, COS} {
+		t.Run(string(kind), func(t *testing.T) {
+			m := newMockSigned()
+			s := httptest.NewServer(m)
+			defer s.Close()
+
+			c := *cfg
+			c.Kind = kind
+			c.Endpoint = "http://storage.example.com"
+			c.Ak = "ak"
+			c.Sk = "sk"
+			c.Token = "token"
+			c.MultiPart = MultiPart{PartSize: 4, Concurrency: 2}
+			h, err := NewObjectStorageHandler(nil, c)
+			assert.NoError(t, err)
+			// the virtual hosts of buckets are resolved to the mock server
+			h.(*SignedHandler).rest.cli.Transport = &http.Transport{
+				DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+					return net.Dial(network, s.Listener.Addr().String())
+				},
+			}
+
+			dir := t.TempDir()
+			small := path.Join(dir, "small.txt")
+			assert.NoError(t, ioutil.WriteFile(small, []byte("abc"), 0644))
+			large := path.Join(dir, "large.txt")
+			assert.NoError(t, ioutil.WriteFile(large, []byte("0123456789"), 0644))
+			prefix := strings.ToLower(string(kind))
+
+			// round 1: put object
+			sum, err := utils.CalculateFileMD5(small)
+			assert.NoError(t, err)
+			etag, err := h.PutObjectFromFile("bucket", "a/small.txt", small, map[string]string{"k": "v"})
+			assert.NoError(t, err)
+			assert.Equal(t, sum, etag)
+			assert.Equal(t, "v", m.metas["bucket/a/small.txt"].Get("x-"+prefix+"-meta-k"))
+			assert.Equal(t, "bucket.storage.example.com", m.hosts[0])
+			assert.Equal(t, "token", m.tokens[0])
+			if kind == OSS {
+				assert.True(t, strings.HasPrefix(m.auths[0], "OSS ak:"))
+			} else {
+				assert.True(t, strings.HasPrefix(m.auths[0], "q-sign-algorithm=sha1&q-ak=ak&"))
+				assert.Contains(t, m.auths[0], "q-header-list=content-md5;host;x-cos-meta-k;x-cos-security-token&")
+			}
+			assert.True(t, h.FileExists("bucket", "a/small.txt", sum))
+			assert.False(t, h.FileExists("bucket", "a/small.txt", "0123"))
+			assert.False(t, h.FileExists("bucket", "a/none.txt", sum))
+
+			// round 2: put object in parts
+			_, err = h.PutObjectFromFile("bucket", "a/large.txt", large, map[string]string{"k": "v"})
+			assert.NoError(t, err)
+			assert.Equal(t, "0123456789", string(m.objects["bucket/a/large.txt"]))
+			assert.Len(t, m.parts, 3)
+			assert.Equal(t, "v", m.metas["bucket/a/large.txt"].Get("x-"+prefix+"-meta-k"))
+			// the etag of object uploaded in parts is not the md5
+			sum, err = utils.CalculateFileMD5(large)
+			assert.NoError(t, err)
+			assert.False(t, h.FileExists("bucket", "a/large.txt", sum))
+
+			// round 3: get and delete object
+			dst := path.Join(dir, "dst.txt")
+			assert.NoError(t, h.GetObjectToFile("bucket", "a/large.txt", dst))
+			data, err := ioutil.ReadFile(dst)
+			assert.NoError(t, err)
+			assert.Equal(t, "0123456789", string(data))
+			assert.Error(t, h.GetObjectToFile("bucket", "a/none.txt", dst))
+			assert.NoError(t, h.DeleteObject("bucket", "a/large.txt"))
+			assert.NoError(t, h.DeleteObject("bucket", "a/large.txt"))
+
+			// round 4: no temporary credentials without sts
+			res, err := h.RefreshSts()
+			assert.NoError(t, err)
+			assert.Nil(t, res)
+
+			c.Endpoint = ""
+			_, err = NewObjectStorageHandler(nil, c)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestOSSSign(t *testing.T) {
+	// the example of https://help.aliyun.com/document_detail/31951.html
+	req, err := http.NewRequest(http.MethodPut, "http://oss-example.oss-cn-hangzhou.aliyuncs.com/nelson", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Content-MD5", "eB5eJF1ptWaXm4bijSPyxw==")
+	req.Header.Set("Content-Type", "text/html")
+	req.Header.Set("Date", "Wed, 28 Dec 2022 10:27:41 GMT")
+	req.Header.Set("x-oss-meta-author", "alice")
+	req.Header.Set("x-oss-meta-magic", "abracadabra")
+	ossSigner{}.sign(req, "oss-example", "nelson", accessKey{ak: "LTAI****************", sk: "yourAccessKeySecret"})
+
+	s := "PUT\neB5eJF1ptWaXm4bijSPyxw==\ntext/html\nWed, 28 Dec 2022 10:27:41 GMT\nx-oss-meta-author:alice\nx-oss-meta-magic:abracadabra\n/oss-example/nelson"
+	expected := "OSS LTAI****************:" + base64.StdEncoding.EncodeToString(hexDecode(t, cosHMAC("yourAccessKeySecret", s)))
+	assert.Equal(t, expected, req.Header.Get("Authorization"))
+}
+
+func hexDecode(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	assert.NoError(t, err)
+	return b
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
-	"context"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,11 +17,13 @@ import (
 	"github.com/baetyl/baetyl-go/v2/log"
 	v1 "github.com/baetyl/baetyl-go/v2/spec/v1"
 	"github.com/baidubce/bce-sdk-go/bce"
+	bcehttp "github.com/baidubce/bce-sdk-go/http"
 	"github.com/baidubce/bce-sdk-go/services/bos"
 	"github.com/baidubce/bce-sdk-go/services/bos/api"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
@@ -27,11 +31,18 @@ import (
 
 // StorageHandler interface
 type StorageHandler interface {
-	PutObjectFromFile(Bucket, remotePath, filename string, meta map[string]string) error
+	PutObjectFromFile(Bucket, remotePath, filename string, meta map[string]string) (string, error)
+	GetObjectToFile(Bucket, remotePath, filename string) error
+	DeleteObject(Bucket, remotePath string) error
 	FileExists(Bucket, remotePath, md5 string) bool
 	RefreshSts() (*v1.STSResponse, error)
 }
 
+// SizeChecker the storage handler which checks the size of object as well, for the storage not always returning md5
+type SizeChecker interface {
+	FileExistsWithSize(Bucket, remotePath, md5 string, size int64) bool
+}
+
 // NewObjectStorageHandler NewObjectStorageHandler
 func NewObjectStorageHandler(ctx dm.Context, cfg ClientInfo) (StorageHandler, error) {
 	switch cfg.Kind {
@@ -39,16 +50,45 @@ func NewObjectStorageHandler(ctx dm.Context, cfg ClientInfo) (StorageHandler, er
 		return NewBosHandler(cfg)
 	case S3:
 		return NewS3Client(ctx, cfg)
+	case File:
+		return NewFileHandler(cfg)
+	case Azure:
+		return NewAzureHandler(cfg)
+	case GCS:
+		return NewGCSHandler(ctx, cfg)
+	case OSS:
+		return NewOSSHandler(ctx, cfg)
+	case COS:
+		return NewCOSHandler(ctx, cfg)
+	case SFTP:
+		return NewSFTPHandler(cfg)
+	case WebDAV:
+		return NewWebDAVHandler(cfg)
+	case HTTP:
+		return NewHTTPHandler(cfg)
 	default:
 		return nil, fmt.Errorf("kind type unexpected")
 	}
 }
 
+// The headers of BOS not supported by sdk
+const (
+	bosSSE                  = "x-bce-server-side-encryption"
+	bosSSEKMSKeyID          = "x-bce-server-side-encryption-bos-kms-key-id"
+	bosSSECustomerAlgorithm = "x-bce-server-side-encryption-customer-algorithm"
+	bosSSECustomerKey       = "x-bce-server-side-encryption-customer-key"
+	bosSSECustomerKeyMD5    = "x-bce-server-side-encryption-customer-key-md5"
+	bosTagging              = "x-bce-tagging"
+)
+
 // BosHandler BosHandler
 type BosHandler struct {
-	bos *bos.Client
-	cfg ClientInfo
-	log *log.Logger
+	bos        *bos.Client
+	cfg        ClientInfo
+	resumer    *resumer
+	throttlers []*throttler
+	ssec       *customerKey // the key of SSE-C if set
+	log        *log.Logger
 }
 
 // NewBosHandler creates a new newBosClient
@@ -61,46 +101,363 @@ func NewBosHandler(cfg ClientInfo) (StorageHandler, error) {
 	cli.MaxParallel = (int64)(cfg.MultiPart.Concurrency)
 	cli.Config.ConnectionTimeoutInMillis = (int)(cfg.Timeout / time.Millisecond)
 	cli.Config.Retry = bce.NewBackOffRetryPolicy(cfg.Backoff.Max, (int64)(cfg.Backoff.Delay/time.Millisecond), (int64)(cfg.Backoff.Base/time.Millisecond))
+	if err = cfg.Object.validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	ssec, err := loadCustomerKey(cfg.Object)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 	b := &BosHandler{
-		bos: cli,
-		cfg: cfg,
-		log: log.With(log.Any("storage", "bos")),
+		bos:        cli,
+		cfg:        cfg,
+		ssec:       ssec,
+		throttlers: []*throttler{newThrottler(cfg.Throttle), globalThrottler},
+		log:        log.With(log.Any("storage", "bos")),
 	}
+	b.resumer = newResumer(cfg.MultiPart, b, b.log)
 	return b, nil
 }
 
-// PutObjectFromFile upload file
-func (cli *BosHandler) PutObjectFromFile(Bucket, remotePath, filename string, meta map[string]string) error {
-	args := new(api.PutObjectArgs)
-	args.UserMeta = meta
-	_, err := cli.bos.PutObjectFromFile(Bucket, remotePath, filename, args)
+// PutObjectFromFile upload file with the object options of client, returns the etag
+func (cli *BosHandler) PutObjectFromFile(Bucket, remotePath, filename string, meta map[string]string) (string, error) {
+	return cli.PutObjectFromFileWithOptions(Bucket, remotePath, filename, meta, &cli.cfg.Object)
+}
+
+// PutObjectFromFileWithOptions upload file with the object options, returns the etag. The object is put by sdk,
+// unless the options not supported by sdk are set, such as server-side encryption, acl and tags,
+// then the request is built with their headers
+func (cli *BosHandler) PutObjectFromFileWithOptions(Bucket, remotePath, filename string, meta map[string]string, opts *ObjectOptions) (string, error) {
+	headers, err := cli.headers(opts)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if cli.resumer.enabled(fi.Size()) {
+		return cli.resumer.uploadWith(&bosUploader{BosHandler: cli, opts: opts, headers: headers}, Bucket, remotePath, filename, meta)
+	}
+	body, err := cli.body(f, 0, fi.Size())
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if len(headers) == 0 {
+		args := &api.PutObjectArgs{UserMeta: meta}
+		if opts != nil {
+			args.StorageClass, args.ContentType, args.CacheControl = opts.StorageClass, opts.ContentType, opts.CacheControl
+		}
+		etag, err := cli.bos.PutObject(Bucket, remotePath, body, args)
+		return etag, errors.Trace(err)
+	}
+	req := &bce.BceRequest{}
+	req.SetMethod(bcehttp.PUT)
+	req.SetBody(body)
+	for k, v := range meta {
+		req.SetHeader(bcehttp.BCE_USER_METADATA_PREFIX+k, v)
+	}
+	setSDKHeaders(req, opts)
+	resp, err := cli.send(req, Bucket, remotePath, headers)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	defer resp.Body().Close()
+	return strings.Trim(resp.Header(bcehttp.ETAG), "\""), nil
+}
+
+// headers returns the request headers of object options not supported by sdk
+func (cli *BosHandler) headers(opts *ObjectOptions) (map[string]string, error) {
+	headers := map[string]string{}
+	if opts == nil {
+		return headers, nil
+	}
+	switch opts.SSE {
+	case SSES3:
+		headers[bosSSE] = "AES256"
+	case SSEKMS:
+		headers[bosSSE] = "KMS"
+		if opts.SSEKMSKeyID != "" {
+			headers[bosSSEKMSKeyID] = opts.SSEKMSKeyID
+		}
+	case SSEC:
+		if cli.ssec == nil {
+			return nil, errors.New("the customer key of SSE-C is not set by client")
+		}
+		cli.customerKey(headers)
+	}
+	for k, v := range map[string]string{
+		bcehttp.BCE_ACL:          opts.ACL,
+		bcehttp.CONTENT_ENCODING: opts.ContentEncoding,
+		bosTagging:               opts.tagging(),
+	} {
+		if v != "" {
+			headers[k] = v
+		}
+	}
+	return headers, nil
+}
+
+// setSDKHeaders sets the headers of object options supported by sdk to the request built
+func setSDKHeaders(req *bce.BceRequest, opts *ObjectOptions) {
+	if opts == nil {
+		return
+	}
+	for k, v := range map[string]string{
+		bcehttp.BCE_STORAGE_CLASS: opts.StorageClass,
+		bcehttp.CONTENT_TYPE:      opts.ContentType,
+		bcehttp.CACHE_CONTROL:     opts.CacheControl,
+	} {
+		if v != "" {
+			req.SetHeader(k, v)
+		}
+	}
+}
+
+// customerKey sets the headers of SSE-C key
+func (cli *BosHandler) customerKey(headers map[string]string) {
+	headers[bosSSECustomerAlgorithm] = "AES256"
+	headers[bosSSECustomerKey] = cli.ssec.key
+	headers[bosSSECustomerKeyMD5] = cli.ssec.md5
+}
+
+// readHeaders returns the headers to read the object uploaded with the options, only the SSE-C key if set
+func (cli *BosHandler) readHeaders(opts *ObjectOptions) (map[string]string, error) {
+	headers := map[string]string{}
+	if opts == nil || opts.SSE != SSEC {
+		return headers, nil
+	}
+	if cli.ssec == nil {
+		return nil, errors.New("the customer key of SSE-C is not set by client")
+	}
+	cli.customerKey(headers)
+	return headers, nil
+}
+
+// send sends the request of object with headers, the body of response should be closed if no error
+func (cli *BosHandler) send(req *bce.BceRequest, Bucket, remotePath string, headers map[string]string) (*bce.BceResponse, error) {
+	req.SetUri(bce.URI_PREFIX + Bucket + "/" + remotePath)
+	for k, v := range headers {
+		req.SetHeader(k, v)
+	}
+	resp := &bce.BceResponse{}
+	if err := cli.bos.SendRequest(req, resp); err != nil {
+		return nil, err
+	}
+	if resp.IsFail() {
+		return nil, resp.ServiceError()
+	}
+	return resp, nil
+}
+
+// bosUploader the multipart uploader of BOS applying the object options
+type bosUploader struct {
+	*BosHandler
+	opts    *ObjectOptions
+	headers map[string]string // the headers of options not supported by sdk
+}
+
+// InitMultipartUpload initiates a multipart upload with the object options
+func (u *bosUploader) InitMultipartUpload(Bucket, remotePath string, meta map[string]string) (string, error) {
+	return u.initMultipartUpload(Bucket, remotePath, u.opts, u.headers)
+}
+
+// UploadPart uploads a part of file with the customer key of SSE-C if set
+func (u *bosUploader) UploadPart(Bucket, remotePath, uploadID string, number int, f *os.File, off, size int64) (string, error) {
+	return u.uploadPart(Bucket, remotePath, uploadID, number, f, off, size, u.headers)
+}
+
+// body returns the section of file as request body, which is read no faster than the throttlers
+func (cli *BosHandler) body(f *os.File, off, size int64) (*bce.Body, error) {
+	body, err := bce.NewBodyFromSectionFile(f, off, size)
+	if err != nil {
+		return nil, err
+	}
+	body.SetStream(ioutil.NopCloser(throttled(io.NewSectionReader(f, off, size), cli.throttlers...)))
+	return body, nil
+}
+
+// GetObjectToFile download file, with the customer key if the objects of client are encrypted by SSE-C
+func (cli *BosHandler) GetObjectToFile(Bucket, remotePath, filename string) error {
+	return cli.GetObjectToFileWithOptions(Bucket, remotePath, filename, &cli.cfg.Object)
+}
+
+// GetObjectToFileWithOptions download file, with the customer key if the object is encrypted by SSE-C
+func (cli *BosHandler) GetObjectToFileWithOptions(Bucket, remotePath, filename string, opts *ObjectOptions) error {
+	headers, err := cli.readHeaders(opts)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(headers) == 0 {
+		return errors.Trace(cli.bos.BasicGetObjectToFile(Bucket, remotePath, filename))
+	}
+	req := &bce.BceRequest{}
+	req.SetMethod(bcehttp.GET)
+	resp, err := cli.send(req, Bucket, remotePath, headers)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body().Close()
+	f, err := os.OpenFile(filename, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+	_, err = io.Copy(f, resp.Body())
 	return errors.Trace(err)
 }
 
+// DeleteObject delete object
+func (cli *BosHandler) DeleteObject(Bucket, remotePath string) error {
+	return errors.Trace(cli.bos.DeleteObject(Bucket, remotePath))
+}
+
 // FileExists FileExists
 func (cli *BosHandler) FileExists(Bucket, remotePath, md5 string) bool {
-	res, err := cli.bos.GetObjectMeta(Bucket, remotePath)
+	return cli.FileExistsWithOptions(Bucket, remotePath, md5, &cli.cfg.Object)
+}
+
+// FileExistsWithOptions checks the md5 of object, with the customer key if the object is encrypted by SSE-C
+func (cli *BosHandler) FileExistsWithOptions(Bucket, remotePath, md5 string, opts *ObjectOptions) bool {
+	headers, err := cli.readHeaders(opts)
 	if err != nil {
 		cli.log.Warn("failed to get object meta", log.Error(err))
 		return false
 	}
-	if res.ObjectMeta.ContentMD5 == md5 {
-		return true
+	if len(headers) == 0 {
+		res, err := cli.bos.GetObjectMeta(Bucket, remotePath)
+		if err != nil {
+			cli.log.Warn("failed to get object meta", log.Error(err))
+			return false
+		}
+		return res.ObjectMeta.ContentMD5 == md5
 	}
-	return false
+	req := &bce.BceRequest{}
+	req.SetMethod(bcehttp.HEAD)
+	resp, err := cli.send(req, Bucket, remotePath, headers)
+	if err != nil {
+		cli.log.Warn("failed to get object meta", log.Error(err))
+		return false
+	}
+	defer resp.Body().Close()
+	return resp.Header(bcehttp.CONTENT_MD5) == md5
 }
 
 func (cli *BosHandler) RefreshSts() (*v1.STSResponse, error) {
 	return nil, nil
 }
 
+// InitMultipartUpload initiates a multipart upload, the meta is set when completed
+func (cli *BosHandler) InitMultipartUpload(Bucket, remotePath string, meta map[string]string) (string, error) {
+	headers, err := cli.headers(&cli.cfg.Object)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return cli.initMultipartUpload(Bucket, remotePath, &cli.cfg.Object, headers)
+}
+
+// initMultipartUpload initiates a multipart upload by sdk, or by the request built if the headers not supported by sdk set
+func (cli *BosHandler) initMultipartUpload(Bucket, remotePath string, opts *ObjectOptions, headers map[string]string) (string, error) {
+	if len(headers) == 0 {
+		var contentType string
+		args := &api.InitiateMultipartUploadArgs{}
+		if opts != nil {
+			contentType, args.StorageClass, args.CacheControl = opts.ContentType, opts.StorageClass, opts.CacheControl
+		}
+		res, err := cli.bos.InitiateMultipartUpload(Bucket, remotePath, contentType, args)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		return res.UploadId, nil
+	}
+	req := &bce.BceRequest{}
+	req.SetMethod(bcehttp.POST)
+	req.SetParam("uploads", "")
+	setSDKHeaders(req, opts)
+	resp, err := cli.send(req, Bucket, remotePath, headers)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	res := &api.InitiateMultipartUploadResult{}
+	if err = resp.ParseJsonBody(res); err != nil {
+		return "", errors.Trace(err)
+	}
+	return res.UploadId, nil
+}
+
+// UploadPart uploads a part of file, returns the etag of part
+func (cli *BosHandler) UploadPart(Bucket, remotePath, uploadID string, number int, f *os.File, off, size int64) (string, error) {
+	headers, err := cli.headers(&cli.cfg.Object)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return cli.uploadPart(Bucket, remotePath, uploadID, number, f, off, size, headers)
+}
+
+// uploadPart uploads a part of file by sdk, or by the request built with the headers of SSE-C key if set,
+// only which are sent with the part
+func (cli *BosHandler) uploadPart(Bucket, remotePath, uploadID string, number int, f *os.File, off, size int64, headers map[string]string) (string, error) {
+	body, err := cli.body(f, off, size)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	ssec := map[string]string{}
+	for _, k := range []string{bosSSECustomerAlgorithm, bosSSECustomerKey, bosSSECustomerKeyMD5} {
+		if v, ok := headers[k]; ok {
+			ssec[k] = v
+		}
+	}
+	if len(ssec) == 0 {
+		etag, err := cli.bos.BasicUploadPart(Bucket, remotePath, uploadID, number, body)
+		return etag, errors.Trace(err)
+	}
+	req := &bce.BceRequest{}
+	req.SetMethod(bcehttp.PUT)
+	req.SetParam("uploadId", uploadID)
+	req.SetParam("partNumber", strconv.Itoa(number))
+	req.SetBody(body)
+	resp, err := cli.send(req, Bucket, remotePath, ssec)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	defer resp.Body().Close()
+	return strings.Trim(resp.Header(bcehttp.ETAG), "\""), nil
+}
+
+// CompleteMultipartUpload completes a multipart upload, returns the etag
+func (cli *BosHandler) CompleteMultipartUpload(Bucket, remotePath, uploadID string, parts []Part, meta map[string]string) (string, error) {
+	args := &api.CompleteMultipartUploadArgs{UserMeta: meta}
+	for _, p := range parts {
+		args.Parts = append(args.Parts, api.UploadInfoType{PartNumber: p.Number, ETag: p.ETag})
+	}
+	res, err := cli.bos.CompleteMultipartUploadFromStruct(Bucket, remotePath, uploadID, args)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return res.ETag, nil
+}
+
+// AbortMultipartUpload aborts a multipart upload
+func (cli *BosHandler) AbortMultipartUpload(Bucket, remotePath, uploadID string) error {
+	return errors.Trace(cli.bos.AbortMultipartUpload(Bucket, remotePath, uploadID))
+}
+
 // S3Handler S3Handler
 type S3Handler struct {
-	s3Client *s3.S3
-	uploader *s3manager.Uploader
-	cli      *http.Client
-	cfg      ClientInfo
-	log      *log.Logger
+	s3Client   *s3.S3
+	uploader   *s3manager.Uploader
+	downloader *s3manager.Downloader
+	resumer    *resumer
+	throttlers []*throttler
+	cli        *http.Client
+	cfg        ClientInfo
+	ssec       *customerKey // the key of SSE-C if set
+	log        *log.Logger
 }
 
 // NewS3Client creates a new NewS3Client
@@ -109,14 +466,26 @@ func NewS3Client(ctx dm.Context, cfg ClientInfo) (StorageHandler, error) {
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	if err = cfg.Object.validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	ssec, err := loadCustomerKey(cfg.Object)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 	if cfg.Name == MinioStsCli {
-		return &S3Handler{
-			s3Client: &s3.S3{},
-			cfg:      cfg,
-			cli:      cli,
-			uploader: &s3manager.Uploader{},
-			log:      log.With(log.Any("storage", "s3")),
-		}, nil
+		h := &S3Handler{
+			s3Client:   &s3.S3{},
+			cfg:        cfg,
+			cli:        cli,
+			ssec:       ssec,
+			uploader:   &s3manager.Uploader{},
+			downloader: &s3manager.Downloader{},
+			throttlers: []*throttler{newThrottler(cfg.Throttle), globalThrottler},
+			log:        log.With(log.Any("storage", "s3")),
+		}
+		h.resumer = newResumer(cfg.MultiPart, h, h.log)
+		return h, nil
 	}
 	s3Config := &aws.Config{
 		Credentials:      credentials.NewStaticCredentials(cfg.Ak, cfg.Sk, cfg.Token),
@@ -124,18 +493,24 @@ func NewS3Client(ctx dm.Context, cfg ClientInfo) (StorageHandler, error) {
 		Region:           aws.String(cfg.Region),
 		DisableSSL:       aws.Bool(!strings.HasPrefix(cfg.Endpoint, "https")),
 		S3ForcePathStyle: aws.Bool(true),
+		HTTPClient:       newHTTPClient(cfg),
 	}
 	sessionProvider, err := session.NewSession(s3Config)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	return &S3Handler{
-		s3Client: s3.New(sessionProvider),
-		cfg:      cfg,
-		cli:      cli,
-		uploader: s3manager.NewUploader(sessionProvider),
-		log:      log.With(log.Any("storage", "s3")),
-	}, nil
+	h := &S3Handler{
+		s3Client:   s3.New(sessionProvider),
+		cfg:        cfg,
+		cli:        cli,
+		ssec:       ssec,
+		uploader:   s3manager.NewUploader(sessionProvider),
+		downloader: s3manager.NewDownloader(sessionProvider),
+		throttlers: []*throttler{newThrottler(cfg.Throttle), globalThrottler},
+		log:        log.With(log.Any("storage", "s3")),
+	}
+	h.resumer = newResumer(cfg.MultiPart, h, h.log)
+	return h, nil
 }
 
 func (cli *S3Handler) RefreshSts() (*v1.STSResponse, error) {
@@ -145,7 +520,7 @@ func (cli *S3Handler) RefreshSts() (*v1.STSResponse, error) {
 	if cli.cfg.StsDeadline.After(time.Now()) {
 		return nil, nil
 	}
-	res, err := GetSts(cli.cli)
+	res, err := GetSts(cli.cli, "minio")
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -156,6 +531,7 @@ func (cli *S3Handler) RefreshSts() (*v1.STSResponse, error) {
 		Region:           aws.String("us-east-1"),
 		DisableSSL:       aws.Bool(!strings.HasPrefix(res.Endpoint, "https")),
 		S3ForcePathStyle: aws.Bool(true),
+		HTTPClient:       newHTTPClient(cli.cfg),
 	}
 	sessionProvider, err := session.NewSession(s3Config)
 	if err != nil {
@@ -163,41 +539,301 @@ func (cli *S3Handler) RefreshSts() (*v1.STSResponse, error) {
 	}
 	cli.s3Client = s3.New(sessionProvider)
 	cli.uploader = s3manager.NewUploader(sessionProvider)
+	cli.downloader = s3manager.NewDownloader(sessionProvider)
 	return res, nil
 }
 
-// PutObjectFromFile upload file
-func (cli *S3Handler) PutObjectFromFile(Bucket, remotePath, filename string, meta map[string]string) error {
+// PutObjectFromFile upload file with the object options of client, returns the etag
+func (cli *S3Handler) PutObjectFromFile(Bucket, remotePath, filename string, meta map[string]string) (string, error) {
+	return cli.PutObjectFromFileWithOptions(Bucket, remotePath, filename, meta, &cli.cfg.Object)
+}
+
+// PutObjectFromFileWithOptions upload file with the object options, returns the etag
+func (cli *S3Handler) PutObjectFromFileWithOptions(Bucket, remotePath, filename string, meta map[string]string, opts *ObjectOptions) (string, error) {
 	Metadata := make(map[string]*string)
 	for k, v := range meta {
-		Metadata[k] = &v
+		Metadata[k] = aws.String(v)
+	}
+	o, err := cli.options(opts)
+	if err != nil {
+		return "", errors.Trace(err)
 	}
 	f, err := os.Open(filename)
 	if err != nil {
-		return errors.Trace(err)
+		return "", errors.Trace(err)
 	}
 	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if cli.resumer.enabled(fi.Size()) {
+		return cli.resumer.uploadWith(&s3Uploader{S3Handler: cli, opts: o}, Bucket, remotePath, filename, meta)
+	}
 	params := &s3manager.UploadInput{
-		Bucket:   aws.String(Bucket),     // Required
-		Key:      aws.String(remotePath), // Required
-		Body:     f,
-		Metadata: Metadata,
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), cli.cfg.Timeout)
-	defer cancel()
-	_, err = cli.uploader.UploadWithContext(ctx, params, func(u *s3manager.Uploader) {
+		Bucket:               aws.String(Bucket),     // Required
+		Key:                  aws.String(remotePath), // Required
+		Body:                 throttled(f, cli.throttlers...),
+		Metadata:             Metadata,
+		ServerSideEncryption: o.sse,
+		SSEKMSKeyId:          o.kmsKeyID,
+		SSECustomerAlgorithm: o.customerAlgorithm,
+		SSECustomerKey:       o.customerKey,
+		StorageClass:         o.storageClass,
+		ACL:                  o.acl,
+		ContentType:          o.contentType,
+		ContentEncoding:      o.contentEncoding,
+		CacheControl:         o.cacheControl,
+		Tagging:              o.tagging,
+	}
+	// the timeout of client is applied to connect and wait for response headers, not to the throttled upload
+	var etag string
+	_, err = cli.uploader.Upload(params, func(u *s3manager.Uploader) {
 		u.PartSize = cli.cfg.MultiPart.PartSize
 		u.LeavePartsOnError = true
 		u.Concurrency = cli.cfg.MultiPart.Concurrency
+		u.RequestOptions = append(u.RequestOptions, etagOf(&etag))
 	}) //并发数
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return etag, nil
+}
+
+// etagOf returns the request option to keep the etag of object put in one request or completed in parts,
+// since the etag is not returned by uploader, it is left empty if not in the response
+func etagOf(etag *string) request.Option {
+	return func(r *request.Request) {
+		r.Handlers.Complete.PushBack(func(r *request.Request) {
+			if r.Error != nil {
+				return
+			}
+			switch out := r.Data.(type) {
+			case *s3.PutObjectOutput:
+				*etag = strings.Trim(aws.StringValue(out.ETag), "\"")
+			case *s3.CompleteMultipartUploadOutput:
+				*etag = strings.Trim(aws.StringValue(out.ETag), "\"")
+			}
+		})
+	}
+}
+
+// s3Options the request fields of object options, nil if not set
+type s3Options struct {
+	sse               *string
+	kmsKeyID          *string
+	customerAlgorithm *string
+	customerKey       *string
+	storageClass      *string
+	acl               *string
+	contentType       *string
+	contentEncoding   *string
+	cacheControl      *string
+	tagging           *string
+}
+
+func (cli *S3Handler) options(opts *ObjectOptions) (*s3Options, error) {
+	o := &s3Options{}
+	if opts == nil {
+		return o, nil
+	}
+	switch opts.SSE {
+	case SSES3:
+		o.sse = aws.String(s3.ServerSideEncryptionAes256)
+	case SSEKMS:
+		o.sse = aws.String(s3.ServerSideEncryptionAwsKms)
+		o.kmsKeyID = optional(opts.SSEKMSKeyID)
+	case SSEC:
+		if cli.ssec == nil {
+			return nil, errors.New("the customer key of SSE-C is not set by client")
+		}
+		// the key is encoded in base64 and its md5 is calculated by sdk
+		o.customerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		o.customerKey = aws.String(cli.ssec.raw)
+	}
+	o.storageClass = optional(opts.StorageClass)
+	o.acl = optional(opts.ACL)
+	o.contentType = optional(opts.ContentType)
+	o.contentEncoding = optional(opts.ContentEncoding)
+	o.cacheControl = optional(opts.CacheControl)
+	o.tagging = optional(opts.tagging())
+	return o, nil
+}
+
+// optional returns nil if the string is empty, so that the header is not sent
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// s3Uploader the multipart uploader of S3 applying the object options
+type s3Uploader struct {
+	*S3Handler
+	opts *s3Options
+}
+
+// InitMultipartUpload initiates a multipart upload with the object options
+func (u *s3Uploader) InitMultipartUpload(Bucket, remotePath string, meta map[string]string) (string, error) {
+	return u.initMultipartUpload(Bucket, remotePath, meta, u.opts)
+}
+
+// UploadPart uploads a part of file with the customer key of SSE-C if set
+func (u *s3Uploader) UploadPart(Bucket, remotePath, uploadID string, number int, f *os.File, off, size int64) (string, error) {
+	return u.uploadPart(Bucket, remotePath, uploadID, number, f, off, size, u.opts)
+}
+
+// InitMultipartUpload initiates a multipart upload
+func (cli *S3Handler) InitMultipartUpload(Bucket, remotePath string, meta map[string]string) (string, error) {
+	o, err := cli.options(&cli.cfg.Object)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return cli.initMultipartUpload(Bucket, remotePath, meta, o)
+}
+
+func (cli *S3Handler) initMultipartUpload(Bucket, remotePath string, meta map[string]string, o *s3Options) (string, error) {
+	Metadata := make(map[string]*string)
+	for k, v := range meta {
+		Metadata[k] = aws.String(v)
+	}
+	res, err := cli.s3Client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket:               aws.String(Bucket),
+		Key:                  aws.String(remotePath),
+		Metadata:             Metadata,
+		ServerSideEncryption: o.sse,
+		SSEKMSKeyId:          o.kmsKeyID,
+		SSECustomerAlgorithm: o.customerAlgorithm,
+		SSECustomerKey:       o.customerKey,
+		StorageClass:         o.storageClass,
+		ACL:                  o.acl,
+		ContentType:          o.contentType,
+		ContentEncoding:      o.contentEncoding,
+		CacheControl:         o.cacheControl,
+		Tagging:              o.tagging,
+	})
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return aws.StringValue(res.UploadId), nil
+}
+
+// UploadPart uploads a part of file, returns the etag of part
+func (cli *S3Handler) UploadPart(Bucket, remotePath, uploadID string, number int, f *os.File, off, size int64) (string, error) {
+	o, err := cli.options(&cli.cfg.Object)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return cli.uploadPart(Bucket, remotePath, uploadID, number, f, off, size, o)
+}
+
+// uploadPart uploads a part, the timeout of client is applied to connect and wait for response headers,
+// not to the transfer of part which may be throttled
+func (cli *S3Handler) uploadPart(Bucket, remotePath, uploadID string, number int, f *os.File, off, size int64, o *s3Options) (string, error) {
+	res, err := cli.s3Client.UploadPart(&s3.UploadPartInput{
+		Bucket:               aws.String(Bucket),
+		Key:                  aws.String(remotePath),
+		UploadId:             aws.String(uploadID),
+		PartNumber:           aws.Int64(int64(number)),
+		ContentLength:        aws.Int64(size),
+		Body:                 throttled(io.NewSectionReader(f, off, size), cli.throttlers...),
+		SSECustomerAlgorithm: o.customerAlgorithm,
+		SSECustomerKey:       o.customerKey,
+	})
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return aws.StringValue(res.ETag), nil
+}
+
+// CompleteMultipartUpload completes a multipart upload, returns the etag
+func (cli *S3Handler) CompleteMultipartUpload(Bucket, remotePath, uploadID string, parts []Part, meta map[string]string) (string, error) {
+	completed := &s3.CompletedMultipartUpload{}
+	for _, p := range parts {
+		completed.Parts = append(completed.Parts, &s3.CompletedPart{
+			PartNumber: aws.Int64(int64(p.Number)),
+			ETag:       aws.String(p.ETag),
+		})
+	}
+	res, err := cli.s3Client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(Bucket),
+		Key:             aws.String(remotePath),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: completed,
+	})
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return strings.Trim(aws.StringValue(res.ETag), "\""), nil
+}
+
+// AbortMultipartUpload aborts a multipart upload
+func (cli *S3Handler) AbortMultipartUpload(Bucket, remotePath, uploadID string) error {
+	_, err := cli.s3Client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(Bucket),
+		Key:      aws.String(remotePath),
+		UploadId: aws.String(uploadID),
+	})
+	return errors.Trace(err)
+}
+
+// GetObjectToFile download file, with the customer key if the objects of client are encrypted by SSE-C
+func (cli *S3Handler) GetObjectToFile(Bucket, remotePath, filename string) error {
+	return cli.GetObjectToFileWithOptions(Bucket, remotePath, filename, &cli.cfg.Object)
+}
+
+// GetObjectToFileWithOptions download file, with the customer key if the object is encrypted by SSE-C
+func (cli *S3Handler) GetObjectToFileWithOptions(Bucket, remotePath, filename string, opts *ObjectOptions) error {
+	o, err := cli.options(opts)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	f, err := os.OpenFile(filename, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+	params := &s3.GetObjectInput{
+		Bucket:               aws.String(Bucket),
+		Key:                  aws.String(remotePath),
+		SSECustomerAlgorithm: o.customerAlgorithm,
+		SSECustomerKey:       o.customerKey,
+	}
+	// the timeout of client is applied to connect and wait for response headers, not to the whole download
+	_, err = cli.downloader.Download(f, params, func(d *s3manager.Downloader) {
+		d.PartSize = cli.cfg.MultiPart.PartSize
+		d.Concurrency = cli.cfg.MultiPart.Concurrency
+	})
+	return errors.Trace(err)
+}
+
+// DeleteObject delete object
+func (cli *S3Handler) DeleteObject(Bucket, remotePath string) error {
+	_, err := cli.s3Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(Bucket),
+		Key:    aws.String(remotePath),
+	})
 	return errors.Trace(err)
 }
 
 // FileExists FileExists
 func (cli *S3Handler) FileExists(Bucket, remotePath, md5 string) bool {
+	return cli.FileExistsWithOptions(Bucket, remotePath, md5, &cli.cfg.Object)
+}
+
+// FileExistsWithOptions checks the etag of object, with the customer key if the object is encrypted by SSE-C
+func (cli *S3Handler) FileExistsWithOptions(Bucket, remotePath, md5 string, opts *ObjectOptions) bool {
+	o, err := cli.options(opts)
+	if err != nil {
+		cli.log.Warn("failed to get object meta", log.Error(err))
+		return false
+	}
 	cparams := &s3.HeadObjectInput{
-		Bucket: aws.String(Bucket),
-		Key:    aws.String(remotePath),
+		Bucket:               aws.String(Bucket),
+		Key:                  aws.String(remotePath),
+		SSECustomerAlgorithm: o.customerAlgorithm,
+		SSECustomerKey:       o.customerKey,
 	}
 	ho, err := cli.s3Client.HeadObject(cparams)
 	if err != nil {
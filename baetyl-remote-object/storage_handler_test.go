@@ -1,14 +1,27 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/awstesting/mock"
 	"github.com/aws/aws-sdk-go/awstesting/unit"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/baetyl/baetyl-go/v2/log"
+	v1 "github.com/baetyl/baetyl-go/v2/spec/v1"
 	"github.com/baetyl/baetyl-go/v2/utils"
 	"github.com/docker/distribution/uuid"
 	"github.com/stretchr/testify/assert"
@@ -21,6 +34,7 @@ var cfg = &ClientInfo{
 	}{
 		Interval: time.Duration(1000000000),
 	},
+	Schedule: Schedule{Interval: time.Second},
 }
 
 func TestNewBosHandler(t *testing.T) {
@@ -65,9 +79,10 @@ func TestPutObjectFromFile(t *testing.T) {
 		cfg:      *cfg,
 		log:      log.L().With(log.Any("test", "s3")),
 	}
-	err := s3Handler.PutObjectFromFile("Bucket", "Key", "./example/etc/baetyl/service-s3.yml", map[string]string{"name": "hahaha", "location": "Beijing"})
+	_, err := s3Handler.PutObjectFromFile("Bucket", "Key", "./example/etc/baetyl/service-s3.yml", map[string]string{"name": "hahaha", "location": "Beijing"})
 	assert.NotNil(t, err)
-	assert.Equal(t, "RequestCanceled: request context canceled\ncaused by: context deadline exceeded", err.Error())
+	// the upload is not bounded by the timeout of client, the request to mock region fails to send
+	assert.Contains(t, err.Error(), "RequestError: send request failed")
 }
 
 func TestFileExists(t *testing.T) {
@@ -84,3 +99,224 @@ func TestFileExists(t *testing.T) {
 	res := s3Handler.FileExists("Bucket", "var/file/service.yml", md5)
 	assert.False(t, res)
 }
+
+func TestGetObjectToFile(t *testing.T) {
+	t.Setenv("AWS_CA_BUNDLE", "")
+	delay := 0 * time.Millisecond
+	body := []byte("0123456789")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusOK)
+		// the body is sent slower than the timeout
+		for i := range body {
+			w.Write(body[i : i+1])
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	c := *cfg
+	c.Timeout = 200 * time.Millisecond
+	c.MultiPart.PartSize = 1048576
+	c.MultiPart.Concurrency = 1
+	sess, err := session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials("ak", "sk", ""),
+		Endpoint:         aws.String(srv.URL),
+		Region:           aws.String("us-east-1"),
+		DisableSSL:       aws.Bool(true),
+		S3ForcePathStyle: aws.Bool(true),
+		HTTPClient:       newHTTPClient(c),
+	})
+	assert.NoError(t, err)
+	s3Handler := &S3Handler{
+		s3Client:   s3.New(sess),
+		downloader: s3manager.NewDownloader(sess),
+		cfg:        c,
+		log:        log.L().With(log.Any("test", "s3")),
+	}
+	file := path.Join(t.TempDir(), "file")
+	err = s3Handler.GetObjectToFile("Bucket", "Key", file)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, body, data)
+
+	// the response headers not received in time
+	delay = 300 * time.Millisecond
+	err = s3Handler.GetObjectToFile("Bucket", "Key", file)
+	assert.Error(t, err)
+}
+
+func TestPutObjectThrottled(t *testing.T) {
+	t.Setenv("AWS_CA_BUNDLE", "")
+	var got []byte
+	var heads int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			got, _ = ioutil.ReadAll(r.Body)
+		case http.MethodHead:
+			heads++
+		}
+		w.Header().Set("ETag", `"etag"`)
+	}))
+	defer srv.Close()
+
+	c := *cfg
+	c.Timeout = 200 * time.Millisecond
+	c.MultiPart.PartSize = 1 << 30
+	c.MultiPart.Concurrency = 4
+	sess, err := session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials("ak", "sk", ""),
+		Endpoint:         aws.String(srv.URL),
+		Region:           aws.String("us-east-1"),
+		DisableSSL:       aws.Bool(true),
+		S3ForcePathStyle: aws.Bool(true),
+		HTTPClient:       newHTTPClient(c),
+	})
+	assert.NoError(t, err)
+	s3Handler := &S3Handler{
+		s3Client:   s3.New(sess),
+		uploader:   s3manager.NewUploader(sess),
+		cfg:        c,
+		throttlers: []*throttler{newThrottler(Throttle{Rate: 8192})},
+		log:        log.L().With(log.Any("test", "s3")),
+	}
+	file := path.Join(t.TempDir(), "file")
+	data := bytes.Repeat([]byte("a"), 4096)
+	assert.NoError(t, ioutil.WriteFile(file, data, 0644))
+
+	// the throttled body is uploaded by section reader, no buffer of part size is allocated,
+	// and the upload transferred longer than the timeout is not cancelled
+	var before, after runtime.MemStats
+	start := time.Now()
+	runtime.ReadMemStats(&before)
+	etag, err := s3Handler.PutObjectFromFile("bucket", "a/file", file, nil)
+	runtime.ReadMemStats(&after)
+	assert.NoError(t, err)
+	assert.Equal(t, "etag", etag)
+	assert.Equal(t, data, got)
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(64<<20))
+	assert.Greater(t, int64(time.Since(start)), int64(c.Timeout))
+	// the etag is of the put response, no object is headed
+	assert.Zero(t, heads)
+}
+
+func TestUploadPartThrottled(t *testing.T) {
+	t.Setenv("AWS_CA_BUNDLE", "")
+	var got []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("ETag", `"part"`)
+	}))
+	defer srv.Close()
+
+	c := *cfg
+	c.Timeout = 200 * time.Millisecond
+	sess, err := session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials("ak", "sk", ""),
+		Endpoint:         aws.String(srv.URL),
+		Region:           aws.String("us-east-1"),
+		DisableSSL:       aws.Bool(true),
+		S3ForcePathStyle: aws.Bool(true),
+		HTTPClient:       newHTTPClient(c),
+	})
+	assert.NoError(t, err)
+	s3Handler := &S3Handler{
+		s3Client:   s3.New(sess),
+		cfg:        c,
+		throttlers: []*throttler{newThrottler(Throttle{Rate: 4096})},
+		log:        log.L().With(log.Any("test", "s3")),
+	}
+	file := path.Join(t.TempDir(), "file")
+	data := bytes.Repeat([]byte("a"), 4096)
+	assert.NoError(t, ioutil.WriteFile(file, data, 0644))
+	f, err := os.Open(file)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	// the part transferred longer than the timeout is uploaded
+	start := time.Now()
+	etag, err := s3Handler.UploadPart("bucket", "a/file", "1", 1, f, 1024, 2048)
+	assert.NoError(t, err)
+	assert.Equal(t, `"part"`, etag)
+	assert.Equal(t, data[1024:3072], got)
+	assert.Greater(t, int64(time.Since(start)), int64(c.Timeout))
+}
+
+// mockHandler stores objects in local dir
+type mockHandler struct {
+	dir     string
+	puts    int
+	deletes int
+	putErr  error // the error of next put
+	check   bool  // compares the md5 of object in FileExists, never exists if false
+}
+
+func (m *mockHandler) PutObjectFromFile(Bucket, remotePath, filename string, meta map[string]string) (string, error) {
+	if err := m.putErr; err != nil {
+		m.putErr = nil
+		return "", err
+	}
+	m.puts++
+	err := copyFile(filename, path.Join(m.dir, Bucket, remotePath))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("etag-%d", m.puts), nil
+}
+
+func (m *mockHandler) GetObjectToFile(Bucket, remotePath, filename string) error {
+	return copyFile(path.Join(m.dir, Bucket, remotePath), filename)
+}
+
+func (m *mockHandler) DeleteObject(Bucket, remotePath string) error {
+	m.deletes++
+	return os.Remove(path.Join(m.dir, Bucket, remotePath))
+}
+
+func (m *mockHandler) FileExists(Bucket, remotePath, md5 string) bool {
+	if !m.check {
+		return false
+	}
+	sum, err := utils.CalculateFileMD5(path.Join(m.dir, Bucket, remotePath))
+	return err == nil && sum == md5
+}
+
+func (m *mockHandler) RefreshSts() (*v1.STSResponse, error) {
+	return nil, nil
+}
+
+func copyFile(src, dst string) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(path.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, data, 0644)
+}
+
+// newMockClient creates a client with mock handler working in temp dir
+func newMockClient(t *testing.T) (*Client, *mockHandler) {
+	dir := t.TempDir()
+	h := &mockHandler{dir: path.Join(dir, "remote")}
+	c := *cfg
+	c.Bucket = "bucket"
+	c.TempPath = path.Join(dir, "tmp")
+	c.Limit = Limit{}
+	c.Sync = SyncConfig{Path: path.Join(dir, "sync")}
+	c.Pool = Pool{Worker: 10, Idletime: time.Minute}
+	assert.NoError(t, os.MkdirAll(c.TempPath, 0755))
+	return &Client{
+		cfg:     c,
+		pwd:     path.Join(dir, "local"),
+		handler: h,
+		fs:      &FileStats{},
+		log:     log.With(log.Any("client", "mock")),
+	}, h
+}
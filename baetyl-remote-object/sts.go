@@ -12,10 +12,11 @@ const (
 	StsUrl = "/agent/sts"
 )
 
-func GetSts(cli *http.Client) (*v1.STSResponse, error) {
+// GetSts requests the temporary credentials of the type, such as minio, from baetyl core
+func GetSts(cli *http.Client, stsType string) (*v1.STSResponse, error) {
 	var err error
 	req := &v1.STSRequest{
-		STSType: "minio",
+		STSType: stsType,
 	}
 	reqBytes, err := json.Marshal(req)
 	if err != nil {
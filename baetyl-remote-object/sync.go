@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/baetyl/baetyl-go/v2/utils"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// syncIndex records the files synced from local directory to remote prefix,
+// the file is uploaded again only if its size, modification time and md5 changed
+type syncIndex struct {
+	Bucket     string                `yaml:"bucket" json:"bucket"`
+	RemotePath string                `yaml:"remotePath" json:"remotePath"`
+	LocalPath  string                `yaml:"localPath" json:"localPath"`
+	Files      map[string]syncedFile `yaml:"files" json:"files"`
+}
+
+// syncedFile the state of file when it was synced
+type syncedFile struct {
+	Size    int64     `yaml:"size" json:"size"`
+	ModTime time.Time `yaml:"modTime" json:"modTime"`
+	MD5     string    `yaml:"md5" json:"md5"`
+	ETag    string    `yaml:"etag,omitempty" json:"etag,omitempty"`
+}
+
+func (cli *Client) handleSyncEvent(e *SyncEvent) (*Result, error) {
+	if strings.Contains(e.LocalPath, "..") {
+		return nil, errors.Errorf("failed to pass LocalPath (%s) check: the local path can't contains ..", e.LocalPath)
+	}
+	root, err := filepath.EvalSymlinks(path.Join(cli.pwd, e.LocalPath))
+	if err != nil {
+		atomic.AddUint64(&cli.fs.deleted, 1)
+		return nil, errors.Errorf("failed get real dir path: %s", err.Error())
+	}
+	if !utils.DirExists(root) {
+		return nil, errors.Errorf("failed to sync path (%s): not a directory", e.LocalPath)
+	}
+
+	file := cli.syncFile(e)
+	// the syncs of the same directory and prefix are exclusive to keep the index consistent
+	if _, running := cli.syncing.LoadOrStore(file, true); running {
+		return nil, errors.Errorf("failed to sync path (%s): another sync is in progress", e.LocalPath)
+	}
+	defer cli.syncing.Delete(file)
+	idx, err := loadSyncIndex(file)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	idx.Bucket, idx.RemotePath, idx.LocalPath = cli.cfg.Bucket, e.RemotePath, e.LocalPath
+
+	res := &Result{
+		Bucket:     cli.cfg.Bucket,
+		RemotePath: e.RemotePath,
+		LocalPath:  e.LocalPath,
+	}
+	var errs []error
+	err = filepath.Walk(root, func(fp string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, fp)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !matchFile(name, e.Include, e.Exclude) {
+			return nil
+		}
+		if err := cli.syncFileTo(idx, name, fp, info, e, res); err != nil {
+			errs = append(errs, err)
+		}
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, errors.Errorf("failed to walk path (%s): %s", e.LocalPath, err.Error()))
+	} else {
+		// the remote objects are not deleted if the walk is incomplete
+		errs = append(errs, cli.syncDeleted(idx, root, e, res)...)
+	}
+	if err = saveSyncIndex(file, idx); err != nil {
+		cli.log.Warn("failed to save sync index", log.Any("file", file), log.Error(err))
+	}
+	if len(errs) > 0 {
+		return res, errors.Errorf("failed to sync %d files: %s", len(errs), errs[0].Error())
+	}
+	if res.Uploaded == 0 && res.Deleted == 0 {
+		res.Status = StatusSkipped
+	}
+	return res, nil
+}
+
+// syncFileTo uploads the file if it is new or changed since last sync
+func (cli *Client) syncFileTo(idx *syncIndex, name, fp string, info os.FileInfo, e *SyncEvent, res *Result) error {
+	old, ok := idx.Files[name]
+	if ok && old.Size == info.Size() {
+		if old.ModTime.Equal(info.ModTime()) {
+			res.Skipped++
+			return nil
+		}
+		// the file touched but not changed
+		if _, md5 := cli.fileSizeMd5(fp); md5 != "" && md5 == old.MD5 {
+			old.ModTime = info.ModTime()
+			idx.Files[name] = old
+			res.Skipped++
+			return nil
+		}
+	}
+	r, err := cli.upload(fp, cli.cfg.Bucket, path.Join(e.RemotePath, name), e.Meta, nil)
+	if err != nil {
+		return errors.Errorf("failed to upload file (%s): %s", name, err.Error())
+	}
+	idx.Files[name] = syncedFile{
+		Size:    r.Size,
+		ModTime: info.ModTime(),
+		MD5:     r.MD5,
+		ETag:    r.ETag,
+	}
+	if r.Status == StatusSkipped {
+		res.Skipped++
+		return nil
+	}
+	res.Uploaded++
+	res.Size += r.Size
+	return nil
+}
+
+// syncDeleted removes the files not found locally from index, and deletes their remote objects if required
+func (cli *Client) syncDeleted(idx *syncIndex, root string, e *SyncEvent, res *Result) []error {
+	var names []string
+	for name := range idx.Files {
+		if _, err := os.Lstat(path.Join(root, name)); os.IsNotExist(err) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names {
+		if e.Delete {
+			remotePath, err := cli.refreshSts(path.Join(e.RemotePath, name))
+			if err == nil {
+				err = cli.handler.DeleteObject(cli.cfg.Bucket, remotePath)
+			}
+			if err != nil {
+				errs = append(errs, errors.Errorf("failed to delete object (%s): %s", name, err.Error()))
+				continue
+			}
+			cli.log.Info("delete object successfully", log.Any("remotePath", remotePath), log.Any("bucket", cli.cfg.Bucket))
+			res.Deleted++
+		}
+		delete(idx.Files, name)
+	}
+	return errs
+}
+
+// syncFile returns the index file of sync
+func (cli *Client) syncFile(e *SyncEvent) string {
+	sum := md5.Sum([]byte(cli.cfg.Bucket + "/" + path.Clean(e.RemotePath) + "\x00" + path.Clean(e.LocalPath)))
+	return path.Join(cli.cfg.Sync.Path, hex.EncodeToString(sum[:])+".yml")
+}
+
+func loadSyncIndex(file string) (*syncIndex, error) {
+	idx := &syncIndex{}
+	if utils.FileExists(file) {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		err = yaml.Unmarshal(data, idx)
+		if err != nil {
+			return nil, errors.Errorf("failed to load sync index (%s): %s", file, err.Error())
+		}
+	}
+	if idx.Files == nil {
+		idx.Files = make(map[string]syncedFile)
+	}
+	return idx, nil
+}
+
+func saveSyncIndex(file string, idx *syncIndex) error {
+	err := os.MkdirAll(path.Dir(file), 0755)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	data, err := yaml.Marshal(idx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	t := file + ".tmp"
+	err = ioutil.WriteFile(t, data, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(t, file))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleSyncEvent(t *testing.T) {
+	cli, h := newMockClient(t)
+	dir := path.Join(cli.pwd, "logs")
+	assert.NoError(t, os.MkdirAll(path.Join(dir, "sub"), 0755))
+	assert.NoError(t, ioutil.WriteFile(path.Join(dir, "a.log"), []byte("a"), 0644))
+	assert.NoError(t, ioutil.WriteFile(path.Join(dir, "sub", "b.log"), []byte("b"), 0644))
+	assert.NoError(t, ioutil.WriteFile(path.Join(dir, "c.tmp"), []byte("c"), 0644))
+	e := &SyncEvent{RemotePath: "remote/logs", LocalPath: "logs", Exclude: []string{"*.tmp"}, Delete: true}
+
+	// round 1: local path can't contain .. and must be a directory
+	_, err := cli.handleSyncEvent(&SyncEvent{RemotePath: "remote", LocalPath: "../logs"})
+	assert.Error(t, err)
+	_, err = cli.handleSyncEvent(&SyncEvent{RemotePath: "remote", LocalPath: "logs/a.log"})
+	assert.Error(t, err)
+
+	// round 2: all files uploaded
+	res, err := cli.handleSyncEvent(e)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, res.Uploaded)
+	assert.Equal(t, int64(2), res.Size)
+	assert.Equal(t, 2, h.puts)
+	assert.True(t, utils.FileExists(path.Join(h.dir, "bucket", "remote/logs/a.log")))
+	assert.True(t, utils.FileExists(path.Join(h.dir, "bucket", "remote/logs/sub/b.log")))
+	assert.False(t, utils.FileExists(path.Join(h.dir, "bucket", "remote/logs/c.tmp")))
+
+	// round 3: nothing changed, the touched file is checked by md5
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(path.Join(dir, "a.log"), later, later))
+	res, err = cli.handleSyncEvent(e)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusSkipped, res.Status)
+	assert.Equal(t, 2, res.Skipped)
+	assert.Equal(t, 2, h.puts)
+
+	// round 4: the changed file uploaded and the removed file deleted
+	assert.NoError(t, ioutil.WriteFile(path.Join(dir, "a.log"), []byte("aa"), 0644))
+	assert.NoError(t, os.Remove(path.Join(dir, "sub", "b.log")))
+	res, err = cli.handleSyncEvent(e)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res.Uploaded)
+	assert.Equal(t, 1, res.Deleted)
+	assert.Equal(t, 3, h.puts)
+	assert.Equal(t, 1, h.deletes)
+	data, err := ioutil.ReadFile(path.Join(h.dir, "bucket", "remote/logs/a.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "aa", string(data))
+	assert.False(t, utils.FileExists(path.Join(h.dir, "bucket", "remote/logs/sub/b.log")))
+
+	// round 5: the remote object is kept if delete is not set
+	assert.NoError(t, os.Remove(path.Join(dir, "a.log")))
+	e.Delete = false
+	res, err = cli.handleSyncEvent(e)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, res.Deleted)
+	assert.Equal(t, 1, h.deletes)
+	assert.True(t, utils.FileExists(path.Join(h.dir, "bucket", "remote/logs/a.log")))
+	idx, err := loadSyncIndex(cli.syncFile(e))
+	assert.NoError(t, err)
+	assert.Len(t, idx.Files, 0)
+}
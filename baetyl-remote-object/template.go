@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/context"
+	"github.com/baetyl/baetyl-go/v2/errors"
+)
+
+// pathVars the variables of remote path template, such as
+// {{.NodeName}}/{{.Date "2006/01/02"}}/{{.TopicSegment 2}}/{{.Filename}}
+type pathVars struct {
+	NodeName    string
+	Namespace   string // the namespace of service on edge
+	ServiceName string
+	Topic       string            // the topic of event received, empty if the file is found by watcher
+	RemotePath  string            // the remote path of event, empty if the file is found by watcher
+	LocalPath   string            // the local path of file relative to the work directory
+	Path        string            // the path relative to the watched directory, the local path if not watched
+	Dir         string            // the directory of path
+	Filename    string            // the base name of path
+	Ext         string            // the extension of filename without dot
+	Meta        map[string]string // the meta of event or watcher
+	Time        time.Time         // the time of event, or the time found by watcher
+
+	file string // the local file to calculate md5
+	md5  string
+}
+
+func newPathVars(pwd, localPath, p string) *pathVars {
+	return &pathVars{
+		LocalPath: localPath,
+		Path:      p,
+		Dir:       path.Dir(p),
+		Filename:  path.Base(p),
+		Ext:       strings.TrimPrefix(path.Ext(p), "."),
+		Time:      time.Now(),
+		file:      path.Join(pwd, localPath),
+	}
+}
+
+// Date formats the time in local time by layout of go, such as 2006/01/02
+func (v *pathVars) Date(layout string) string {
+	return v.Time.Local().Format(layout)
+}
+
+// TopicSegment returns the segment of topic at index starting with 0, such as 1 of a/b/c is b
+func (v *pathVars) TopicSegment(i int) (string, error) {
+	segments := strings.Split(v.Topic, "/")
+	if v.Topic == "" || i < 0 || i >= len(segments) {
+		return "", errors.Errorf("segment (%d) of topic (%s) not found", i, v.Topic)
+	}
+	return segments[i], nil
+}
+
+// MD5 returns the md5 of local file in hex, which is calculated only if referenced
+func (v *pathVars) MD5() (string, error) {
+	if v.md5 != "" {
+		return v.md5, nil
+	}
+	f, err := os.Open(v.file)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", errors.Errorf("failed to calculate md5 of file (%s): %s", v.LocalPath, err.Error())
+	}
+	v.md5 = hex.EncodeToString(h.Sum(nil))
+	return v.md5, nil
+}
+
+// pathTemplate renders the remote path by template, so devices don't need to know the bucket layout
+type pathTemplate struct {
+	tpl       *template.Template
+	node      string
+	namespace string
+	service   string
+}
+
+// newPathTemplate parses the template of remote path, returns nil if the text is empty
+func newPathTemplate(text string, ctx context.Context) (*pathTemplate, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tpl, err := template.New("remotePath").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.Errorf("failed to parse remote path template (%s): %s", text, err.Error())
+	}
+	p := &pathTemplate{
+		tpl:       tpl,
+		namespace: context.EdgeNamespace(),
+	}
+	if ctx != nil {
+		p.node = ctx.NodeName()
+		p.service = ctx.ServiceName()
+	}
+	return p, nil
+}
+
+func (p *pathTemplate) render(v *pathVars) (string, error) {
+	v.NodeName, v.Namespace, v.ServiceName = p.node, p.namespace, p.service
+	var buf bytes.Buffer
+	err := p.tpl.Execute(&buf, v)
+	if err != nil {
+		return "", errors.Errorf("failed to render remote path: %s", err.Error())
+	}
+	res := strings.TrimPrefix(path.Clean(buf.String()), "/")
+	if res == "" || res == "." {
+		return "", errors.New("failed to render remote path: the remote path is empty")
+	}
+	return res, nil
+}
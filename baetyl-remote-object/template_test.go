@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathTemplate(t *testing.T) {
+	tpl, err := newPathTemplate("", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, tpl)
+	_, err = newPathTemplate("{{.Path", nil)
+	assert.Error(t, err)
+
+	pwd := t.TempDir()
+	assert.NoError(t, os.MkdirAll(path.Join(pwd, "var", "log"), 0755))
+	assert.NoError(t, ioutil.WriteFile(path.Join(pwd, "var", "log", "a.log"), []byte("a"), 0644))
+	sum := md5.Sum([]byte("a"))
+
+	tpl, err = newPathTemplate(`/{{.Namespace}}/{{.Date "2006/01/02"}}/{{.TopicSegment 1}}/{{.Meta.type}}/{{.MD5}}.{{.Ext}}`, nil)
+	assert.NoError(t, err)
+	vars := newPathVars(pwd, "var/log/a.log", "var/log/a.log")
+	vars.Topic = "device/d1/upload"
+	vars.Meta = map[string]string{"type": "log"}
+	vars.Time = time.Date(2021, 3, 4, 12, 0, 0, 0, time.Local)
+	got, err := tpl.render(vars)
+	assert.NoError(t, err)
+	assert.Equal(t, "baetyl-edge/2021/03/04/d1/log/"+hex.EncodeToString(sum[:])+".log", got)
+
+	tpl, err = newPathTemplate(`{{.Dir}}/{{.Filename}}`, nil)
+	assert.NoError(t, err)
+	got, err = tpl.render(newPathVars(pwd, "var/log/a.log", "var/log/a.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "var/log/a.log", got)
+
+	// the segment out of range, the meta missing and the file not found are errors
+	tpl, err = newPathTemplate(`{{.TopicSegment 3}}`, nil)
+	assert.NoError(t, err)
+	_, err = tpl.render(vars)
+	assert.Error(t, err)
+	tpl, err = newPathTemplate(`{{.Meta.none}}`, nil)
+	assert.NoError(t, err)
+	_, err = tpl.render(vars)
+	assert.Error(t, err)
+	tpl, err = newPathTemplate(`{{.MD5}}`, nil)
+	assert.NoError(t, err)
+	_, err = tpl.render(newPathVars(pwd, "none", "none"))
+	assert.Error(t, err)
+	tpl, err = newPathTemplate(`{{.RemotePath}}`, nil)
+	assert.NoError(t, err)
+	_, err = tpl.render(vars)
+	assert.Error(t, err)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// the max bytes read at once by throttled reader, to smooth the bandwidth
+const throttleChunk = 32 * 1024
+
+// globalThrottler the bandwidth limit shared by all clients
+var globalThrottler = newThrottler(Throttle{})
+
+// throttler limits the bandwidth by token bucket, the burst is the bytes of one second
+type throttler struct {
+	cfg    Throttle
+	tokens float64
+	last   time.Time
+	lock   sync.Mutex
+}
+
+func newThrottler(cfg Throttle) *throttler {
+	return &throttler{cfg: cfg}
+}
+
+// enabled reports whether any rate is limited
+func (t *throttler) enabled() bool {
+	return t != nil && (t.cfg.Rate > 0 || len(t.cfg.Schedules) > 0)
+}
+
+// rate returns the rate of the first schedule matched, otherwise the default rate
+func (t *throttler) rate(now time.Time) int64 {
+	for _, s := range t.cfg.Schedules {
+		if s.contains(now) {
+			return s.Rate
+		}
+	}
+	return t.cfg.Rate
+}
+
+// wait reserves n bytes and returns the time to wait before sending them
+func (t *throttler) wait(n int, now time.Time) time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	rate := t.rate(now)
+	if rate <= 0 {
+		t.tokens, t.last = 0, now
+		return 0
+	}
+	if !t.last.IsZero() {
+		t.tokens += now.Sub(t.last).Seconds() * float64(rate)
+	}
+	if t.tokens > float64(rate) {
+		t.tokens = float64(rate)
+	}
+	t.last = now
+	t.tokens -= float64(n)
+	if t.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-t.tokens / float64(rate) * float64(time.Second))
+}
+
+// throttledReader reads no faster than the rates of throttlers
+type throttledReader struct {
+	io.ReadSeeker
+	throttlers []*throttler
+}
+
+// throttledReaderAt the throttled reader of which the ReadAt is throttled too, so that the reader at of body is kept,
+// e.g. s3manager uploads the parts by section readers without copying them into buffers of part size
+type throttledReaderAt struct {
+	*throttledReader
+	at io.ReaderAt
+}
+
+// throttled returns the reader limited by the enabled throttlers, the reader itself is returned if none enabled.
+// The reader returned implements io.ReaderAt if r does
+func throttled(r io.ReadSeeker, throttlers ...*throttler) io.ReadSeeker {
+	var ts []*throttler
+	for _, t := range throttlers {
+		if t.enabled() {
+			ts = append(ts, t)
+		}
+	}
+	if len(ts) == 0 {
+		return r
+	}
+	tr := &throttledReader{ReadSeeker: r, throttlers: ts}
+	if at, ok := r.(io.ReaderAt); ok {
+		return &throttledReaderAt{throttledReader: tr, at: at}
+	}
+	return tr
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := r.ReadSeeker.Read(p)
+	r.wait(n)
+	return n, err
+}
+
+// ReadAt reads len(p) bytes in chunks, each chunk waits for the throttlers
+func (r *throttledReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	var total int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > throttleChunk {
+			chunk = chunk[:throttleChunk]
+		}
+		n, err := r.at.ReadAt(chunk, off)
+		r.wait(n)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		p, off = p[n:], off+int64(n)
+	}
+	return total, nil
+}
+
+func (r *throttledReader) wait(n int) {
+	for _, t := range r.throttlers {
+		if d := t.wait(n, time.Now()); d > 0 {
+			time.Sleep(d)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThrottleConfig(t *testing.T) {
+	var cfg Throttle
+	err := utils.UnmarshalYAML([]byte(`
+rate: 1m
+schedules:
+  - start: "09:00"
+    end: "18:00"
+    rate: 128k
+  - start: "22:00"
+    end: "06:00"
+`), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1048576), cfg.Rate)
+	assert.Len(t, cfg.Schedules, 2)
+	assert.Equal(t, Window{Start: "09:00", End: "18:00"}, cfg.Schedules[0].Window)
+	assert.Equal(t, int64(131072), cfg.Schedules[0].Rate)
+	assert.Equal(t, int64(0), cfg.Schedules[1].Rate)
+
+	err = utils.UnmarshalYAML([]byte(`
+schedules:
+  - start: "9"
+    end: "18:00"
+`), &cfg)
+	assert.Error(t, err)
+}
+
+func TestThrottlerWait(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.Local)
+	th := newThrottler(Throttle{Rate: 100})
+	assert.True(t, th.enabled())
+	assert.Equal(t, time.Duration(0), th.wait(0, now))
+	assert.Equal(t, time.Second/2, th.wait(50, now))
+	// tokens refilled
+	assert.Equal(t, time.Duration(0), th.wait(50, now.Add(time.Second)))
+	// burst of one second at most
+	assert.Equal(t, time.Second, th.wait(200, now.Add(time.Hour)))
+
+	// unlimited in schedule
+	th = newThrottler(Throttle{Rate: 100, Schedules: []ThrottleSchedule{{Window: Window{Start: "22:00", End: "06:00"}}}})
+	assert.Equal(t, int64(100), th.rate(now))
+	night := time.Date(2020, 1, 1, 23, 0, 0, 0, time.Local)
+	assert.Equal(t, int64(0), th.rate(night))
+	assert.Equal(t, time.Duration(0), th.wait(1000, night))
+
+	assert.False(t, newThrottler(Throttle{}).enabled())
+	var nilThrottler *throttler
+	assert.False(t, nilThrottler.enabled())
+}
+
+func TestThrottledReader(t *testing.T) {
+	data := make([]byte, 128*1024)
+	r := bytes.NewReader(data)
+	assert.Equal(t, r, throttled(r, newThrottler(Throttle{}), nil))
+
+	start := time.Now()
+	got, err := ioutil.ReadAll(throttled(r, newThrottler(Throttle{Rate: 256 * 1024}), newThrottler(Throttle{})))
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+	assert.True(t, time.Since(start) >= 400*time.Millisecond)
+
+	// the reader at is kept and throttled
+	tr := throttled(r, newThrottler(Throttle{Rate: 256 * 1024}))
+	at, ok := tr.(io.ReaderAt)
+	assert.True(t, ok)
+	got = make([]byte, len(data)-1)
+	start = time.Now()
+	n, err := at.ReadAt(got, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, len(data)-1, n)
+	assert.Equal(t, data[1:], got)
+	assert.True(t, time.Since(start) >= 400*time.Millisecond)
+	n, err = at.ReadAt(got, 2)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, len(data)-2, n)
+
+	// the reader without ReadAt is not extended
+	_, ok = throttled(struct{ io.ReadSeeker }{r}, newThrottler(Throttle{Rate: 256 * 1024})).(io.ReaderAt)
+	assert.False(t, ok)
+}
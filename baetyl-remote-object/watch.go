@@ -0,0 +1,475 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/context"
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/baetyl/baetyl-go/v2/utils"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The actions of watcher after the file uploaded
+const (
+	AfterKeep   = "keep"
+	AfterDelete = "delete"
+	AfterMove   = "move"
+)
+
+// notification the change of file notified
+type notification struct {
+	path   string
+	closed bool // the file is closed after written or moved in
+}
+
+// notifier notifies the changes of directories
+type notifier interface {
+	add(dir string) error
+	notifications() <-chan notification
+	close() error
+}
+
+// watchedFile the state of file found by watcher
+type watchedFile struct {
+	root     string // the watched directory containing the file
+	size     int64
+	modTime  time.Time
+	since    time.Time // the time since the file unchanged
+	closed   bool
+	inflight bool
+	done     bool
+	retries  int       // the failures since the file changed
+	next     time.Time // the file failed is not submitted again before the time
+}
+
+// watchIndex records the files uploaded and kept by watcher, so they are not uploaded again after restart
+type watchIndex struct {
+	Rule  string                `yaml:"rule" json:"rule"`
+	Files map[string]syncedFile `yaml:"files" json:"files"`
+}
+
+// watcher uploads the files in local directories once they are stable
+type watcher struct {
+	cfg      Watch
+	rule     string
+	cli      *Client
+	target   dispatcher // the client by default, or the fanout of rule with multiple targets
+	cb       ruleHook
+	tpl      *pathTemplate
+	notifier notifier
+	files    map[string]*watchedFile
+	index    *watchIndex
+	file     string // the file of index
+	lock     sync.Mutex
+	tomb     utils.Tomb
+	log      *log.Logger
+}
+
+func newWatcher(ctx context.Context, cfg Watch, rule string, cli *Client, cb ruleHook) (*watcher, error) {
+	for _, p := range cfg.Paths {
+		if strings.Contains(p, "..") {
+			return nil, errors.Errorf("failed to pass path (%s) check: the path can't contains ..", p)
+		}
+	}
+	switch cfg.After {
+	case AfterKeep, AfterDelete, AfterMove:
+	default:
+		return nil, errors.Errorf("after (%s) of watch invalid, should be keep, delete or move", cfg.After)
+	}
+	if cfg.After == AfterMove && cfg.MoveTo == "" {
+		return nil, errors.New("moveTo is required if after is move")
+	}
+	if cfg.RemotePath == "" {
+		return nil, errors.New("remotePath of watch is required")
+	}
+	tpl, err := newPathTemplate(cfg.RemotePath, ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	sum := md5.Sum([]byte("watch\x00" + rule))
+	file := path.Join(cli.cfg.Sync.Path, hex.EncodeToString(sum[:])+".yml")
+	idx, err := loadWatchIndex(file)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	idx.Rule = rule
+	w := &watcher{
+		cfg:    cfg,
+		rule:   rule,
+		cli:    cli,
+		target: cli,
+		cb:     cb,
+		tpl:    tpl,
+		files:  make(map[string]*watchedFile),
+		index:  idx,
+		file:   file,
+		log:    log.With(log.Any("rule", rule), log.Any("source", "watch")),
+	}
+	w.notifier, err = newNotifier()
+	if err != nil {
+		w.log.Warn("failed to create notifier, fall back to polling", log.Error(err))
+	}
+	return w, nil
+}
+
+// start starts to watch the directories
+func (w *watcher) start() error {
+	return w.tomb.Go(w.watching)
+}
+
+// resume submits the tasks of rule journaled before restart, their files are marked inflight
+// so that the first scan doesn't submit them again
+func (w *watcher) resume() error {
+	msgs, err := w.cli.Pending(w.rule)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	w.lock.Lock()
+	for _, msg := range msgs {
+		e, ok := msg.Event.Content.(*UploadEvent)
+		if !ok {
+			continue
+		}
+		fp := path.Join(w.cli.pwd, e.LocalPath)
+		root, ok := w.root(fp)
+		if !ok {
+			continue
+		}
+		f := &watchedFile{root: root, since: time.Now(), inflight: true}
+		if info, err := os.Stat(fp); err == nil {
+			f.size, f.modTime = info.Size(), info.ModTime()
+		}
+		w.files[fp] = f
+	}
+	w.lock.Unlock()
+	return errors.Trace(w.target.Resume(w.rule, w.callback))
+}
+
+func (w *watcher) watching() error {
+	defer w.log.Debug("watcher stopped")
+	var notifications <-chan notification
+	if w.notifier != nil {
+		notifications = w.notifier.notifications()
+	}
+	t := time.NewTicker(w.cfg.Interval)
+	defer t.Stop()
+	w.scan(time.Now())
+	for {
+		select {
+		case <-w.tomb.Dying():
+			return nil
+		case n, ok := <-notifications:
+			if !ok {
+				notifications = nil
+				continue
+			}
+			if n.closed {
+				w.checkClosed(n.path, time.Now())
+			}
+		case <-t.C:
+			w.scan(time.Now())
+		}
+	}
+}
+
+// scan walks the directories and submits the files stable
+func (w *watcher) scan(now time.Time) {
+	found := make(map[string]bool)
+	for _, p := range w.cfg.Paths {
+		root := path.Join(w.cli.pwd, p)
+		err := filepath.Walk(root, func(fp string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info.IsDir() {
+				if fp != root && !w.cfg.Recursive {
+					return filepath.SkipDir
+				}
+				if w.notifier != nil {
+					if err := w.notifier.add(fp); err != nil {
+						w.log.Warn("failed to watch dir", log.Any("dir", fp), log.Error(err))
+					}
+				}
+				return nil
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			if !w.match(root, fp) {
+				return nil
+			}
+			found[fp] = true
+			w.check(root, fp, info, now, false)
+			return nil
+		})
+		if err != nil {
+			w.log.Warn("failed to scan dir", log.Any("dir", root), log.Error(err))
+		}
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for fp, f := range w.files {
+		if !found[fp] && !f.inflight {
+			delete(w.files, fp)
+		}
+	}
+	changed := false
+	for local := range w.index.Files {
+		if !found[path.Join(w.cli.pwd, local)] {
+			delete(w.index.Files, local)
+			changed = true
+		}
+	}
+	if changed {
+		w.saveIndex()
+	}
+}
+
+// checkClosed checks the file notified closed only, instead of scanning all directories
+func (w *watcher) checkClosed(fp string, now time.Time) {
+	root, ok := w.root(fp)
+	if !ok || !w.match(root, fp) {
+		return
+	}
+	info, err := os.Stat(fp)
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+	w.check(root, fp, info, now, true)
+}
+
+// root returns the watched directory containing the file
+func (w *watcher) root(fp string) (string, bool) {
+	for _, p := range w.cfg.Paths {
+		root := path.Join(w.cli.pwd, p)
+		rel, err := filepath.Rel(root, fp)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		if !w.cfg.Recursive && path.Dir(fp) != root {
+			continue
+		}
+		return root, true
+	}
+	return "", false
+}
+
+// match reports whether the file in the watched directory is included
+func (w *watcher) match(root, fp string) bool {
+	rel, err := filepath.Rel(root, fp)
+	return err == nil && matchFile(filepath.ToSlash(rel), w.cfg.Include, w.cfg.Exclude)
+}
+
+// check updates the state of file and submits it if stable, the file uploaded before restart is skipped if unchanged
+func (w *watcher) check(root, fp string, info os.FileInfo, now time.Time, closed bool) {
+	w.lock.Lock()
+	f, ok := w.files[fp]
+	if !ok {
+		f = &watchedFile{root: root, since: now}
+		if local, err := filepath.Rel(w.cli.pwd, fp); err == nil {
+			if s, ok := w.index.Files[filepath.ToSlash(local)]; ok {
+				f.size, f.modTime, f.done = s.Size, s.ModTime, true
+			}
+		}
+		w.files[fp] = f
+	}
+	if f.size != info.Size() || !f.modTime.Equal(info.ModTime()) {
+		f.size, f.modTime, f.since = info.Size(), info.ModTime(), now
+		f.closed, f.done = false, false
+		f.retries, f.next = 0, time.Time{}
+	}
+	if closed {
+		f.closed = true
+	}
+	ready := !f.inflight && !f.done && !now.Before(f.next) && (f.closed || now.Sub(f.since) >= w.cfg.Stable)
+	if ready {
+		f.inflight = true
+	}
+	w.lock.Unlock()
+	if ready {
+		w.submit(root, fp)
+	}
+}
+
+func (w *watcher) submit(root, fp string) {
+	err := w.call(root, fp)
+	if err != nil {
+		w.log.Error("failed to submit file", log.Any("file", fp), log.Error(err))
+		w.lock.Lock()
+		if f, ok := w.files[fp]; ok {
+			f.inflight = false
+			f.retries++
+			f.next = time.Now().Add(w.backoff(f.retries))
+		}
+		w.lock.Unlock()
+	}
+}
+
+func (w *watcher) call(root, fp string) error {
+	rel, err := filepath.Rel(root, fp)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	local, err := filepath.Rel(w.cli.pwd, fp)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	local = filepath.ToSlash(local)
+	vars := newPathVars(w.cli.pwd, local, filepath.ToSlash(rel))
+	vars.Meta = w.cfg.Meta
+	remotePath, err := w.tpl.render(vars)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	msg := &EventMessage{
+		Event: &Event{
+			Time: time.Now(),
+			Type: Upload,
+			Content: &UploadEvent{
+				RemotePath: remotePath,
+				LocalPath:  local,
+				Meta:       w.cfg.Meta,
+			},
+		},
+	}
+	_, err = w.target.Journal(w.rule, msg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return w.target.CallAsync(msg, w.callback)
+}
+
+// callback handles the file after uploaded, the file failed is submitted again after the backoff
+func (w *watcher) callback(msg *EventMessage, res *Result, err error) {
+	if e, ok := msg.Event.Content.(*UploadEvent); ok {
+		fp := path.Join(w.cli.pwd, e.LocalPath)
+		if err == nil {
+			if e := w.after(fp); e != nil {
+				w.log.Error("failed to handle file uploaded", log.Any("file", fp), log.Error(e))
+			}
+		}
+		now := time.Now()
+		w.lock.Lock()
+		if f, ok := w.files[fp]; ok {
+			f.inflight = false
+			f.done = err == nil
+			if err != nil {
+				f.retries++
+				f.next = now.Add(w.backoff(f.retries))
+			} else if w.cfg.After == AfterKeep {
+				w.index.Files[filepath.ToSlash(e.LocalPath)] = syncedFile{Size: f.size, ModTime: f.modTime}
+				w.saveIndex()
+			}
+		}
+		w.lock.Unlock()
+	}
+	if w.cb != nil {
+		w.cb(msg, res, err)
+	}
+}
+
+// backoff returns the delay before the file failed is submitted again, doubled by every failure up to the max delay
+func (w *watcher) backoff(retries int) time.Duration {
+	b := w.cli.cfg.Backoff
+	d := b.Base
+	for i := 1; i < retries && d < b.Delay; i++ {
+		d *= 2
+	}
+	if b.Delay > 0 && d > b.Delay {
+		d = b.Delay
+	}
+	return d
+}
+
+// saveIndex saves the index of files uploaded, must be called with the lock held
+func (w *watcher) saveIndex() {
+	if err := saveWatchIndex(w.file, w.index); err != nil {
+		w.log.Error("failed to save index of files uploaded", log.Any("file", w.file), log.Error(err))
+	}
+}
+
+func loadWatchIndex(file string) (*watchIndex, error) {
+	idx := &watchIndex{}
+	if utils.FileExists(file) {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		err = yaml.Unmarshal(data, idx)
+		if err != nil {
+			return nil, errors.Errorf("failed to load watch index (%s): %s", file, err.Error())
+		}
+	}
+	if idx.Files == nil {
+		idx.Files = make(map[string]syncedFile)
+	}
+	return idx, nil
+}
+
+func saveWatchIndex(file string, idx *watchIndex) error {
+	err := os.MkdirAll(path.Dir(file), 0755)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	data, err := yaml.Marshal(idx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	t := file + ".tmp"
+	err = ioutil.WriteFile(t, data, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(t, file))
+}
+
+// after deletes or moves the file uploaded
+func (w *watcher) after(fp string) error {
+	switch w.cfg.After {
+	case AfterDelete:
+		err := os.Remove(fp)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Trace(err)
+		}
+	case AfterMove:
+		w.lock.Lock()
+		f, ok := w.files[fp]
+		w.lock.Unlock()
+		root := path.Dir(fp)
+		if ok {
+			root = f.root
+		}
+		rel, err := filepath.Rel(root, fp)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		dst := path.Join(w.cli.pwd, w.cfg.MoveTo, rel)
+		err = os.MkdirAll(path.Dir(dst), 0755)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		err = os.Rename(fp, dst)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// close stops watching
+func (w *watcher) close() {
+	w.tomb.Kill(nil)
+	w.tomb.Wait()
+	if w.notifier != nil {
+		w.notifier.close()
+	}
+}
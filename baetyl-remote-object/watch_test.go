@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/panjf2000/ants"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMockWatcher(t *testing.T, cfg Watch) (*watcher, *mockHandler, chan *Result) {
+	cli, h := newMockClient(t)
+	var err error
+	cli.pool, err = ants.NewPoolWithFunc(cli.cfg.Pool.Worker, cli.call)
+	assert.NoError(t, err)
+	t.Cleanup(cli.pool.Release)
+	assert.NoError(t, os.MkdirAll(path.Join(cli.pwd, "data", "sub"), 0755))
+	results := make(chan *Result, 10)
+	w, err := newWatcher(nil, cfg, "rule", cli, func(msg *EventMessage, res *Result, err error) {
+		results <- res
+	})
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		if w.notifier != nil {
+			w.notifier.close()
+		}
+	})
+	return w, h, results
+}
+
+func nextResult(t *testing.T, results chan *Result) *Result {
+	select {
+	case res := <-results:
+		return res
+	case <-time.After(3 * time.Second):
+		t.Fatal("no result")
+		return nil
+	}
+}
+
+func TestWatcherScan(t *testing.T) {
+	cfg := Watch{
+		Paths:      []string{"data"},
+		Exclude:    []string{"*.tmp"},
+		RemotePath: "logs/{{.Ext}}/{{.Filename}}",
+		Stable:     time.Minute,
+		After:      AfterKeep,
+	}
+	w, h, results := newMockWatcher(t, cfg)
+	pwd := w.cli.pwd
+	assert.NoError(t, ioutil.WriteFile(path.Join(pwd, "data", "a.log"), []byte("a"), 0644))
+	assert.NoError(t, ioutil.WriteFile(path.Join(pwd, "data", "b.tmp"), []byte("b"), 0644))
+	assert.NoError(t, ioutil.WriteFile(path.Join(pwd, "data", "sub", "c.log"), []byte("c"), 0644))
+
+	// not stable
+	now := time.Now()
+	w.scan(now)
+	assert.Len(t, w.files, 1)
+	assert.Len(t, results, 0)
+
+	// stable, the excluded and sub directory are ignored
+	w.scan(now.Add(time.Minute))
+	res := nextResult(t, results)
+	assert.Equal(t, StatusSucceeded, res.Status)
+	assert.Equal(t, "logs/log/a.log", res.RemotePath)
+	assert.True(t, utils.FileExists(path.Join(h.dir, "bucket", "logs/log/a.log")))
+	assert.True(t, utils.FileExists(path.Join(pwd, "data", "a.log")))
+
+	// uploaded once if unchanged
+	w.scan(now.Add(2 * time.Minute))
+	assert.Len(t, results, 0)
+	assert.Equal(t, 1, h.puts)
+
+	// uploaded again if changed and closed
+	assert.NoError(t, ioutil.WriteFile(path.Join(pwd, "data", "a.log"), []byte("aa"), 0644))
+	w.checkClosed(path.Join(pwd, "data", "a.log"), now.Add(2*time.Minute))
+	res = nextResult(t, results)
+	assert.Equal(t, StatusSucceeded, res.Status)
+	assert.Equal(t, 2, h.puts)
+
+	// the closed file excluded or out of the watched directory is ignored
+	w.checkClosed(path.Join(pwd, "data", "b.tmp"), now.Add(2*time.Minute))
+	w.checkClosed(path.Join(pwd, "data", "sub", "c.log"), now.Add(2*time.Minute))
+	assert.Len(t, results, 0)
+	assert.Len(t, w.files, 1)
+
+	// the file uploaded is not uploaded again after restart unless changed
+	w2, err := newWatcher(nil, cfg, "rule", w.cli, w.cb)
+	assert.NoError(t, err)
+	w2.scan(now.Add(3 * time.Minute))
+	assert.Len(t, results, 0)
+	assert.Equal(t, 2, h.puts)
+	assert.NoError(t, ioutil.WriteFile(path.Join(pwd, "data", "a.log"), []byte("aaa"), 0644))
+	w2.checkClosed(path.Join(pwd, "data", "a.log"), now.Add(3*time.Minute))
+	nextResult(t, results)
+	assert.Equal(t, 3, h.puts)
+
+	// the file removed is dropped from the index
+	assert.NoError(t, os.Remove(path.Join(pwd, "data", "a.log")))
+	w2.scan(now.Add(4 * time.Minute))
+	idx, err := loadWatchIndex(w2.file)
+	assert.NoError(t, err)
+	assert.Len(t, idx.Files, 0)
+}
+
+func TestWatcherBackoff(t *testing.T) {
+	cfg := Watch{
+		Paths:      []string{"data"},
+		RemotePath: "{{.Path}}",
+		Stable:     time.Minute,
+		After:      AfterKeep,
+	}
+	w, h, results := newMockWatcher(t, cfg)
+	w.cli.cfg.Backoff = Backoff{Base: time.Second, Delay: 3 * time.Second}
+	assert.Equal(t, time.Second, w.backoff(1))
+	assert.Equal(t, 2*time.Second, w.backoff(2))
+	assert.Equal(t, 3*time.Second, w.backoff(3))
+	assert.Equal(t, 3*time.Second, w.backoff(10))
+
+	fp := path.Join(w.cli.pwd, "data", "a.log")
+	assert.NoError(t, ioutil.WriteFile(fp, []byte("a"), 0644))
+	h.putErr = fmt.Errorf("network unreachable")
+	w.checkClosed(fp, time.Now())
+	res := nextResult(t, results)
+	assert.Equal(t, StatusFailed, res.Status)
+
+	// the file failed is not submitted again before the backoff
+	w.scan(time.Now().Add(time.Minute))
+	w.checkClosed(fp, time.Now())
+	assert.Len(t, results, 0)
+	w.scan(time.Now().Add(time.Minute + time.Second))
+	res = nextResult(t, results)
+	assert.Equal(t, StatusSucceeded, res.Status)
+	assert.Equal(t, 1, h.puts)
+}
+
+func TestWatcherResume(t *testing.T) {
+	cfg := Watch{
+		Paths:      []string{"data"},
+		RemotePath: "{{.Path}}",
+		Stable:     time.Minute,
+		After:      AfterKeep,
+	}
+	w, h, results := newMockWatcher(t, cfg)
+	w.cli.cfg.Journal = Journal{Enable: true, Path: path.Join(path.Dir(w.cli.pwd), "journal")}
+	var err error
+	w.cli.journal, err = newJournal(w.cli.cfg.Journal, "mock")
+	assert.NoError(t, err)
+
+	// the file journaled before restart is resumed once, not submitted again by scan
+	fp := path.Join(w.cli.pwd, "data", "a.log")
+	assert.NoError(t, ioutil.WriteFile(fp, []byte("a"), 0644))
+	msg := &EventMessage{Event: &Event{Time: time.Now(), Type: Upload, Content: &UploadEvent{RemotePath: "a.log", LocalPath: "data/a.log"}}}
+	_, err = w.cli.Journal("rule", msg)
+	assert.NoError(t, err)
+	assert.NoError(t, w.resume())
+	w.scan(time.Now().Add(time.Minute))
+	res := nextResult(t, results)
+	assert.Equal(t, StatusSucceeded, res.Status)
+	w.scan(time.Now().Add(2 * time.Minute))
+	assert.Len(t, results, 0)
+	assert.Equal(t, 1, h.puts)
+}
+
+func TestWatcherAfter(t *testing.T) {
+	cfg := Watch{
+		Paths:      []string{"data"},
+		Recursive:  true,
+		RemotePath: "{{.Path}}",
+		After:      AfterMove,
+		MoveTo:     "done",
+	}
+	w, h, results := newMockWatcher(t, cfg)
+	pwd := w.cli.pwd
+	assert.NoError(t, ioutil.WriteFile(path.Join(pwd, "data", "sub", "c.log"), []byte("c"), 0644))
+	w.scan(time.Now())
+	res := nextResult(t, results)
+	assert.Equal(t, "sub/c.log", res.RemotePath)
+	assert.True(t, utils.FileExists(path.Join(h.dir, "bucket", "sub/c.log")))
+	assert.False(t, utils.FileExists(path.Join(pwd, "data", "sub", "c.log")))
+	assert.True(t, utils.FileExists(path.Join(pwd, "done", "sub", "c.log")))
+
+	w.cfg.After = AfterDelete
+	assert.NoError(t, ioutil.WriteFile(path.Join(pwd, "data", "d.log"), []byte("d"), 0644))
+	w.scan(time.Now())
+	res = nextResult(t, results)
+	assert.Equal(t, "d.log", res.RemotePath)
+	assert.False(t, utils.FileExists(path.Join(pwd, "data", "d.log")))
+
+	_, err := newWatcher(nil, Watch{Paths: []string{"../data"}}, "rule", w.cli, nil)
+	assert.Error(t, err)
+	_, err = newWatcher(nil, Watch{Paths: []string{"data"}, After: AfterMove}, "rule", w.cli, nil)
+	assert.Error(t, err)
+	_, err = newWatcher(nil, Watch{Paths: []string{"data"}, After: "copy"}, "rule", w.cli, nil)
+	assert.Error(t, err)
+	_, err = newWatcher(nil, Watch{Paths: []string{"data"}, After: AfterKeep, RemotePath: "{{.Path"}, "rule", w.cli, nil)
+	assert.Error(t, err)
+}
+
+func TestWatcherNotify(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("inotify is only supported on linux")
+	}
+	cfg := Watch{
+		Paths:      []string{"data"},
+		RemotePath: "{{.Path}}",
+		Stable:     time.Hour,
+		Interval:   time.Hour,
+		After:      AfterKeep,
+	}
+	w, _, results := newMockWatcher(t, cfg)
+	assert.NotNil(t, w.notifier)
+	assert.NoError(t, w.start())
+	defer w.close()
+	// wait for the first scan adding watches
+	time.Sleep(100 * time.Millisecond)
+
+	// uploaded once closed without waiting to be stable
+	assert.NoError(t, ioutil.WriteFile(path.Join(w.cli.pwd, "data", "e.log"), []byte("e"), 0644))
+	res := nextResult(t, results)
+	assert.Equal(t, StatusSucceeded, res.Status)
+	assert.Equal(t, "e.log", res.RemotePath)
+}
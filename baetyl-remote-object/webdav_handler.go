@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	v1 "github.com/baetyl/baetyl-go/v2/spec/v1"
+	"github.com/baetyl/baetyl-go/v2/utils"
+)
+
+// WebDAVHandler stores objects in the collection of webdav server, the object is stored in <endpoint>/<bucket>/<remotePath>
+// and its meta in the sidecar <endpoint>/<bucket>/<remotePath>.meta.json
+type WebDAVHandler struct {
+	rest       *restClient
+	endpoint   *url.URL
+	basic      bool        // basic auth is challenged
+	digest     *digestAuth // the challenge of digest auth
+	dirs       sync.Map    // the collections created
+	cfg        ClientInfo
+	throttlers []*throttler
+	log        *log.Logger
+	lock       sync.Mutex
+}
+
+// NewWebDAVHandler creates a new webdav handler, ak and sk are the user and password of basic or digest auth
+// which is chosen by the challenge of server, so the password is never sent in plain text if digest auth is required,
+// the tls config is used for https endpoint
+func NewWebDAVHandler(cfg ClientInfo) (StorageHandler, error) {
+	u, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, errors.Errorf("failed to create webdav client (%s): endpoint (%s) invalid", cfg.Name, cfg.Endpoint)
+	}
+	rest, err := newRESTClient(cfg)
+	if err != nil {
+		return nil, errors.Errorf("failed to create webdav client (%s): %s", cfg.Name, err.Error())
+	}
+	return &WebDAVHandler{
+		rest:       rest,
+		endpoint:   u,
+		cfg:        cfg,
+		throttlers: []*throttler{newThrottler(cfg.Throttle), globalThrottler},
+		log:        log.With(log.Any("storage", "webdav")),
+	}, nil
+}
+
+// PutObjectFromFile uploads file and its sidecar meta, the collections are created as needed, returns the md5 in hex as etag
+func (cli *WebDAVHandler) PutObjectFromFile(Bucket, remotePath, filename string, meta map[string]string) (string, error) {
+	object, err := objectPath(Bucket, remotePath)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	md5, err := utils.CalculateFileMD5(filename)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if err = cli.mkdirAll(path.Dir(object)); err != nil {
+		return "", errors.Trace(err)
+	}
+	_, err = cli.send(http.MethodPut, object, func() io.Reader {
+		return throttled(io.NewSectionReader(f, 0, fi.Size()), cli.throttlers...)
+	}, fi.Size())
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	data, err := json.Marshal(&FileMeta{
+		Size:         fi.Size(),
+		MD5:          md5,
+		Meta:         meta,
+		LastModified: time.Now(),
+	})
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	_, err = cli.send(http.MethodPut, object+sidecarSuffix, func() io.Reader {
+		return bytes.NewReader(data)
+	}, int64(len(data)))
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return md5, nil
+}
+
+// GetObjectToFile download file
+func (cli *WebDAVHandler) GetObjectToFile(Bucket, remotePath, filename string) error {
+	object, err := objectPath(Bucket, remotePath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	res, err := cli.do(http.MethodGet, object, nil, 0)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer res.Body.Close()
+	f, err := os.OpenFile(filename, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+	_, err = io.Copy(f, res.Body)
+	return errors.Trace(err)
+}
+
+// DeleteObject removes object and its sidecar meta
+func (cli *WebDAVHandler) DeleteObject(Bucket, remotePath string) error {
+	object, err := objectPath(Bucket, remotePath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, p := range []string{object, object + sidecarSuffix} {
+		if _, err = cli.send(http.MethodDelete, p, nil, 0); err != nil && !isNotFound(err) {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// FileExists reports whether the object exists in the size and md5 stored in its sidecar meta
+func (cli *WebDAVHandler) FileExists(Bucket, remotePath, md5 string) bool {
+	object, err := objectPath(Bucket, remotePath)
+	if err != nil {
+		return false
+	}
+	ok, err := func() (bool, error) {
+		header, err := cli.send(http.MethodHead, object, nil, 0)
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		res, err := cli.do(http.MethodGet, object+sidecarSuffix, nil, 0)
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		defer res.Body.Close()
+		var fm FileMeta
+		if err = json.NewDecoder(res.Body).Decode(&fm); err != nil {
+			return false, errors.Trace(err)
+		}
+		return fm.matches(size, md5), nil
+	}()
+	if err != nil && !isNotFound(err) {
+		cli.log.Warn("failed to get object meta", log.Error(err))
+	}
+	return ok
+}
+
+func (cli *WebDAVHandler) RefreshSts() (*v1.STSResponse, error) {
+	return nil, nil
+}
+
+// mkdirAll creates the collection along with any necessary parents
+func (cli *WebDAVHandler) mkdirAll(dir string) error {
+	if dir == "." || dir == "/" || dir == "" {
+		return nil
+	}
+	if _, ok := cli.dirs.Load(dir); ok {
+		return nil
+	}
+	if err := cli.mkdirAll(path.Dir(dir)); err != nil {
+		return errors.Trace(err)
+	}
+	_, err := cli.send("MKCOL", dir+"/", nil, 0)
+	if err != nil {
+		// the collection exists
+		if e, ok := errors.Cause(err).(*restError); !ok || e.Status != http.StatusMethodNotAllowed {
+			return errors.Trace(err)
+		}
+	}
+	cli.dirs.Store(dir, true)
+	return nil
+}
+
+// send sends the request and discards the body of response, returns the header of response
+func (cli *WebDAVHandler) send(method, p string, body func() io.Reader, size int64) (http.Header, error) {
+	res, err := cli.do(method, p, body, size)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	return res.Header, nil
+}
+
+// do sends the request with auth, the request is sent again with the challenge of digest auth if unauthorized,
+// so the body is created by the function each time
+func (cli *WebDAVHandler) do(method, p string, body func() io.Reader, size int64) (*http.Response, error) {
+	for retried := false; ; retried = true {
+		u := *cli.endpoint
+		u.Path = u.Path + "/" + p
+		var r io.Reader = http.NoBody
+		if body != nil && size > 0 {
+			r = ioutil.NopCloser(body())
+		}
+		req, err := http.NewRequest(method, u.String(), r)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		req.ContentLength = size
+		cli.auth(req)
+		if retried {
+			return cli.rest.do(req)
+		}
+		res, err := cli.rest.do(req, http.StatusUnauthorized)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if res.StatusCode != http.StatusUnauthorized {
+			return res, nil
+		}
+		res.Body.Close()
+		if !cli.challenge(res.Header.Get("WWW-Authenticate")) {
+			return nil, errors.Trace(&restError{Method: method, URL: u.String(), Status: res.StatusCode, Message: res.Status})
+		}
+	}
+}
+
+// auth sets the authorization of request by the scheme challenged, nothing is set before challenged
+func (cli *WebDAVHandler) auth(req *http.Request) {
+	cli.lock.Lock()
+	basic, d := cli.basic, cli.digest
+	cli.lock.Unlock()
+	if d != nil {
+		req.Header.Set("Authorization", d.authorize(req.Method, req.URL.RequestURI(), cli.cfg.Ak, cli.cfg.Sk))
+	} else if basic {
+		req.SetBasicAuth(cli.cfg.Ak, cli.cfg.Sk)
+	}
+}
+
+// challenge keeps the scheme of auth challenged, returns false if the scheme is not supported
+func (cli *WebDAVHandler) challenge(header string) bool {
+	if cli.cfg.Ak == "" {
+		return false
+	}
+	cli.lock.Lock()
+	defer cli.lock.Unlock()
+	if d := parseDigestAuth(header); d != nil {
+		cli.digest = d
+		return true
+	}
+	if strings.HasPrefix(strings.ToLower(header), "basic") && cli.digest == nil {
+		cli.basic = true
+		return true
+	}
+	return false
+}
+
+// digestAuth the challenge of http digest auth in md5, see https://datatracker.ietf.org/doc/html/rfc2617
+type digestAuth struct {
+	realm  string
+	nonce  string
+	opaque string
+	qop    string // auth if supported by server, otherwise empty
+	nc     uint32
+	lock   sync.Mutex
+}
+
+func parseDigestAuth(header string) *digestAuth {
+	if !strings.HasPrefix(strings.ToLower(header), "digest ") {
+		return nil
+	}
+	params := map[string]string{}
+	for _, kv := range strings.Split(header[len("digest "):], ",") {
+		parts := strings.SplitN(strings.TrimSpace(kv), "=", 2)
+		if len(parts) == 2 {
+			params[strings.ToLower(parts[0])] = strings.Trim(parts[1], "\"")
+		}
+	}
+	if alg := params["algorithm"]; alg != "" && !strings.EqualFold(alg, "MD5") {
+		return nil
+	}
+	d := &digestAuth{realm: params["realm"], nonce: params["nonce"], opaque: params["opaque"]}
+	for _, q := range strings.Split(params["qop"], ",") {
+		if strings.TrimSpace(q) == "auth" {
+			d.qop = "auth"
+		}
+	}
+	return d
+}
+
+// authorize returns the authorization of the request
+func (d *digestAuth) authorize(method, uri, user, password string) string {
+	ha1 := md5Hex(user + ":" + d.realm + ":" + password)
+	ha2 := md5Hex(method + ":" + uri)
+	s := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`, user, d.realm, d.nonce, uri)
+	if d.qop == "" {
+		s += fmt.Sprintf(`, response="%s"`, md5Hex(ha1+":"+d.nonce+":"+ha2))
+	} else {
+		d.lock.Lock()
+		d.nc++
+		nc := fmt.Sprintf("%08x", d.nc)
+		d.lock.Unlock()
+		b := make([]byte, 8)
+		rand.Read(b)
+		cnonce := hex.EncodeToString(b)
+		response := md5Hex(ha1 + ":" + d.nonce + ":" + nc + ":" + cnonce + ":" + d.qop + ":" + ha2)
+		s += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s", response="%s"`, d.qop, nc, cnonce, response)
+	}
+	if d.opaque != "" {
+		s += fmt.Sprintf(`, opaque="%s"`, d.opaque)
+	}
+	return s + ", algorithm=MD5"
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"regexp"
+	"testing"
+
+	"github.com/baetyl/baetyl-go/v2/utils"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/webdav"
+)
+
+// mockDigest checks the digest auth of user:pass with qop auth
+func mockDigest(next http.Handler) http.Handler {
+	field := func(h, k string) string {
+		m := regexp.MustCompile(k + `="?([^",]*)"?`).FindStringSubmatch(h)
+		if m == nil {
+			return ""
+		}
+		return m[1]
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := r.Header.Get("Authorization")
+		ha1 := md5Hex("user:dav:pass")
+		ha2 := md5Hex(r.Method + ":" + field(h, "uri"))
+		expected := md5Hex(fmt.Sprintf("%s:nonce:%s:%s:auth:%s", ha1, field(h, "nc"), field(h, "cnonce"), ha2))
+		if field(h, "response") != expected || field(h, "uri") != r.URL.RequestURI() {
+			w.Header().Set("WWW-Authenticate", `Digest realm="dav", nonce="nonce", qop="auth", algorithm=MD5`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// mockBasic checks the basic auth of user:pass
+func mockBasic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != "user" || p != "pass" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="dav"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func TestWebDAVHandler(t *testing.T) {
+	for name, auth := range map[string]func(http.Handler) http.Handler{"basic": mockBasic, "digest": mockDigest} {
+		t.Run(name, func(t *testing.T) {
+			fs := webdav.NewMemFS()
+			s := httptest.NewServer(auth(&webdav.Handler{Prefix: "/dav", FileSystem: fs, LockSystem: webdav.NewMemLS()}))
+			defer s.Close()
+
+			c := *cfg
+			c.Kind = WebDAV
+			c.Endpoint = s.URL + "/dav"
+			c.Ak = "user"
+			c.Sk = "pass"
+			h, err := NewObjectStorageHandler(nil, c)
+			assert.NoError(t, err)
+
+			dir := t.TempDir()
+			small := path.Join(dir, "small.txt")
+			assert.NoError(t, ioutil.WriteFile(small, []byte("abc"), 0644))
+			sum, err := utils.CalculateFileMD5(small)
+			assert.NoError(t, err)
+
+			// round 1: put object in the collections created
+			assert.False(t, h.FileExists("bucket", "a/b/small.txt", sum))
+			etag, err := h.PutObjectFromFile("bucket", "a/b/small.txt", small, map[string]string{"k": "v"})
+			assert.NoError(t, err)
+			assert.Equal(t, sum, etag)
+			fi, err := fs.Stat(context.Background(), "/bucket/a/b/small.txt"+sidecarSuffix)
+			assert.NoError(t, err)
+			assert.NotZero(t, fi.Size())
+			assert.True(t, h.FileExists("bucket", "a/b/small.txt", sum))
+			assert.False(t, h.FileExists("bucket", "a/b/small.txt", "0123"))
+			_, err = h.PutObjectFromFile("bucket", "a/c.txt", small, nil)
+			assert.NoError(t, err)
+
+			// round 2: get and delete object
+			dst := path.Join(dir, "dst.txt")
+			assert.NoError(t, h.GetObjectToFile("bucket", "a/b/small.txt", dst))
+			data, err := ioutil.ReadFile(dst)
+			assert.NoError(t, err)
+			assert.Equal(t, "abc", string(data))
+			assert.Error(t, h.GetObjectToFile("bucket", "a/none.txt", dst))
+			assert.NoError(t, h.DeleteObject("bucket", "a/b/small.txt"))
+			assert.NoError(t, h.DeleteObject("bucket", "a/b/small.txt"))
+			assert.False(t, h.FileExists("bucket", "a/b/small.txt", sum))
+
+			// round 3: wrong password
+			c.Sk = "wrong"
+			h, err = NewObjectStorageHandler(nil, c)
+			assert.NoError(t, err)
+			_, err = h.PutObjectFromFile("bucket", "a/small.txt", small, nil)
+			assert.Error(t, err)
+		})
+	}
+
+	c := *cfg
+	c.Kind = WebDAV
+	c.Endpoint = "ftp://127.0.0.1/dav"
+	_, err := NewObjectStorageHandler(nil, c)
+	assert.Error(t, err)
+}
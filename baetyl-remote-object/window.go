@@ -0,0 +1,71 @@
+package main
+
+import (
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+)
+
+// Window time window of day in local time, such as 09:00-18:00,
+// the window crosses midnight if the end is before the start, such as 22:00-06:00
+type Window struct {
+	Start string `yaml:"start" json:"start"`
+	End   string `yaml:"end" json:"end"`
+}
+
+func (w Window) validate() error {
+	if _, err := time.Parse("15:04", w.Start); err != nil {
+		return errors.Errorf("start (%s) of window invalid, should be like 09:00", w.Start)
+	}
+	if _, err := time.Parse("15:04", w.End); err != nil {
+		return errors.Errorf("end (%s) of window invalid, should be like 18:00", w.End)
+	}
+	return nil
+}
+
+// next returns the first time not before t in any window, t itself if no window
+func (s Schedule) next(t time.Time) time.Time {
+	if len(s.Windows) == 0 {
+		return t
+	}
+	var next time.Time
+	for _, w := range s.Windows {
+		if w.contains(t) {
+			return t
+		}
+		start, err := time.Parse("15:04", w.Start)
+		if err != nil {
+			continue
+		}
+		n := time.Date(t.Year(), t.Month(), t.Day(), start.Hour(), start.Minute(), 0, 0, t.Location())
+		if !n.After(t) {
+			n = n.AddDate(0, 0, 1)
+		}
+		if next.IsZero() || n.Before(next) {
+			next = n
+		}
+	}
+	if next.IsZero() {
+		return t
+	}
+	return next
+}
+
+// contains reports whether the time is in the window
+func (w Window) contains(t time.Time) bool {
+	start, err := time.Parse("15:04", w.Start)
+	if err != nil {
+		return false
+	}
+	end, err := time.Parse("15:04", w.End)
+	if err != nil {
+		return false
+	}
+	s := start.Hour()*60 + start.Minute()
+	e := end.Hour()*60 + end.Minute()
+	m := t.Hour()*60 + t.Minute()
+	if s <= e {
+		return s <= m && m < e
+	}
+	return m >= s || m < e
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindow(t *testing.T) {
+	at := func(clock string) time.Time {
+		c, err := time.Parse("15:04", clock)
+		assert.NoError(t, err)
+		return time.Date(2020, 1, 1, c.Hour(), c.Minute(), 0, 0, time.Local)
+	}
+
+	w := Window{Start: "09:00", End: "18:00"}
+	assert.NoError(t, w.validate())
+	assert.False(t, w.contains(at("08:59")))
+	assert.True(t, w.contains(at("09:00")))
+	assert.True(t, w.contains(at("17:59")))
+	assert.False(t, w.contains(at("18:00")))
+
+	// across midnight
+	w = Window{Start: "22:00", End: "06:00"}
+	assert.NoError(t, w.validate())
+	assert.True(t, w.contains(at("23:00")))
+	assert.True(t, w.contains(at("00:00")))
+	assert.True(t, w.contains(at("05:59")))
+	assert.False(t, w.contains(at("06:00")))
+	assert.False(t, w.contains(at("12:00")))
+
+	w = Window{Start: "9", End: "18:00"}
+	assert.Error(t, w.validate())
+	assert.False(t, w.contains(at("12:00")))
+	w = Window{Start: "09:00", End: "25:00"}
+	assert.Error(t, w.validate())
+}
+
+func TestScheduleNext(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.Local)
+	assert.Equal(t, now, Schedule{}.next(now))
+
+	s := Schedule{Windows: []Window{{Start: "22:00", End: "06:00"}, {Start: "13:00", End: "14:00"}}}
+	assert.Equal(t, time.Date(2020, 1, 1, 13, 0, 0, 0, time.Local), s.next(now))
+	assert.Equal(t, time.Date(2020, 1, 1, 22, 0, 0, 0, time.Local), s.next(now.Add(2*time.Hour)))
+	night := time.Date(2020, 1, 1, 23, 0, 0, 0, time.Local)
+	assert.Equal(t, night, s.next(night))
+
+	s = Schedule{Windows: []Window{{Start: "09:00", End: "10:00"}}}
+	assert.Equal(t, time.Date(2020, 1, 2, 9, 0, 0, 0, time.Local), s.next(now))
+}